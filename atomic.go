@@ -0,0 +1,114 @@
+package bloom
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// AtomicBitsetStorage is a lock free Bitset backend, safe for concurrent use. Bits are set with an atomic
+// compare and swap loop on their word as soon as they're appended, so there's no queue and Save doesn't
+// do anything. Values are therefore visible to Exists before Save is called.
+type AtomicBitsetStorage struct {
+	words []uint64
+	size  uint
+}
+
+// NewAtomicBitsetStorage creates a lock free Bitset backend storage to be used with the bloom filter,
+// holding the bits of a single partition.
+func NewAtomicBitsetStorage(partitionBits uint) *AtomicBitsetStorage {
+	return &AtomicBitsetStorage{make([]uint64, (partitionBits+63)/64), partitionBits}
+}
+
+// NewBitsetAtomic creates and returns a new bloom filter using the lock free Bitset backend, which can be
+// added to and checked from many goroutines at once. Every appended bit costs an atomic compare and swap
+// instead of a queue append, which is small next to hashing the value, so single threaded adds cost about
+// the same as with NewBitset. Concurrent adds don't contend on a lock like with NewBitset WithConcurrency,
+// although goroutines setting bits in the same word retry their compare and swap. BenchmarkBitsetAtomic*
+// compares them. It panics if size or hashIter is zero, or with ErrTooLarge like NewBitset.
+func NewBitsetAtomic(size, hashIter uint, opts ...Option) *BF {
+	if err := validateParameters(size, hashIter); err != nil {
+		panic(err)
+	}
+
+	o := newOptions(opts)
+	filters := filterSetup(size, hashIter, o)
+	if err := checkMemory(filters, o); err != nil {
+		panic(err)
+	}
+
+	for index, filter := range filters {
+		if filter.partition != index {
+			filter.storage = filters[filter.partition].storage
+		} else {
+			filter.storage = NewAtomicBitsetStorage(filter.partitionBits)
+		}
+		filters[index] = filter
+	}
+
+	return &BF{filters, o.observer, o.saves, o.capacityWatch()}
+}
+
+// Append sets the bit right away, using an atomic compare and swap on its word.
+func (s *AtomicBitsetStorage) Append(bit uint) {
+	word, mask := &s.words[bit/64], uint64(1)<<(bit%64)
+	for {
+		old := atomic.LoadUint64(word)
+		if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+			return
+		}
+	}
+}
+
+// Save doesn't do anything, since the bits are set when they're appended.
+func (s *AtomicBitsetStorage) Save() error {
+	return nil
+}
+
+// Exists checks if the given bit is set, using an atomic load of its word.
+func (s *AtomicBitsetStorage) Exists(bit uint) (bool, error) {
+	return atomic.LoadUint64(&s.words[bit/64])&(uint64(1)<<(bit%64)) != 0, nil
+}
+
+// ExistsMany checks if each of the given bits is set.
+func (s *AtomicBitsetStorage) ExistsMany(bits []uint) ([]bool, error) {
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		ret[i], _ = s.Exists(bit)
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set. Bits set while counting might not be included.
+func (s *AtomicBitsetStorage) Count() (uint, error) {
+	var count int
+	for i := range s.words {
+		count += bits.OnesCount64(atomic.LoadUint64(&s.words[i]))
+	}
+
+	return uint(count), nil
+}
+
+// Clear unsets every bit, one word at a time. Bits set while clearing might be kept.
+func (s *AtomicBitsetStorage) Clear() error {
+	for i := range s.words {
+		atomic.StoreUint64(&s.words[i], 0)
+	}
+
+	return nil
+}
+
+// backend names the lock free Bitset backend in Config.
+func (s *AtomicBitsetStorage) backend() string {
+	return "atomic"
+}
+
+// clone copies the words into a new lock free Bitset backend.
+func (s *AtomicBitsetStorage) clone(string) (storage, error) {
+	words := make([]uint64, len(s.words))
+	for i := range s.words {
+		words[i] = atomic.LoadUint64(&s.words[i])
+	}
+
+	return &AtomicBitsetStorage{words, s.size}, nil
+}
@@ -0,0 +1,164 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// BatchSaver saves the queues of many Redis backed bloom filters over a single connection, for
+// applications adding to a lot of small filters at once, like a filter per tenant, where a Save per filter
+// would take a connection and a round trip each. The filters are added with Add and saved by Flush, which
+// pipelines the transaction of every partition into a single round trip. The filters don't need to share
+// a pool with the BatchSaver, but their keys have to be on the Redis server of its pool. A BatchSaver isn't
+// safe for concurrent use.
+type BatchSaver struct {
+	conns   *redisConns
+	filters []*BF
+}
+
+// NewBatchSaver creates a BatchSaver getting its connection from the pool.
+func NewBatchSaver(pool *redis.Pool) *BatchSaver {
+	return &BatchSaver{poolConns(pool), nil}
+}
+
+// NewBatchSaverWithConn is like NewBatchSaver, but gets its connection from the factory.
+func NewBatchSaverWithConn(factory func() Conn) *BatchSaver {
+	return &BatchSaver{factoryConns(factory), nil}
+}
+
+// Add adds the bloom filter to the ones saved by the next Flush. Adding a filter more than once saves it
+// once.
+func (s *BatchSaver) Add(bf *BF) {
+	s.filters = append(s.filters, bf)
+}
+
+// Flush saves the queues of the bloom filters added since the last Flush, like Save: every partition is
+// saved in its own MULTI/EXEC transaction, so other clients never see a partially saved partition, but
+// all the transactions are sent over a single connection and read in a single round trip. Partitions that
+// aren't backed by Redis are saved with their own Save first, and their first error is returned before
+// any transaction is sent. The queue of a partition is kept if its transaction fails, in which case the
+// first error is returned once every reply has been read, and the filters are kept for the next Flush.
+func (s *BatchSaver) Flush() error {
+	return s.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but returns ctx.Err() if the context is done before all the replies have
+// been read.
+func (s *BatchSaver) FlushContext(ctx context.Context) error {
+	// The partitions that aren't backed by Redis are saved first, so a failure doesn't drop the queues
+	// already taken from the Redis ones.
+	seen := make(map[storage]bool)
+	for _, bf := range s.filters {
+		for _, f := range bf.partitions() {
+			if _, ok := f.storage.(*RedisStorage); ok || seen[f.storage] {
+				continue
+			}
+			seen[f.storage] = true
+
+			if err := saveContext(ctx, f.storage); err != nil {
+				return err
+			}
+		}
+		if err := syncPartitions(bf.partitions()); err != nil {
+			return err
+		}
+	}
+
+	var stores []*RedisStorage
+	var queues [][]uint
+	for _, bf := range s.filters {
+		for _, f := range bf.partitions() {
+			store, ok := f.storage.(*RedisStorage)
+			if !ok || seen[f.storage] {
+				continue
+			}
+			seen[f.storage] = true
+
+			if bits := store.drain(); len(bits) > 0 {
+				stores = append(stores, store)
+				queues = append(queues, bits)
+			}
+		}
+	}
+
+	if err := s.save(ctx, stores, queues); err != nil {
+		return err
+	}
+
+	for _, bf := range s.filters {
+		bf.observeFill(ctx)
+		bf.checkCapacity(ctx)
+	}
+	s.filters = s.filters[:0]
+	return nil
+}
+
+// save pipelines the transactions saving the queues taken from the Redis backends, putting the queues of
+// the ones that fail back.
+func (s *BatchSaver) save(ctx context.Context, stores []*RedisStorage, queues [][]uint) (err error) {
+	if len(stores) == 0 {
+		return nil
+	}
+
+	saved := make([]bool, len(stores))
+	defer func() {
+		for index, store := range stores {
+			if !saved[index] {
+				store.requeue(queues[index])
+			}
+		}
+	}()
+
+	conn, err := s.conns.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	commands := make([]int, len(stores))
+	for index, store := range stores {
+		if commands[index], err = store.sendSave(conn, queues[index]); err != nil {
+			return err
+		}
+		if err := conn.Send("EXEC"); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	var failed error
+	for index := range stores {
+		// The replies are the ones of MULTI and the queued commands, followed by the one of EXEC.
+		var storeErr error
+		for i := 0; i <= commands[index]; i++ {
+			reply, err := receiveContext(ctx, conn)
+			if _, ok := err.(redis.Error); err != nil && !ok {
+				return err
+			}
+			if err == nil && i == commands[index] {
+				err = execError(redis.Values(reply, nil))
+			}
+			if storeErr == nil {
+				storeErr = err
+			}
+		}
+		if storeErr != nil {
+			if failed == nil {
+				failed = storeErr
+			}
+			continue
+		}
+		saved[index] = true
+	}
+
+	return failed
+}
@@ -2,36 +2,154 @@ package bloom
 
 import (
 	"github.com/willf/bitset"
+	"sync"
 )
 
 // BitsetStorage is a struct representing the Bitset backend for the bloom filter.
+// It's not safe for concurrent use unless it was created with NewConcurrentBitsetStorage.
+// The bits set are counted as they flip from 0 to 1, so counting them for EstimatedItemCount, Stats and
+// capacity checks doesn't scan the Bitset.
 type BitsetStorage struct {
 	store *bitset.BitSet
 	queue []uint
 	size  uint
+	mu    *sync.RWMutex
+	set   uint
 }
 
-// NewBitsetStorage creates a Bitset backend storage to be used with the bloom filter.
-func NewBitsetStorage(size uint) *BitsetStorage {
+// NewBitsetStorage creates a Bitset backend storage to be used with the bloom filter, holding the bits of
+// a single partition.
+func NewBitsetStorage(partitionBits uint) *BitsetStorage {
 	b := make([]uint, 0)
-	return &BitsetStorage{bitset.New(size), b, size}
+	return &BitsetStorage{bitset.New(partitionBits), b, partitionBits, nil, 0}
+}
+
+// NewConcurrentBitsetStorage creates a Bitset backend storage which is safe for concurrent use. Reading
+// bits takes a read lock while appending, saving and clearing bits take a write lock.
+func NewConcurrentBitsetStorage(partitionBits uint) *BitsetStorage {
+	s := NewBitsetStorage(partitionBits)
+	s.mu = new(sync.RWMutex)
+
+	return s
 }
 
 // Append appends the bit, which is to be saved, to the queue.
 func (s *BitsetStorage) Append(bit uint) {
+	s.lock()
+	defer s.unlock()
+
 	s.queue = append(s.queue, bit)
 }
 
 // Save pushes the bits from the queue to the storage backend, assigning the value 1 in the process.
-func (s *BitsetStorage) Save() {
+func (s *BitsetStorage) Save() error {
+	s.lock()
+	defer s.unlock()
+
 	for _, bit := range s.queue {
-		s.store.Set(bit)
+		if !s.store.Test(bit) {
+			s.store.Set(bit)
+			s.set++
+		}
 	}
+	s.queue = s.queue[:0]
+
+	return nil
 }
 
 // Exists checks if the given bit exists in the Bitset backend.
 func (s *BitsetStorage) Exists(bit uint) (ret bool, err error) {
+	s.rlock()
+	defer s.runlock()
+
 	ret = s.store.Test(bit)
 
 	return
 }
+
+// ExistsMany checks if each of the given bits exists in the Bitset backend.
+func (s *BitsetStorage) ExistsMany(bits []uint) ([]bool, error) {
+	s.rlock()
+	defer s.runlock()
+
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		ret[i] = s.store.Test(bit)
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set in the Bitset backend, which is counted as the bits are saved.
+func (s *BitsetStorage) Count() (uint, error) {
+	s.rlock()
+	defer s.runlock()
+
+	return s.set, nil
+}
+
+// Clear unsets every bit in the Bitset backend and empties the queue.
+func (s *BitsetStorage) Clear() error {
+	s.lock()
+	defer s.unlock()
+
+	s.store.ClearAll()
+	s.queue = s.queue[:0]
+	s.set = 0
+
+	return nil
+}
+
+// discard empties the queue without setting its bits.
+func (s *BitsetStorage) discard() {
+	s.lock()
+	defer s.unlock()
+
+	s.queue = s.queue[:0]
+}
+
+// backend names the Bitset backend in Config.
+func (s *BitsetStorage) backend() string {
+	return "bitset"
+}
+
+// clone copies the bits and the queue into a new Bitset backend, which is concurrent if this one is.
+func (s *BitsetStorage) clone(string) (storage, error) {
+	s.rlock()
+	defer s.runlock()
+
+	c := &BitsetStorage{s.store.Clone(), append([]uint(nil), s.queue...), s.size, nil, s.set}
+	if s.mu != nil {
+		c.mu = new(sync.RWMutex)
+	}
+
+	return c, nil
+}
+
+// lock takes the write lock of a concurrent Bitset backend.
+func (s *BitsetStorage) lock() {
+	if s.mu != nil {
+		s.mu.Lock()
+	}
+}
+
+// unlock releases the write lock of a concurrent Bitset backend.
+func (s *BitsetStorage) unlock() {
+	if s.mu != nil {
+		s.mu.Unlock()
+	}
+}
+
+// rlock takes the read lock of a concurrent Bitset backend.
+func (s *BitsetStorage) rlock() {
+	if s.mu != nil {
+		s.mu.RLock()
+	}
+}
+
+// runlock releases the read lock of a concurrent Bitset backend.
+func (s *BitsetStorage) runlock() {
+	if s.mu != nil {
+		s.mu.RUnlock()
+	}
+}
@@ -1,175 +1,1108 @@
 /*
 Bloom filter with Bitset and Redis backend support.
 
+The Redis backend can be left out of the build with the noredis build tag, for programs that only use
+the in-memory backends and don't want to compile github.com/gomodule/redigo.
+
 Speeding things up with:
 
 - Partitioned bloom filters
 
 - Utilizing the same cheap hash function every time, but still with good results (http://www.eecs.harvard.edu/~kirsch/pubs/bbbf/rsa.pdf)
+
+The size given to the bloom filter constructors is the total number of bits (m) of the filter, like the
+one returned by OptimalM, which is split between the partitions: NewBitset(1000, 4) has 4 partitions of
+250 bits. The storages, like NewBitsetStorage, are created with the number of bits of a single partition.
 */
 package bloom
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"github.com/gomodule/redigo/redis"
 	"hash"
 	"hash/fnv"
 	"math"
+	"math/bits"
 	"sync"
 )
 
+// ErrSaturated is returned by EstimatedItemCount when every bit of the bloom filter is set, in which case
+// the number of values can't be estimated.
+var ErrSaturated = errors.New("bloom: filter is saturated")
+
+// ErrUnsupportedBackend is returned when an operation isn't supported by the bloom filter backend.
+var ErrUnsupportedBackend = errors.New("bloom: operation isn't supported by the backend")
+
+// ErrInvalidParameters is returned when a bloom filter is created with a zero size or zero hash iterations.
+var ErrInvalidParameters = errors.New("bloom: size and hash iterations must be positive")
+
+// ErrTooLarge is returned when a bloom filter would allocate more memory than allowed WithMaxBytes.
+var ErrTooLarge = errors.New("bloom: filter is too large")
+
+// ErrDestroyed is returned by the operations of a bloom filter once it's destroyed with Destroy.
+var ErrDestroyed = errors.New("bloom: filter is destroyed")
+
+// ErrReadOnly is returned when values are saved to, or cleared from, a bloom filter opened read-only with
+// OpenBitsetFile.
+var ErrReadOnly = errors.New("bloom: filter is read-only")
+
+// ErrSizeMismatch is returned when an existing Redis key, Memcached item or bbolt value doesn't hold as
+// many bytes as the bits of the filter need, like one created for a different size.
+var ErrSizeMismatch = errors.New("bloom: stored size doesn't match the filter")
+
+// defaultSaveConcurrency is the number of partitions saved at the same time unless set
+// WithSaveConcurrency.
+const defaultSaveConcurrency = 8
+
+// maxHashIter caps the number of hash iterations chosen by NewBitsetWithEstimate.
+const maxHashIter = 32
+
 type Value []byte
 
 // BF holds all the storage filters.
 type BF struct {
-	filters []filter
+	filters  []filter
+	observer Observer
+	saves    int
+	capacity *capacityWatch
 }
 
 // filter represents each and every storage filter. Each hash iteration (k) = 1 storage filter.
+// Filter k sets its bits in partition k % p, so with fewer partitions (p) than hash iterations several
+// filters share the storage of a partition. The first p filters hold the storages of the partitions.
+// partitionBits is the number of bits of the partition, whose storage is created with that size; the
+// size of the bloom filter is the sum of the partitionBits of its partitions.
 type filter struct {
-	size       uint
-	storage    storage
-	hasher     hash.Hash64
-	multiplier uint
+	partitionBits uint
+	storage       storage
+	hasher        func() hash.Hash64
+	multiplier    uint
+	seed          uint64
+	scheme        hashScheme
+	extraction    hashExtraction
+	partition     int
 }
 
+// hashScheme selects how the two hash values of a value are combined into the bit of a partition filter.
+type hashScheme uint8
+
+const (
+	// doubleHashing sets bit a + b*i of partition i.
+	doubleHashing hashScheme = iota
+	// enhancedDoubleHashing sets bit a + b*i + (i^3-i)/6 of partition i.
+	enhancedDoubleHashing
+	// mixedHashing sets bit splitmix64(h + i*gamma) of partition i, where h is the 64 bit hash.
+	mixedHashing
+)
+
+// hashExtraction selects the bytes of the 8 byte hash sum the two hash values are read from, as 32 bit
+// integers starting at offsets a and b, in little endian order if set. defaultExtraction reads a from the
+// first half of the sum and b from the second one, as big endian integers.
+type hashExtraction struct {
+	littleEndian bool
+	a, b         uint8
+}
+
+// defaultExtraction is the hashExtraction of the filters not created WithHashExtraction.
+var defaultExtraction = hashExtraction{false, 0, 4}
+
+// valid reports whether the offsets read 4 bytes from different places of the hash sum.
+func (e hashExtraction) valid() bool {
+	return e.a <= 4 && e.b <= 4 && e.a != e.b
+}
+
+// splitmixGamma is the increment of the splitmix64 generator, the odd integer closest to 2^64 divided by
+// the golden ratio.
+const splitmixGamma = 0x9e3779b97f4a7c15
+
 // NewBitset creates and returns a new bloom filter using Bitset as a backend.
-func NewBitset(size, hashIter uint) *BF {
-	filters := filterSetup(size, hashIter)
+// The bloom filter is not safe for concurrent use unless it's created WithConcurrency.
+// It panics if size or hashIter is zero, or if the filter would take more than 1GB unless allowed
+// WithMaxBytes; use NewBitsetE to get an error instead.
+func NewBitset(size, hashIter uint, opts ...Option) *BF {
+	b, err := NewBitsetE(size, hashIter, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// NewBitsetE is like NewBitset, but returns ErrInvalidParameters if size or hashIter is zero, and
+// ErrTooLarge instead of allocating a filter larger than allowed.
+func NewBitsetE(size, hashIter uint, opts ...Option) (*BF, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	filters := filterSetup(size, hashIter, o)
+	if err := checkMemory(filters, o); err != nil {
+		return nil, err
+	}
 
 	for index, filter := range filters {
-		filter.storage = NewBitsetStorage(filter.size)
+		if filter.partition != index {
+			filter.storage = filters[filter.partition].storage
+		} else if o.concurrent {
+			filter.storage = NewConcurrentBitsetStorage(filter.partitionBits)
+		} else {
+			filter.storage = NewBitsetStorage(filter.partitionBits)
+		}
 		filters[index] = filter
 	}
 
-	return &BF{filters}
+	return &BF{filters, o.observer, o.saves, o.capacityWatch()}, nil
 }
 
-// NewRedis creates and returns a new bloom filter using Redis as a backend.
-func NewRedis(pool *redis.Pool, key string, size, hashIter uint, expiredAfterSeconds int64) (*BF, bool, error) {
-	filters := filterSetup(size, hashIter)
+// NewBitsetStandard creates and returns a new standard bloom filter using Bitset as a backend, where the
+// hash iterations all set their bits in a single shared Bitset of the given size instead of a partition
+// each. It has a slightly lower false positive rate than the partitioned NewBitset for the same size and
+// hash iterations. It always uses enhanced double hashing, since with plain double hashing values whose
+// hashes are related overlap in the shared Bitset. It panics if size or hashIter is zero.
+func NewBitsetStandard(size, hashIter uint, opts ...Option) *BF {
+	return NewBitset(size, hashIter, append(opts, WithEnhancedDoubleHashing(), WithPartitions(1))...)
+}
 
-	bloom := BF{filters}
+// NewBitsetWithEstimate creates and returns a new bloom filter using Bitset as a backend, sized to hold n
+// values with a false positive probability of p.
+func NewBitsetWithEstimate(n uint, p float64, opts ...Option) *BF {
+	size, hashIter := estimateParameters(n, p)
 
-	var err error
-	var exist bool
-	for index, filter := range bloom.filters {
-		filter.storage, exist, err = NewRedisStorage(pool, fmt.Sprintf("%s.%d", key, filter.multiplier), filter.size, expiredAfterSeconds)
-		if err != nil {
-			return &bloom, exist, err
-		}
-		bloom.filters[index] = filter
+	return NewBitset(size, hashIter, opts...)
+}
+
+// validateParameters returns ErrInvalidParameters if size or hashIter is zero.
+func validateParameters(size, hashIter uint) error {
+	if size == 0 || hashIter == 0 {
+		return fmt.Errorf("%w, got size %d and hash iterations %d", ErrInvalidParameters, size, hashIter)
 	}
 
-	return &bloom, exist, nil
+	return nil
 }
 
 // filterSetup is a helper function to generate the required number of filters (hash iterations -> k).
-func filterSetup(size, hashIter uint) (filters []filter) {
-	partitionSize := math.Ceil(float64(size) / float64(hashIter))
+// The size is split evenly between the partitions, one per hash iteration unless set WithPartitions.
+// Every partition gets ceil(size / p) bits, or WithExactSize the first size % p partitions get one bit
+// more than the others.
+func filterSetup(size, hashIter uint, o options) (filters []filter) {
+	partitions := hashIter
+	if o.partitions > 0 && o.partitions < hashIter {
+		partitions = o.partitions
+	}
+	partitionSize := math.Ceil(float64(size) / float64(partitions))
 
 	var k uint
-	hasher := fnv.New64()
 	for k = 0; k < hashIter; k++ {
-		filters = append(filters, filter{uint(partitionSize), nil, hasher, k + 1})
+		filterSize := uint(partitionSize)
+		if o.exactSize && size >= partitions {
+			filterSize = size / partitions
+			if k%partitions < size%partitions {
+				filterSize++
+			}
+		}
+		filters = append(filters, filter{filterSize, nil, o.hasher, k + 1, o.seed, o.scheme, o.hashExtraction(), int(k % partitions)})
+	}
+
+	return
+}
+
+// partitionKey returns the Redis key, or the Memcached key prefix, of a partition filter, starting with
+// the prefix set WithKeyPrefix and wrapping the key in a hash tag if the bloom filter is created
+// WithClusterHashTag.
+func partitionKey(key string, multiplier uint, o options) string {
+	if o.clusterHashTag {
+		return fmt.Sprintf("%s{%s}.%d", o.keyPrefix, key, multiplier)
 	}
 
+	return fmt.Sprintf("%s%s.%d", o.keyPrefix, key, multiplier)
+}
+
+// estimateParameters calculates the optimal size (m) and hash iterations (k) for n values with a false
+// positive probability of p.
+func estimateParameters(n uint, p float64) (size, hashIter uint) {
+	size = OptimalM(n, p)
+	hashIter = OptimalK(n, size)
+
 	return
 }
 
+// OptimalM returns the optimal size in bits (m) of a bloom filter holding n values with a false positive
+// probability of p, using m = -n * ln(p) / ln(2)^2. The filter takes about m / 8 bytes of memory, or of
+// Redis strings. It panics if p isn't between 0 and 1, and n = 0 is counted as a single value.
+func OptimalM(n uint, p float64) uint {
+	if p <= 0 || p >= 1 {
+		panic("bloom: false positive probability must be between 0 and 1")
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	return uint(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+}
+
+// OptimalK returns the optimal number of hash iterations (k) of a bloom filter of m bits holding n values,
+// using k = m / n * ln(2) rounded to the nearest integer. It's between 1 and 32, like the hash iterations
+// chosen by NewBitsetWithEstimate, and n = 0 is counted as a single value.
+func OptimalK(n, m uint) uint {
+	if n == 0 {
+		n = 1
+	}
+
+	k := math.Round(float64(m) / float64(n) * math.Ln2)
+	return uint(math.Max(1, math.Min(k, maxHashIter)))
+}
+
+// Parameters returns the number of bits (m) and hash iterations (k) used by the bloom filter.
+// The bits are split evenly between the partitions, so m might be slightly larger than the requested size
+// unless the filter is created WithExactSize.
+func (b *BF) Parameters() (size, hashIter uint) {
+	for _, f := range b.partitions() {
+		size += f.partitionBits
+	}
+
+	return size, uint(len(b.filters))
+}
+
+// TotalBits returns the number of bits (m) of the bloom filter, summed over its partitions. It's the size
+// returned by Parameters, which is at least the requested size and larger when the partitions round it
+// up.
+func (b *BF) TotalBits() uint {
+	size, _ := b.Parameters()
+
+	return size
+}
+
+// partitions returns the filters holding the storage of every partition, in order.
+func (b *BF) partitions() []filter {
+	count := 0
+	for _, f := range b.filters {
+		if f.partition >= count {
+			count = f.partition + 1
+		}
+	}
+
+	return b.filters[:count]
+}
+
+// Clear removes every value from the bloom filter, including the values waiting in the queue.
+func (b *BF) Clear() error {
+	for _, f := range b.partitions() {
+		if err := f.storage.Clear(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EstimateFalsePositiveRate estimates the current false positive probability of the bloom filter
+// from the ratio of bits set in each partition filter.
+func (b *BF) EstimateFalsePositiveRate() (float64, error) {
+	counts, err := b.counts(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	rate := 1.0
+	for _, f := range b.filters {
+		rate *= float64(counts[f.partition]) / float64(f.partitionBits)
+	}
+
+	return rate, nil
+}
+
+// EstimatedItemCount estimates the number of values added to the bloom filter from the total number of
+// bits set, using -(m/k) * ln(1 - X/m). ErrSaturated is returned if all the bits are set.
+func (b *BF) EstimatedItemCount() (uint, error) {
+	counts, err := b.counts(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	var set uint
+	for _, count := range counts {
+		set += count
+	}
+
+	size, hashIter := b.Parameters()
+	if set >= size {
+		return 0, ErrSaturated
+	}
+
+	return uint(math.Round(estimateItems(set, size, hashIter))), nil
+}
+
+// estimateItems estimates the number of values setting the given number of bits of a bloom filter of size
+// total bits. It holds for partitioned filters as well: with p partitions of m/p bits, every value sets
+// k/p bits of each partition, which leaves the same expected fraction of zero bits as k bits in m.
+func estimateItems(set, size, hashIter uint) float64 {
+	m := float64(size)
+	return -m / float64(hashIter) * math.Log(1-float64(set)/m)
+}
+
+// IsEmpty reports whether no bit of the bloom filter is set, which is the case until a value is saved.
+// It's cheaper than counting the bits: the Bitset backend keeps a count of its bits, and the Redis backend
+// looks for a set bit with BITPOS, pipelined over the partitions.
+func (b *BF) IsEmpty() (bool, error) {
+	return b.IsEmptyContext(context.Background())
+}
+
+// IsEmptyContext is like IsEmpty, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) IsEmptyContext(ctx context.Context) (bool, error) {
+	partitions := b.partitions()
+	stores := make([]storage, len(partitions))
+	for index, f := range partitions {
+		stores[index] = f.storage
+	}
+
+	return allEmpty(ctx, stores)
+}
+
+// counts returns the number of bits set in each partition. Redis backed partitions sharing their
+// connections are counted with pipelined BITCOUNTs in a single round trip.
+func (b *BF) counts(ctx context.Context) ([]uint, error) {
+	partitions := b.partitions()
+	stores := make([]storage, len(partitions))
+	for index, f := range partitions {
+		stores[index] = f.storage
+	}
+
+	return countAll(ctx, stores)
+}
+
 // Append is used to append a value to the queue.
 func (b *BF) Append(value []byte) {
 	for _, f := range b.filters {
-		a, b := f.hashValue(&value)
-		f.storage.Append((a + b*f.multiplier) % f.size)
+		a, b := f.hashValue(value)
+		f.storage.Append(f.position(a, b))
 	}
+	b.observeAdd(1)
+}
+
+// Save takes care of saving the values from the queue to the correct backend, saving the partitions
+// concurrently up to the limit set WithSaveConcurrency. A SaveError listing the failed partition filters
+// is returned if any of them couldn't be saved, in which case their queues are kept so Save can be retried.
+func (b *BF) Save() error {
+	return b.SaveContext(context.Background())
 }
 
-// Save takes care of saving the values from the queue to the correct backend.
-func (b *BF) Save() {
+// SaveContext is like Save, but the Redis backend stops saving once the context is done.
+func (b *BF) SaveContext(ctx context.Context) error {
+	partitions := b.partitions()
+	errs := make([]error, len(partitions))
+
+	saves := b.saves
+	if saves <= 0 {
+		saves = defaultSaveConcurrency
+	}
+	sem := make(chan struct{}, saves)
+
 	var wg sync.WaitGroup
-	for _, f := range b.filters {
+	for index, f := range partitions {
 		wg.Add(1)
-		go func(f filter) {
-			defer wg.Done()
+		sem <- struct{}{}
+		go func(index int, f filter) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
 
-			f.storage.Save()
-		}(f)
+			errs[index] = saveContext(ctx, f.storage)
+		}(index, f)
 	}
 
 	wg.Wait()
+	if err := syncPartitions(partitions); err != nil && errs[0] == nil {
+		errs[0] = err
+	}
+
+	var failed SaveError
+	for index, err := range errs {
+		if err != nil {
+			failed = append(failed, &PartitionError{index, err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+
+	b.observeFill(ctx)
+	b.checkCapacity(ctx)
+	return nil
+}
+
+// Discard empties the queue of every partition filter without saving it, to roll back the values added
+// since the last Save. Values saved already, or being saved concurrently, aren't affected. The atomic
+// Bitset backend sets the bits of the values as they're added, so Discard doesn't do anything for it.
+func (b *BF) Discard() {
+	for _, f := range b.partitions() {
+		if d, ok := f.storage.(discarder); ok {
+			d.discard()
+		}
+	}
+}
+
+// Close saves the values waiting in the queue, like Save, and releases the resources held by the backend:
+// memory mapped files are unmapped and files are closed. A Redis backed filter only saves its queue,
+// since the pool belongs to the caller and is left open. Calling Close when done with a filter makes up
+// for a forgotten Save. Nothing is released if the queue can't be saved, so Close can be retried. The
+// filter can't be used once closed, but calling Close again is safe.
+func (b *BF) Close() error {
+	if err := b.Save(); err != nil {
+		return err
+	}
+
+	var first error
+	for index, f := range b.partitions() {
+		if c, ok := f.storage.(closer); ok {
+			if err := c.close(); err != nil && first == nil {
+				first = &PartitionError{index, err}
+			}
+		}
+	}
+
+	return first
+}
+
+// Destroy deletes the data of a Redis backed bloom filter, the keys of its partitions and the metadata hash
+// read by OpenRedis, with DELs pipelined over a single connection per Redis server, to retire a filter
+// before it expires or clean up after tests. Values waiting in the queue are discarded. The filter can't be
+// used afterwards: every operation returns ErrDestroyed, including Destroy, and Close returns it as well
+// since the queue can't be saved. Other backends return ErrUnsupportedBackend without deleting anything.
+// If a server of a sharded filter fails, the keys deleted so far stay deleted but the filter stays usable,
+// and Destroy can be called again.
+func (b *BF) Destroy() error {
+	return b.DestroyContext(context.Background())
+}
+
+// DestroyContext is like Destroy, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) DestroyContext(ctx context.Context) error {
+	partitions := b.partitions()
+	stores := make([]storage, len(partitions))
+	for index, f := range partitions {
+		stores[index] = f.storage
+	}
+
+	single, groups := groupStores(stores)
+	for _, index := range single {
+		if _, ok := stores[index].(destroyedStorage); ok {
+			return ErrDestroyed
+		}
+		return ErrUnsupportedBackend
+	}
+	for _, group := range groups {
+		if _, ok := stores[group[0]].(destroyer); !ok {
+			return ErrUnsupportedBackend
+		}
+	}
+
+	for _, group := range groups {
+		grouped := make([]storage, len(group))
+		for i, index := range group {
+			grouped[i] = stores[index]
+		}
+		if err := stores[group[0]].(destroyer).destroyMany(ctx, grouped); err != nil {
+			return err
+		}
+	}
+
+	for index := range b.filters {
+		b.filters[index].storage = destroyedStorage{}
+	}
+	return nil
+}
+
+// Has checks if the given value is in the bloom filter or not. False positives might occur. It's Exist
+// with a single value.
+func (b *BF) Has(value []byte) (bool, error) {
+	return b.HasContext(context.Background(), value)
+}
+
+// HasContext is like Has, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) HasContext(ctx context.Context, value []byte) (bool, error) {
+	exists, err := b.ExistContext(ctx, value)
+	if err != nil {
+		return false, err
+	}
+
+	return exists[0], nil
 }
 
 // Exists checks if the given value is in the bloom filter or not. False positives might occur.
+//
+// Deprecated: Exists is easily mistaken for Exist, use Has instead.
 func (b *BF) Exists(value []byte) (exists bool, err error) {
+	return b.HasContext(context.Background(), value)
+}
+
+// ExistsContext is like Exists, but the Redis backend returns ctx.Err() once the context is done.
+//
+// Deprecated: use HasContext instead.
+func (b *BF) ExistsContext(ctx context.Context, value []byte) (exists bool, err error) {
+	return b.HasContext(ctx, value)
+}
+
+// ExistsDetailed checks if the given value is in the bloom filter like Exists, and also counts how many
+// partition filters have the bit of the value set. Every partition filter is checked, even after a miss,
+// so it's meant for diagnosing the hash distribution rather than for the hot path.
+func (b *BF) ExistsDetailed(value []byte) (exists bool, matchedPartitions int, err error) {
 	for _, f := range b.filters {
-		a, b := f.hashValue(&value)
-		exists, err = f.storage.Exists((a + b*f.multiplier) % f.size)
-		if !exists {
-			return
+		a, b := f.hashValue(value)
+
+		found, err := f.storage.Exists(f.position(a, b))
+		if err != nil {
+			return false, matchedPartitions, err
+		}
+		if found {
+			matchedPartitions++
 		}
 	}
 
-	exists = true
-	return
+	return matchedPartitions == len(b.filters), matchedPartitions, nil
 }
 
-// Exist checks if the given value is in the bloom filter or not. False positives might occur.
+// Exist checks if the given values are in the bloom filter or not. False positives might occur.
+// Each partition filter is queried once for all the values that might still exist, and the Redis backend
+// pipelines the GETBITs of every partition and value into a single round trip when the partitions share
+// their connections, instead of one per bit.
 func (b *BF) Exist(values ...Value) (exists []bool, err error) {
+	return b.ExistContext(context.Background(), values...)
+}
+
+// ExistContext is like Exist, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) ExistContext(ctx context.Context, values ...Value) (exists []bool, err error) {
+	exists, err = b.existHashes(ctx, b.hashValues(values))
+	b.observeQueries(err, exists...)
+
+	return
+}
+
+// Missing returns the values that aren't in the bloom filter, in the order they're given, like Exist
+// without the values that might exist. Missing values are certain to not have been added, so they're
+// the ones to look up elsewhere.
+func (b *BF) Missing(values ...Value) ([]Value, error) {
+	return b.MissingContext(context.Background(), values...)
+}
+
+// MissingContext is like Missing, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) MissingContext(ctx context.Context, values ...Value) (missing []Value, err error) {
+	exists, err := b.ExistContext(ctx, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, e := range exists {
+		if !e {
+			missing = append(missing, values[index])
+		}
+	}
+
+	return missing, nil
+}
+
+// ExistsAll checks if every one of the values is in the bloom filter, returning false as soon as a
+// partition filter is missing the bit of any of them. False positives might occur.
+func (b *BF) ExistsAll(values ...Value) (bool, error) {
+	return b.ExistsAllContext(context.Background(), values...)
+}
+
+// ExistsAllContext is like ExistsAll, but the Redis backend returns ctx.Err() once the context is done.
+// The Redis backend checks every partition filter with pipelined GETBITs.
+func (b *BF) ExistsAllContext(ctx context.Context, values ...Value) (all bool, err error) {
+	defer func() { b.observeQueries(err, all) }()
+
+	hashes := b.hashValues(values)
+
+	bits := make([]uint, len(hashes))
+	for _, f := range b.filters {
+		for index, hashed := range hashes {
+			bits[index] = f.position(hashed[0], hashed[1])
+		}
+
+		found, err := existsManyContext(ctx, f.storage, bits)
+		if err != nil {
+			return false, err
+		}
+		for _, exists := range found {
+			if !exists {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// ExistsAny checks if at least one of the values is in the bloom filter, returning false as soon as no
+// value has its bits set in every partition filter checked so far. False positives might occur.
+func (b *BF) ExistsAny(values ...Value) (bool, error) {
+	return b.ExistsAnyContext(context.Background(), values...)
+}
+
+// ExistsAnyContext is like ExistsAny, but the Redis backend returns ctx.Err() once the context is done.
+// The Redis backend checks every partition filter with pipelined GETBITs.
+func (b *BF) ExistsAnyContext(ctx context.Context, values ...Value) (found bool, err error) {
+	defer func() { b.observeQueries(err, found) }()
+
+	exists, err := b.existHashes(ctx, b.hashValues(values))
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range exists {
+		if e {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hashValues hashes each of the values once, for checking them against every partition filter.
+func (b *BF) hashValues(values []Value) [][2]uint {
+	hashes := make([][2]uint, len(values))
+	if len(b.filters) == 0 {
+		return hashes
+	}
 
-	exists = make([]bool, len(values))
 	for index, value := range values {
-		for _, f := range b.filters {
-			a, b := f.hashedValue(&value)
-			exist, err := f.storage.Exists((a + b*f.multiplier) % f.size)
-			if err != nil {
-				return exists, err
+		hashes[index][0], hashes[index][1] = b.filters[0].hashValue(value)
+	}
+
+	return hashes
+}
+
+// existHashes checks which of the hashed values are in the bloom filter, querying each partition filter
+// once for all the values that might still exist. The partitions sharing a pipeline, like the Redis
+// backends of a server, are checked at once instead, which takes a single round trip per server instead of
+// one per partition, at the cost of checking the bits of values already missing on that server.
+func (b *BF) existHashes(ctx context.Context, hashes [][2]uint) (exists []bool, err error) {
+	exists = make([]bool, len(hashes))
+	candidates := make([]int, len(hashes))
+	for index := range candidates {
+		candidates[index] = index
+	}
+
+	var checked map[interface{}]bool
+	bits := make([]uint, 0, len(hashes))
+	for index, f := range b.filters {
+		if len(candidates) == 0 {
+			break
+		}
+
+		var found []bool
+		if checker, ok := f.storage.(pipelinedChecker); ok {
+			if checked[checker.countGroup()] {
+				continue
+			}
+			if checked == nil {
+				checked = make(map[interface{}]bool)
+			}
+			checked[checker.countGroup()] = true
+
+			found, err = existAcross(ctx, checker, b.filters[index:], hashes, candidates)
+		} else {
+			bits = bits[:0]
+			for _, candidate := range candidates {
+				bits = append(bits, f.position(hashes[candidate][0], hashes[candidate][1]))
+			}
+
+			found, err = existsManyContext(ctx, f.storage, bits)
+		}
+		if err != nil {
+			return exists, err
+		}
+
+		remaining := candidates[:0]
+		for i, candidate := range candidates {
+			if found[i] {
+				remaining = append(remaining, candidate)
 			}
-			exists[index] = exist
 		}
+		candidates = remaining
+	}
+
+	for _, index := range candidates {
+		exists[index] = true
 	}
 	return
 }
 
-// Load checks if the given value is in the bloom filter or not. False positives might occur.
+// existAcross checks the bits of the candidate hashed values in every one of the partition filters
+// sharing the countGroup of the pipelinedChecker at once, and reports which candidates have all of them
+// set.
+func existAcross(ctx context.Context, checker pipelinedChecker, filters []filter, hashes [][2]uint, candidates []int) ([]bool, error) {
+	group := make([]filter, 0, len(filters))
+	for _, f := range filters {
+		if pc, ok := f.storage.(pipelinedChecker); ok && pc.countGroup() == checker.countGroup() {
+			group = append(group, f)
+		}
+	}
+
+	stores := make([]storage, 0, len(candidates)*len(group))
+	bits := make([]uint, 0, cap(stores))
+	for _, candidate := range candidates {
+		for _, f := range group {
+			stores = append(stores, f.storage)
+			bits = append(bits, f.position(hashes[candidate][0], hashes[candidate][1]))
+		}
+	}
+
+	found, err := checker.existsAcross(ctx, stores, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make([]bool, len(candidates))
+	for i := range candidates {
+		exists[i] = true
+		for _, set := range found[i*len(group) : (i+1)*len(group)] {
+			exists[i] = exists[i] && set
+		}
+	}
+	return exists, nil
+}
+
+// Warm checks the bits of the values in every partition filter without answering whether they exist, to
+// pull the pages Redis keeps them in into memory before a burst of reads. The GETBITs of the partitions
+// sharing their connections are pipelined with a single flush, so a filter which isn't sharded takes a
+// single round trip. Partitions of other backends are skipped, since their bits are already in memory.
+func (b *BF) Warm(values ...Value) error {
+	return b.WarmContext(context.Background(), values...)
+}
+
+// WarmContext is like Warm, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) WarmContext(ctx context.Context, values ...Value) error {
+	hashes := b.hashValues(values)
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var checkers []pipelinedChecker
+	var stores [][]storage
+	var bits [][]uint
+	positions := make(map[interface{}]int)
+	for _, f := range b.filters {
+		checker, ok := f.storage.(pipelinedChecker)
+		if !ok {
+			continue
+		}
+
+		position, ok := positions[checker.countGroup()]
+		if !ok {
+			position = len(checkers)
+			positions[checker.countGroup()] = position
+			checkers, stores, bits = append(checkers, checker), append(stores, nil), append(bits, nil)
+		}
+		for _, hash := range hashes {
+			stores[position] = append(stores[position], f.storage)
+			bits[position] = append(bits[position], f.position(hash[0], hash[1]))
+		}
+	}
+
+	for index, checker := range checkers {
+		if _, err := checker.existsAcross(ctx, stores[index], bits[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load adds the given values to the bloom filter and saves them, returning whether each value was
+// already in the bloom filter before the call. False positives might occur.
 func (b *BF) Load(values ...Value) (exists []bool, err error) {
+	return b.LoadContext(context.Background(), values...)
+}
+
+// LoadContext is like Load, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) LoadContext(ctx context.Context, values ...Value) (exists []bool, err error) {
+	exists, err = b.ExistContext(ctx, values...)
+	if err != nil {
+		return
+	}
+
 	b.Add(values...)
-	b.Save()
+	err = b.SaveContext(ctx)
 	return
 }
 
+// AddIfNotExists adds and saves the values that aren't in the bloom filter yet, returning whether each
+// value was newly added. Values are handled in order, so a value repeated within the call is only added
+// the first time. False positives might occur, in which case a new value is reported as already present.
+func (b *BF) AddIfNotExists(values ...Value) (added []bool, err error) {
+	return b.AddIfNotExistsContext(context.Background(), values...)
+}
+
+// AddIfNotExistsContext is like AddIfNotExists, but the Redis backend returns ctx.Err() once the context
+// is done. The Redis backend checks every partition filter with pipelined GETBITs before saving the new
+// values with pipelined SETBITs.
+func (b *BF) AddIfNotExistsContext(ctx context.Context, values ...Value) (added []bool, err error) {
+	hashes := b.hashValues(values)
+
+	exists, err := b.existHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]map[uint]bool, len(b.partitions()))
+	for index := range pending {
+		pending[index] = make(map[uint]bool)
+	}
+
+	added = make([]bool, len(values))
+	for index, hashed := range hashes {
+		if exists[index] {
+			continue
+		}
+
+		queued := len(b.filters) > 0
+		for _, f := range b.filters {
+			if !pending[f.partition][f.position(hashed[0], hashed[1])] {
+				queued = false
+				break
+			}
+		}
+		if queued {
+			continue
+		}
+
+		added[index] = true
+		b.observeAdd(1)
+		for _, f := range b.filters {
+			bit := f.position(hashed[0], hashed[1])
+			pending[f.partition][bit] = true
+			f.storage.Append(bit)
+		}
+	}
+
+	err = b.SaveContext(ctx)
+	return
+}
+
+// GetOrAdd reports whether the value already existed in the bloom filter, and adds and saves it if it
+// didn't, computing its bit positions once for both. Redis backends sharing their connections check and set
+// the bits in a single Lua script, like CheckAndAdd and with the same Redis Cluster requirements, so other
+// clients can't add the value in between. Other backends check the bits first and only append them when
+// one is missing, saving them along with the values already in the queue.
+func (b *BF) GetOrAdd(value []byte) (existed bool, err error) {
+	return b.GetOrAddContext(context.Background(), value)
+}
+
+// GetOrAddContext is like GetOrAdd, but the Redis backend returns ctx.Err() once the context is done.
+func (b *BF) GetOrAddContext(ctx context.Context, value []byte) (existed bool, err error) {
+	defer func() { b.observeQueries(err, existed) }()
+
+	if len(b.filters) == 0 {
+		return false, nil
+	}
+
+	positions := b.positionsFor(value)
+	if checker, ok := checkGroup(b.filters); ok {
+		if adder, ok := checker.(atomicAdder); ok {
+			stores := make([]storage, len(b.filters))
+			for index, f := range b.filters {
+				stores[index] = f.storage
+			}
+
+			existed, err = adder.addAcross(ctx, stores, positions)
+			if err == nil && !existed {
+				b.observeAdd(1)
+			}
+			return
+		}
+	}
+
+	existed = true
+	for index, f := range b.filters {
+		set, err := existsContext(ctx, f.storage, positions[index])
+		if err != nil {
+			return false, err
+		}
+		if !set {
+			existed = false
+			break
+		}
+	}
+	if existed {
+		return true, nil
+	}
+
+	b.observeAdd(1)
+	for index, f := range b.filters {
+		f.storage.Append(positions[index])
+	}
+
+	return false, b.SaveContext(ctx)
+}
+
 // Add is used to append a value to the queue.
 func (b *BF) Add(values ...Value) {
 
 	for _, value := range values {
 		for _, f := range b.filters {
-			a, b := f.hashedValue(&value)
-			f.storage.Append((a + b*f.multiplier) % f.size)
+			a, b := f.hashValue(value)
+			f.storage.Append(f.position(a, b))
 		}
 	}
+	b.observeAdd(len(values))
 }
 
-// hashValue takes care of hashing the value that is being stored in the bloom filter.
-func (f *filter) hashValue(value *[]byte) (a, b uint) {
-	f.hasher.Reset()
-	f.hasher.Write(*value)
-	sum := f.hasher.Sum(nil)
+// BitPositions returns the bit the value sets in every partition filter, in the order of the hash
+// iterations. The positions only depend on the size, hash iterations, partitions, seed, hashing scheme and
+// hasher of the bloom filter, and they're part of the stable serialization contract: a given value maps to
+// the same bits in every version of the package, which is what lets serialized filters and Redis keys be
+// read back. Positions are within the partition, so filters sharing a partition index the same bits.
+func (b *BF) BitPositions(value []byte) []uint {
+	positions := make([]uint, len(b.filters))
+	for index, f := range b.filters {
+		a, b := f.hashValue(value)
+		positions[index] = f.position(a, b)
+	}
 
-	a = uint(binary.BigEndian.Uint32(sum[0:4]))
-	b = uint(binary.BigEndian.Uint32(sum[4:8]))
+	return positions
+}
 
-	return
+// positionsFor returns the bit the value sets in every partition filter like BitPositions, hashing the
+// value once for all of them.
+func (b *BF) positionsFor(value []byte) []uint {
+	positions := make([]uint, len(b.filters))
+	if len(b.filters) == 0 {
+		return positions
+	}
+
+	a, h := b.filters[0].hashValue(value)
+	for index, f := range b.filters {
+		positions[index] = f.position(a, h)
+	}
+
+	return positions
 }
 
 // hashValue takes care of hashing the value that is being stored in the bloom filter.
-func (f *filter) hashedValue(value *Value) (a, b uint) {
-	f.hasher.Reset()
-	f.hasher.Write(*value)
-	sum := f.hasher.Sum(nil)
+// A new hasher is used for every call, so filters can be hashed from multiple goroutines.
+func (f *filter) hashValue(value []byte) (a, b uint) {
+	hasher := f.newHasher()
+	hasher.Write(value)
+
+	return f.hashSum(hasher)
+}
+
+// newHasher creates a hasher for the filter, writing the seed as big endian bytes first if it isn't zero.
+// FNV-1 is used unless the filter was created WithHasher.
+func (f *filter) newHasher() hash.Hash64 {
+	var hasher hash.Hash64
+	if f.hasher != nil {
+		hasher = f.hasher()
+	} else {
+		hasher = fnv.New64()
+	}
+
+	if f.seed != 0 {
+		var seed [8]byte
+		binary.BigEndian.PutUint64(seed[:], f.seed)
+		hasher.Write(seed[:])
+	}
+
+	return hasher
+}
+
+// position returns the bit of the partition filter for the two hash values of a value. Enhanced double
+// hashing reduces every term modulo the partition size and adds them up with mulMod and addMod, so they
+// can't overflow however large the multiplier and the partition are. Mixed
+// hashing joins the two halves back into the 64 bit hash and takes the output of a splitmix64 generator
+// seeded with it, the multiplier giving the index of the output. Double hashing a partition of more than
+// 2^32 bits widens the two values first, since a + b*i would otherwise never reach past the first few
+// multiples of 2^32.
+func (f *filter) position(a, b uint) uint {
+	m := f.partitionBits
+	if uint64(m) > 1<<32 && f.scheme != mixedHashing {
+		a, b = widen(a, b)
+	}
+
+	switch f.scheme {
+	case enhancedDoubleHashing:
+		bit := addMod(a%m, mulMod(b%m, f.multiplier%m, m), m)
+		return addMod(bit, tetrahedral(f.multiplier)%m, m)
+	case mixedHashing:
+		h := uint64(a)<<32 | uint64(uint32(b))
+		return uint(splitmix64(h+uint64(f.multiplier)*splitmixGamma) % uint64(m))
+	}
+
+	return (a + b*f.multiplier) % m
+}
+
+// mulMod returns x*y modulo m for x and y below m, computing the full 128 bit product.
+func mulMod(x, y, m uint) uint {
+	hi, lo := bits.Mul64(uint64(x), uint64(y))
+
+	return uint(bits.Rem64(hi, lo, uint64(m)))
+}
+
+// addMod returns x+y modulo m for x and y below m, without the sum wrapping around.
+func addMod(x, y, m uint) uint {
+	if x >= m-y {
+		return x - (m - y)
+	}
+
+	return x + y
+}
+
+// widen derives two 64 bit hash values from the two 32 bit ones, as the first two outputs of a splitmix64
+// generator seeded with the 64 bit hash they were read from.
+func widen(a, b uint) (uint, uint) {
+	state := (uint64(a)<<32 | uint64(uint32(b))) + splitmixGamma
+
+	return uint(splitmix64(state)), uint(splitmix64(state + splitmixGamma))
+}
+
+// splitmix64 is the finalizer of the splitmix64 generator, which mixes every bit of x into every bit of
+// the result.
+func splitmix64(x uint64) uint64 {
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+
+	return x ^ x>>31
+}
+
+// tetrahedral returns (i^3-i)/6, the cubic term of enhanced double hashing.
+func tetrahedral(i uint) uint {
+	return (i - 1) * i / 2 * (i + 1) / 3
+}
+
+// hashSum splits the sum of the hasher into the two values used for double hashing. Both halves of the
+// 64 bit sum are used by default, and partition k sets bit a + b*(k+1), so values only collide in every
+// partition when both a and b collide modulo the partition size. Kirsch and Mitzenmacher show this
+// doesn't change the asymptotic false positive rate.
+func (f *filter) hashSum(hasher hash.Hash64) (a, b uint) {
+	return f.extract(hasher.Sum(nil))
+}
+
+// splitHash splits a 64 bit hash like hashSum splits the sum of the hasher, which holds its big endian
+// bytes.
+func (f *filter) splitHash(h uint64) (a, b uint) {
+	if f.extraction == defaultExtraction {
+		return uint(h >> 32), uint(uint32(h))
+	}
+
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], h)
+	return f.extract(sum[:])
+}
+
+// extract reads the two hash values from the bytes of the hash sum, as set by the hashExtraction.
+func (f *filter) extract(sum []byte) (a, b uint) {
+	e := f.extraction
+	var order binary.ByteOrder = binary.BigEndian
+	if e.littleEndian {
+		order = binary.LittleEndian
+	}
 
-	a = uint(binary.BigEndian.Uint32(sum[0:4]))
-	b = uint(binary.BigEndian.Uint32(sum[4:8]))
+	a = uint(order.Uint32(sum[e.a : e.a+4]))
+	b = uint(order.Uint32(sum[e.b : e.b+4]))
 
 	return
 }
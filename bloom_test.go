@@ -1,165 +1,2853 @@
 package bloom
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/gomodule/redigo/redis"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
 	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func newRedisPool(maxIdle int) *redis.Pool {
-	return &redis.Pool{
-		MaxIdle:     maxIdle,
-		IdleTimeout: 240 * time.Second,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", fmt.Sprintf("%s:6379", os.Getenv("REDIS_HOST")))
+func TestBitsetSave(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	b.Append([]byte("afi"))
+	b.Save()
+
+	exists, err := b.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the Bitset backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = b.Exists([]byte("amma"))
+	if exists {
+		t.Fatal("amma shouldn't exist in the Bitset backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingStorage is a Bitset backend storage which fails to save.
+type failingStorage struct {
+	*BitsetStorage
+}
+
+var errFailingStorage = errors.New("failing storage")
+
+func (s failingStorage) Save() error {
+	return errFailingStorage
+}
+
+func TestBitsetSaveError(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.filters[2].storage = failingStorage{b.filters[2].storage.(*BitsetStorage)}
+	b.filters[5].storage = failingStorage{b.filters[5].storage.(*BitsetStorage)}
+
+	b.Append([]byte("afi"))
+	err := b.Save()
+
+	failed, ok := err.(SaveError)
+	if !ok {
+		t.Fatalf("expected a SaveError, got %v", err)
+	}
+	if len(failed) != 2 || failed[0].Partition != 2 || failed[1].Partition != 5 {
+		t.Fatalf("expected partitions 2 and 5 to fail, got %v", err)
+	}
+	if !errors.Is(err, errFailingStorage) {
+		t.Fatalf("expected the SaveError to wrap the storage error, got %v", err)
+	}
+}
+
+func TestBitsetWithConcurrency(t *testing.T) {
+	b := NewBitset(15000, 7, WithConcurrency())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				value := Value(fmt.Sprintf("afi.%d.%d", g, i))
+				b.Add(value)
+				if err := b.Save(); err != nil {
+					errs <- err
+					return
+				}
+
+				exists, err := b.Exists(value)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !exists {
+					errs <- fmt.Errorf("%s should exist in the Bitset backend", value)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetAtomic(t *testing.T) {
+	b := NewBitsetAtomic(15000, 7)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				b.Add(Value(fmt.Sprintf("afi.%d.%d", g, i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 200; i++ {
+			value := []byte(fmt.Sprintf("afi.%d.%d", g, i))
+			exists, err := b.Exists(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists {
+				t.Fatalf("%s should exist in the atomic Bitset backend without saving", value)
+			}
+		}
+	}
+
+	if count, _ := b.filters[0].storage.Count(); count == 0 || count > 1600 {
+		t.Fatalf("expected up to 1600 bits to be set in the first partition, got %d", count)
+	}
+}
+
+func TestBitsetExist(t *testing.T) {
+	tests := []struct {
+		size     uint
+		hashIter uint
+		n        int
+	}{
+		{15000, 7, 1000},
+		{15000, 3, 1000},
+		{50000, 7, 5000},
+		{9600, 1, 1000},
+	}
+
+	for _, test := range tests {
+		b := NewBitset(test.size, test.hashIter)
+
+		present := make([]Value, test.n)
+		absent := make([]Value, test.n)
+		for i := 0; i < test.n; i++ {
+			present[i] = Value(fmt.Sprintf("present.%d", i))
+			absent[i] = Value(fmt.Sprintf("absent.%d", i))
+		}
+		b.Add(present...)
+		b.Save()
+
+		exists, err := b.Exist(present...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, exist := range exists {
+			if !exist {
+				t.Fatalf("%s should exist in the Bitset backend (size %d, k %d)", present[i], test.size, test.hashIter)
+			}
+		}
+
+		exists, err = b.Exist(absent...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		falsePositives := 0
+		for _, exist := range exists {
+			if exist {
+				falsePositives++
+			}
+		}
+
+		partitionSize := math.Ceil(float64(test.size) / float64(test.hashIter))
+		expected := math.Pow(1-math.Pow(1-1/partitionSize, float64(test.n)), float64(test.hashIter))
+		if rate := float64(falsePositives) / float64(test.n); rate > expected*1.5+0.005 {
+			t.Fatalf("false positive rate %f too high, expected around %f (size %d, k %d)", rate, expected, test.size, test.hashIter)
+		}
+	}
+}
+
+func TestBitsetHas(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	values := make([]Value, 100)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(values[:50]...)
+	b.Save()
+
+	batch, err := b.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, value := range values {
+		has, err := b.Has(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exists, err := b.Exists(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		str, err := b.ExistsString(string(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detailed, _, err := b.ExistsDetailed(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := b.ExistsAll(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := b.ExistsAny(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, result := range []bool{exists, str, detailed, all, found, batch[i]} {
+			if result != has {
+				t.Fatalf("expected every check of %s to agree with Has, got %v, %v, %v, %v, %v and %v", value, exists, str, detailed, all, found, batch[i])
+			}
+		}
+		if has != (i < 50) {
+			t.Fatalf("expected %s to exist %t, got %t", value, i < 50, has)
+		}
+	}
+}
+
+func TestBitsetSetBits(t *testing.T) {
+	for _, b := range []*BF{NewBitset(15000, 7, WithPartitions(3)), NewBitsetAtomic(15000, 7, WithPartitions(3))} {
+		b.Add(Value("afi"))
+		b.Save()
+
+		expected := make([][]uint, 3)
+		for index, position := range b.BitPositions([]byte("afi")) {
+			partition := b.filters[index].partition
+			expected[partition] = uniqueBits(append(expected[partition], position))
+		}
+
+		set, err := b.SetBits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(set, expected) {
+			t.Fatalf("expected the bits %v to be set, got %v", expected, set)
+		}
+	}
+
+	set, err := NewBitset(100, 2).SetBits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set, [][]uint{{}, {}}) {
+		t.Fatalf("expected no bits to be set in an empty filter, got %v", set)
+	}
+}
+
+func TestBitsetBytes(t *testing.T) {
+	for _, b := range []*BF{NewBitset(15000, 7, WithPartitions(3)), NewBitsetAtomic(15000, 7, WithPartitions(3))} {
+		b.Add(Value("afi"))
+		b.Save()
+
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 3 || len(data[0]) != 625 {
+			t.Fatalf("expected 3 partitions of 625 bytes, got %d partitions", len(data))
+		}
+
+		exists := func(value []byte) bool {
+			for index, position := range b.BitPositions(value) {
+				if data[b.filters[index].partition][position/8]&(0x80>>(position%8)) == 0 {
+					return false
+				}
+			}
+			return true
+		}
+		if !exists([]byte("afi")) {
+			t.Fatal("afi should exist in the bytes of the filter")
+		}
+		if exists([]byte("amma")) {
+			t.Fatal("amma shouldn't exist in the bytes of the filter")
+		}
+
+		set, _ := b.SetBits()
+		for index := range data {
+			count := 0
+			for _, octet := range data[index] {
+				count += bits.OnesCount8(octet)
+			}
+			if count != len(set[index]) {
+				t.Fatalf("expected %d bits set in partition %d, got %d", len(set[index]), index, count)
+			}
+		}
+	}
+}
+
+func TestBitsetCompress(t *testing.T) {
+	b := NewBitset(1000000, 7)
+	for i := 0; i < 1400; i++ {
+		b.AddString(fmt.Sprintf("value-%d", i))
+	}
+	b.Save()
+
+	c, err := b.Compress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MemoryBytes()*5 > b.MemoryBytes() {
+		t.Fatalf("expected a 1%% full filter to compress to less than a fifth, got %d bytes for %d", c.MemoryBytes(), b.MemoryBytes())
+	}
+
+	for i := 0; i < 20000; i++ {
+		value := fmt.Sprintf("value-%d", i)
+		expected, _ := b.ExistsString(value)
+		exists, err := c.ExistsString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Fatalf("expected the compressed filter to answer %v for %s, got %v", expected, value, exists)
+		}
+		if exists, _ := c.Exists([]byte(value)); exists != expected {
+			t.Fatalf("expected Exists to answer %v for %s, got %v", expected, value, exists)
+		}
+	}
+
+	b.AddString("afi")
+	b.Save()
+	if exists, _ := c.ExistsString("afi"); exists {
+		t.Fatal("afi shouldn't exist in a filter compressed before it was added")
+	}
+
+	empty, err := NewBitset(100, 2).Compress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := empty.ExistsString("afi"); exists {
+		t.Fatal("afi shouldn't exist in an empty compressed filter")
+	}
+}
+
+func TestBitsetHashExtraction(t *testing.T) {
+	b := NewBitset(15000, 7)
+	explicit := NewBitset(15000, 7, WithHashExtraction(binary.BigEndian, 0, 4))
+	if !reflect.DeepEqual(b.BitPositions([]byte("afi")), explicit.BitPositions([]byte("afi"))) || b.Fingerprint() != explicit.Fingerprint() {
+		t.Fatal("expected the default hash extraction to read big endian halves")
+	}
+
+	little := NewBitset(15000, 7, WithHashExtraction(binary.LittleEndian, 4, 0))
+	if little.Fingerprint() == b.Fingerprint() {
+		t.Fatal("expected the hash extraction to change the fingerprint")
+	}
+
+	hasher := fnv.New64()
+	hasher.Write([]byte("afi"))
+	sum := hasher.Sum(nil)
+	x, y := uint(binary.LittleEndian.Uint32(sum[4:8])), uint(binary.LittleEndian.Uint32(sum[0:4]))
+	for index, position := range little.BitPositions([]byte("afi")) {
+		if expected := (x + y*uint(index+1)) % 2143; position != expected {
+			t.Fatalf("expected hash iteration %d to set bit %d, got %d", index, expected, position)
+		}
+	}
+
+	little.AddUint64(42)
+	little.Save()
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], 42)
+	if exists, _ := little.Exists(id[:]); !exists {
+		t.Fatal("expected integers to be split like the sum of the hasher")
+	}
+
+	data, err := little.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBitset(100, 2)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Fingerprint() != little.Fingerprint() {
+		t.Fatal("expected the hash extraction to be restored")
+	}
+	if exists, _ := restored.ExistsUint64(42); !exists {
+		t.Fatal("42 should exist in the restored filter")
+	}
+
+	for _, invalid := range [][2]int{{0, 0}, {0, 5}, {-1, 4}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected offsets %v to panic", invalid)
+				}
+			}()
+			WithHashExtraction(binary.BigEndian, invalid[0], invalid[1])
+		}()
+	}
+}
+
+func TestBitsetMissing(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"), Value("foo"))
+	b.Save()
+
+	missing, err := b.Missing(Value("amma"), Value("afi"), Value("bar"), Value("foo"), Value("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []Value{Value("amma"), Value("bar"), Value("baz")}) {
+		t.Fatalf("expected amma, bar and baz to be missing in order, got %q", missing)
+	}
+
+	if missing, err := b.Missing(Value("afi")); err != nil || missing != nil {
+		t.Fatalf("expected nothing to be missing, got %q and %v", missing, err)
+	}
+}
+
+func TestBitsetExistsStream(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"), Value("nanay"))
+	b.Save()
+
+	in := make(chan Value)
+	go func() {
+		for _, value := range []string{"afi", "amma", "nanay"} {
+			in <- Value(value)
+		}
+		close(in)
+	}()
+
+	var results []Result
+	for result := range b.ExistsStream(in) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		results = append(results, result)
+	}
+
+	expected := []Result{{Value("afi"), true, nil}, {Value("amma"), false, nil}, {Value("nanay"), true, nil}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected %v, got %v", expected, results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := make(chan Value)
+	out := b.ExistsStreamContext(ctx, blocked, 2)
+	cancel()
+	if _, ok := <-out; ok {
+		t.Fatal("the stream should be closed once the context is cancelled")
+	}
+}
+
+func TestBitsetExistsAllAny(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"), Value("amma"))
+	b.Save()
+
+	tests := []struct {
+		values []Value
+		all    bool
+		any    bool
+	}{
+		{[]Value{Value("afi"), Value("amma")}, true, true},
+		{[]Value{Value("afi"), Value("langafi")}, false, true},
+		{[]Value{Value("langafi"), Value("langamma")}, false, false},
+		{nil, true, false},
+	}
+
+	for _, test := range tests {
+		all, err := b.ExistsAll(test.values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if all != test.all {
+			t.Fatalf("expected ExistsAll of %q to be %v", test.values, test.all)
+		}
+
+		found, err := b.ExistsAny(test.values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != test.any {
+			t.Fatalf("expected ExistsAny of %q to be %v", test.values, test.any)
+		}
+	}
+}
+
+func TestBitsetExistsDetailed(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"))
+	b.Save()
+
+	exists, matched, err := b.ExistsDetailed([]byte("afi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || matched != 7 {
+		t.Fatalf("expected afi to match every partition, got %v and %d", exists, matched)
+	}
+
+	// Set the bits of langafi in all but the last partition.
+	value := []byte("langafi")
+	for _, f := range b.filters[:6] {
+		x, y := f.hashValue(value)
+		f.storage.Append(f.position(x, y))
+	}
+	b.Save()
+
+	exists, matched, err = b.ExistsDetailed(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists || matched != 6 {
+		t.Fatalf("expected langafi to match 6 partitions, got %v and %d", exists, matched)
+	}
+}
+
+func TestBitsetLoad(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	exists, err := b.Load(Value("afi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exists) != 2 || exists[0] || exists[1] {
+		t.Fatalf("afi and amma shouldn't exist before the first Load, got %v", exists)
+	}
+
+	exists, err = b.Load(Value("afi"), Value("langafi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exists) != 2 || !exists[0] || exists[1] {
+		t.Fatalf("only afi should exist before the second Load, got %v", exists)
+	}
+
+	exist, err := b.Exists([]byte("langafi"))
+	if !exist {
+		t.Fatal("langafi should exist in the Bitset backend after Load")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetAddFrom(t *testing.T) {
+	for sep, input := range map[byte]string{'\n': "afi\r\namma\n\nfoo", ',': "afi,amma,,foo,"} {
+		b := NewBitset(15000, 7)
+
+		count, err := b.AddFrom(bytes.NewBufferString(input), sep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatalf("expected 3 values to be added from %q, got %d", input, count)
+		}
+
+		exists, err := b.Exist(Value("afi"), Value("amma"), Value("foo"), Value("bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(exists, []bool{true, true, true, false}) {
+			t.Fatalf("expected afi, amma and foo to be added from %q, got %v", input, exists)
+		}
+	}
+
+	b := NewBitset(15000, 7)
+	count, err := b.AddFrom(io.MultiReader(bytes.NewBufferString("afi\n"), errReader{io.ErrUnexpectedEOF}), '\n')
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+	if exists, _ := b.Exists([]byte("afi")); count != 1 || !exists {
+		t.Fatal("values read before the error should be added and saved")
+	}
+}
+
+// errReader is an io.Reader failing with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestBitsetAddIfNotExists(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"))
+	b.Save()
+
+	added, err := b.AddIfNotExists(Value("afi"), Value("amma"), Value("langafi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []bool{false, true, true, false}; !reflect.DeepEqual(added, expected) {
+		t.Fatalf("expected %v to be added, got %v", expected, added)
+	}
+
+	exists, err := b.Exist(Value("amma"), Value("langafi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || !exists[1] {
+		t.Fatal("added values should be saved to the Bitset backend")
+	}
+}
+
+func TestBitsetGetOrAdd(t *testing.T) {
+	b := NewBitset(15000, 7, WithPartitions(3))
+	if positions := b.positionsFor([]byte("afi")); !reflect.DeepEqual(positions, b.BitPositions([]byte("afi"))) {
+		t.Fatalf("expected the positions of afi to be %v, got %v", b.BitPositions([]byte("afi")), positions)
+	}
+
+	b.Add(Value("amma"))
+	for _, expected := range []bool{false, true} {
+		existed, err := b.GetOrAdd([]byte("afi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existed != expected {
+			t.Fatalf("expected afi to exist %t, got %t", expected, existed)
+		}
+	}
+
+	exists, err := b.Exist(Value("afi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || !exists[1] {
+		t.Fatal("GetOrAdd should save the value along with the queue")
+	}
+}
+
+func TestBitsetConcurrentExists(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	values := make([]Value, 500)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(values...)
+	b.Save()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, value := range values {
+				for i := 0; i < 20; i++ {
+					exists, err := b.Exists(value)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !exists {
+						errs <- fmt.Errorf("%s should exist in the Bitset backend", value)
+						return
+					}
+				}
+			}
+
+			exists, err := b.Exist(values...)
 			if err != nil {
-				return nil, err
+				errs <- err
+				return
 			}
+			for i, exist := range exists {
+				if !exist {
+					errs <- fmt.Errorf("%s should exist in the Bitset backend", values[i])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetConcurrentAddExists(t *testing.T) {
+	b := NewBitset(15000, 7, WithConcurrency())
+
+	saved := make([]Value, 100)
+	for i := range saved {
+		saved[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(saved...)
+	b.Save()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 100; i++ {
+				value := Value(fmt.Sprintf("amma.%d.%d", g, i))
+				b.Add(value)
+				if err := b.Save(); err != nil {
+					errs <- err
+					return
+				}
+				if exists, err := b.Has(value); err != nil || !exists {
+					errs <- fmt.Errorf("%s should exist once saved, got %t, %v", value, exists, err)
+					return
+				}
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				for _, value := range saved {
+					if exists, err := b.Has(value); err != nil || !exists {
+						errs <- fmt.Errorf("%s should exist while others are added, got %t, %v", value, exists, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetInvalidParameters(t *testing.T) {
+	for _, params := range [][2]uint{{0, 7}, {15000, 0}, {0, 0}} {
+		if _, err := NewBitsetE(params[0], params[1]); !errors.Is(err, ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters for size %d and hash iterations %d, got %v", params[0], params[1], err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewBitset should panic when the size is zero")
+		}
+	}()
+	NewBitset(0, 7)
+}
+
+func TestBitsetTotalBits(t *testing.T) {
+	// The size is the bit budget of the whole filter, split between the partitions and rounded up.
+	tests := []struct {
+		size, hashIter uint
+		opts           []Option
+		total, bits    uint
+	}{
+		{1000, 4, nil, 1000, 250},
+		{1000, 7, nil, 1001, 143},
+		{1000, 7, []Option{WithPartitions(1)}, 1000, 1000},
+		{1000, 7, []Option{WithExactSize()}, 1000, 143},
+	}
+
+	for _, test := range tests {
+		b := NewBitset(test.size, test.hashIter, test.opts...)
+		if total := b.TotalBits(); total != test.total {
+			t.Fatalf("expected %d bits for size %d and %d hash iterations, got %d", test.total, test.size, test.hashIter, total)
+		}
+		if bits := b.filters[0].storage.(*BitsetStorage).size; bits != test.bits {
+			t.Fatalf("expected the first partition storage to hold %d bits, got %d", test.bits, bits)
+		}
+		if stats := b.Stats(); stats.TotalBits != test.total || stats.BitsPerPartition != test.bits {
+			t.Fatalf("expected Stats to report %d bits and %d per partition, got %+v", test.total, test.bits, stats)
+		}
+	}
+
+	n, p := uint(1000), 0.01
+	if b := NewBitsetWithEstimate(n, p); b.TotalBits() < OptimalM(n, p) || b.TotalBits() >= OptimalM(n, p)+OptimalK(n, OptimalM(n, p)) {
+		t.Fatalf("expected the estimated filter to hold OptimalM bits, rounded up to its partitions, got %d", b.TotalBits())
+	}
+}
+
+func TestBitsetExactSize(t *testing.T) {
+	tests := []struct {
+		size, hashIter uint
+		opts           []Option
+		sizes          []uint
+	}{
+		{1000, 7, nil, []uint{143, 143, 143, 143, 143, 143, 143}},
+		{1000, 7, []Option{WithExactSize()}, []uint{143, 143, 143, 143, 143, 143, 142}},
+		{1000, 7, []Option{WithExactSize(), WithPartitions(3)}, []uint{334, 333, 333}},
+		{1001, 7, []Option{WithExactSize()}, []uint{143, 143, 143, 143, 143, 143, 143}},
+		{3, 7, []Option{WithExactSize()}, []uint{1, 1, 1, 1, 1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		b := NewBitset(test.size, test.hashIter, test.opts...)
+
+		var sizes []uint
+		for _, f := range b.partitions() {
+			sizes = append(sizes, f.partitionBits)
+		}
+		if !reflect.DeepEqual(sizes, test.sizes) {
+			t.Fatalf("expected the partition sizes %v for size %d, got %v", test.sizes, test.size, sizes)
+		}
+
+		var total uint
+		for _, size := range test.sizes {
+			total += size
+		}
+		if size, _ := b.Parameters(); size != total {
+			t.Fatalf("expected %d bits in total, got %d", total, size)
+		}
+	}
+
+	b := NewBitset(1000, 7, WithExactSize())
+	b.Add(Value("afi"))
+	b.Save()
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBitset(1, 1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Equal(b) {
+		t.Fatal("partitions of different sizes should survive a serialization round trip")
+	}
+
+	rehashed, err := b.Rehash(2000, 7, func(yield func(Value)) { yield(Value("afi")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := rehashed.Parameters(); size != 2000 {
+		t.Fatalf("expected Rehash to keep the exact size, got %d bits", size)
+	}
+}
+
+func TestBitsetMaxBytes(t *testing.T) {
+	b := NewBitset(15000, 7)
+	if bytes := b.MemoryBytes(); bytes != 7*34*8 {
+		t.Fatalf("expected 7 partitions of 34 words, got %d bytes", bytes)
+	}
+
+	if _, err := NewBitsetE(math.MaxUint32, 7, WithMaxBytes(1<<20)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge above the limit, got %v", err)
+	}
+	if ^uint(0)>>32 > 0 {
+		if _, err := NewBitsetE(^uint(0), 7); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("expected ErrTooLarge above the default limit, got %v", err)
+		}
+	}
+
+	b, err := NewBitsetE(15000, 7, WithMaxBytes(7*34*8))
+	if err != nil {
+		t.Fatalf("a filter of exactly the limit should be allowed, got %v", err)
+	}
+	if bytes := b.MemoryBytes(); bytes != 7*34*8 {
+		t.Fatalf("expected 7 partitions of 34 words, got %d bytes", bytes)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("NewBitsetAtomic should panic with ErrTooLarge, got %v", err)
+		}
+	}()
+	NewBitsetAtomic(15000, 7, WithMaxBytes(100))
+}
+
+func TestBitsetHashMixing(t *testing.T) {
+	b := NewBitset(10000, 40, WithPartitions(1), WithHashMixing())
+
+	for i := 0; i < 1000; i++ {
+		distinct := map[uint]bool{}
+		for _, position := range b.BitPositions([]byte(fmt.Sprintf("afi.%d", i))) {
+			distinct[position] = true
+		}
+		if len(distinct) < 36 {
+			t.Fatalf("expected at least 90%% of the 40 positions of afi.%d to be distinct, got %d", i, len(distinct))
+		}
+	}
+
+	// The second half of the hash is a multiple of the size, so double hashing sets a single bit.
+	plain := NewBitset(10000, 40, WithPartitions(1))
+	counts := map[*BF]int{}
+	for _, f := range []*BF{plain, b} {
+		distinct := map[uint]bool{}
+		for _, filter := range f.filters {
+			distinct[filter.position(5, 20000)] = true
+		}
+		counts[f] = len(distinct)
+	}
+	if counts[plain] != 1 || counts[b] < 36 {
+		t.Fatalf("expected 1 distinct position with double hashing and at least 36 mixed, got %d and %d", counts[plain], counts[b])
+	}
+
+	b.Add(Value("afi"))
+	b.Save()
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBitset(1, 1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Equal(b) || restored.filters[0].scheme != mixedHashing {
+		t.Fatal("the hashing scheme should survive a serialization round trip")
+	}
+
+	rehashed, err := b.Rehash(20000, 40, func(yield func(Value)) { yield(Value("afi")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehashed.filters[0].scheme != mixedHashing {
+		t.Fatal("Rehash should keep the hashing scheme")
+	}
+}
+
+func TestBitsetPartitionIndependence(t *testing.T) {
+	b := NewBitset(7*1009, 7)
+
+	const values = 20000
+	random := rand.New(rand.NewSource(33))
+	positions := make([][]float64, len(b.filters))
+	for i := 0; i < values; i++ {
+		value := make([]byte, 16)
+		random.Read(value)
+
+		x, y := b.filters[0].hashValue(value)
+		for k, f := range b.filters {
+			positions[k] = append(positions[k], float64(f.position(x, y)))
+		}
+	}
+
+	// Positions in different partitions should be uncorrelated. The standard error of the correlation of
+	// n independent samples is about 1/sqrt(n), so this allows for roughly four standard errors.
+	for i := range positions {
+		for j := i + 1; j < len(positions); j++ {
+			if r := correlation(positions[i], positions[j]); math.Abs(r) > 4/math.Sqrt(values) {
+				t.Fatalf("positions of partitions %d and %d are correlated, r = %f", i, j, r)
+			}
+		}
+	}
+}
+
+// correlation returns the Pearson correlation coefficient of x and y.
+func correlation(x, y []float64) float64 {
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/float64(len(x)), sumY/float64(len(y))
+
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+
+	return cov / math.Sqrt(varX*varY)
+}
+
+func TestBitsetEnhancedDoubleHashing(t *testing.T) {
+	b := NewBitset(1000000, 30, WithEnhancedDoubleHashing())
+
+	random := rand.New(rand.NewSource(34))
+	for i := 0; i < 10000; i++ {
+		value := make(Value, 16)
+		random.Read(value)
+		b.Add(value)
+	}
+	b.Save()
+
+	// Every partition should have its set bits spread evenly over ten buckets, each expected to hold
+	// about a tenth of the bits.
+	const buckets = 10
+	for index, f := range b.filters {
+		store := f.storage.(*BitsetStorage)
+
+		counts := make([]uint, buckets)
+		for bit, ok := store.store.NextSet(0); ok; bit, ok = store.store.NextSet(bit + 1) {
+			counts[bit*buckets/f.partitionBits]++
+		}
+
+		expected := float64(store.store.Count()) / buckets
+		for bucket, count := range counts {
+			if math.Abs(float64(count)-expected) > 0.2*expected {
+				t.Fatalf("partition %d bucket %d holds %d bits, expected about %.0f", index, bucket, count, expected)
+			}
+		}
+	}
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.filters[0].scheme != enhancedDoubleHashing {
+		t.Fatal("the hashing scheme should be restored from the serialized filter")
+	}
+	if err := restored.Union(NewBitset(1000000, 30)); err == nil {
+		t.Fatal("filters using different hashing schemes shouldn't be merged")
+	}
+}
+
+func TestBitsetStandard(t *testing.T) {
+	const size, hashIter, n, probes = 10000, 7, 1000, 200000
+
+	standard := NewBitsetStandard(size, hashIter)
+	partitioned := NewBitset(size, hashIter)
+
+	if m, k := standard.Parameters(); m != size || k != hashIter {
+		t.Fatalf("expected the standard filter to have %d bits and %d hash iterations, got %d and %d", size, hashIter, m, k)
+	}
+	if partitions := standard.Config().Partitions; partitions != 1 {
+		t.Fatalf("expected the standard filter to have a single partition, got %d", partitions)
+	}
+
+	// The classic false positive rate of a standard filter is (1 - e^(-kn/m))^k, while each partition of a
+	// partitioned filter only holds m/k bits, giving (1 - (1 - k/m)^n)^k.
+	expected := map[*BF]float64{
+		standard:    math.Pow(1-math.Exp(-float64(hashIter*n)/size), hashIter),
+		partitioned: math.Pow(1-math.Pow(1-float64(hashIter)/size, n), hashIter),
+	}
+
+	for b, rate := range expected {
+		for i := 0; i < n; i++ {
+			b.Add(Value(fmt.Sprintf("afi.%d", i)))
+		}
+		b.Save()
+
+		positives := 0
+		for i := 0; i < probes; i++ {
+			if exists, _ := b.Exists([]byte(fmt.Sprintf("langafi.%d", i))); exists {
+				positives++
+			}
+		}
+
+		measured := float64(positives) / probes
+		t.Logf("%d partitions: measured false positive rate %f, expected %f", b.Config().Partitions, measured, rate)
+		if math.Abs(measured-rate) > 0.3*rate {
+			t.Fatalf("measured false positive rate %f is too far from %f", measured, rate)
+		}
+	}
+
+	data, err := standard.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Fingerprint() != standard.Fingerprint() {
+		t.Fatal("the restored standard filter should keep its layout")
+	}
+	if exists, _ := restored.Exists([]byte("afi.7")); !exists {
+		t.Fatal("afi.7 should exist in the restored standard filter")
+	}
+	if err := restored.Union(partitioned); !errors.Is(err, ErrIncompatibleFilter) {
+		t.Fatalf("expected ErrIncompatibleFilter when merging standard and partitioned filters, got %v", err)
+	}
+}
+
+func TestBitsetWithPartitions(t *testing.T) {
+	for _, partitions := range []uint{1, 4, 7, 10} {
+		filters := map[string]*BF{
+			"Bitset":   NewBitset(15000, 7, WithPartitions(partitions)),
+			"atomic":   NewBitsetAtomic(15000, 7, WithPartitions(partitions)),
+			"counting": &NewCountingBitset(15000, 7, WithPartitions(partitions)).BF,
+		}
+
+		expected := partitions
+		if expected > 7 {
+			expected = 7
+		}
+
+		for backend, b := range filters {
+			size, hashIter := b.Parameters()
+			if hashIter != 7 || uint(len(b.partitions())) != expected {
+				t.Fatalf("expected 7 hash iterations over %d partitions in the %s backend, got %d over %d", expected, backend, hashIter, len(b.partitions()))
+			}
+			if size < 15000 || size >= 15000+expected {
+				t.Fatalf("expected the %s backend to split 15000 bits over %d partitions, got %d", backend, expected, size)
+			}
+
+			for i := 0; i < 100; i++ {
+				b.Add(Value(fmt.Sprintf("afi.%d", i)))
+			}
+			b.Save()
+
+			for i := 0; i < 100; i++ {
+				exists, err := b.Exists([]byte(fmt.Sprintf("afi.%d", i)))
+				if !exists {
+					t.Fatalf("afi.%d should exist in the %s backend with %d partitions", i, backend, partitions)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			exists, err := b.Exists([]byte("amma"))
+			if exists {
+				t.Fatalf("amma shouldn't exist in the %s backend with %d partitions", backend, partitions)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestBitsetBitPositions(t *testing.T) {
+	// These positions are part of the serialization contract and must never change.
+	for _, golden := range []struct {
+		b         *BF
+		positions []uint
+	}{
+		{NewBitset(15000, 7), []uint{1951, 1392, 833, 274, 1858, 1299, 740}},
+		{NewBitset(15000, 7, WithSeed(42)), []uint{718, 1351, 1984, 474, 1107, 1740, 230}},
+		{NewBitsetStandard(15000, 7), []uint{11357, 13379, 403, 2430, 4461, 6497, 8539}},
+	} {
+		positions := golden.b.BitPositions([]byte("afi"))
+		if !reflect.DeepEqual(positions, golden.positions) {
+			t.Fatalf("expected the bit positions of afi to be %v, got %v", golden.positions, positions)
+		}
+
+		golden.b.Add(Value("afi"))
+		golden.b.Save()
+		for index, f := range golden.b.filters {
+			if exists, _ := f.storage.Exists(positions[index]); !exists {
+				t.Fatalf("expected afi to set bit %d of partition filter %d", positions[index], index)
+			}
+		}
+	}
+}
+
+func TestBitsetWithEstimate(t *testing.T) {
+	var n uint = 10000
+	p := 0.01
+
+	b := NewBitsetWithEstimate(n, p)
+
+	size, hashIter := b.Parameters()
+	if size < 95850 || size > 95860 || hashIter != 7 {
+		t.Fatalf("expected m around 95851 and k 7, got m %d and k %d", size, hashIter)
+	}
+
+	random := rand.New(rand.NewSource(7))
+	for i := uint(0); i < n; i++ {
+		b.Append([]byte(fmt.Sprintf("present.%d", random.Int63())))
+	}
+	b.Save()
+
+	falsePositives := 0
+	tries := 100000
+	for i := 0; i < tries; i++ {
+		exists, err := b.Exists([]byte(fmt.Sprintf("absent.%d", random.Int63())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			falsePositives++
+		}
+	}
+
+	if rate := float64(falsePositives) / float64(tries); math.Abs(rate-p) > p*0.25 {
+		t.Fatalf("false positive rate %f isn't within tolerance of %f", rate, p)
+	}
+}
+
+func TestOptimalParameters(t *testing.T) {
+	for _, ref := range []struct {
+		n    uint
+		p    float64
+		m, k uint
+	}{
+		{1000000, 0.01, 9585059, 7},
+		{1000, 0.001, 14378, 10},
+		{100, 0.5, 145, 1},
+		{10, 1e-20, 959, 32},
+	} {
+		m := OptimalM(ref.n, ref.p)
+		if m != ref.m {
+			t.Fatalf("expected m = %d for n = %d and p = %v, got %d", ref.m, ref.n, ref.p, m)
+		}
+		if k := OptimalK(ref.n, m); k != ref.k {
+			t.Fatalf("expected k = %d for n = %d and m = %d, got %d", ref.k, ref.n, m, k)
+		}
+
+		size, hashIter := NewBitsetWithEstimate(ref.n, ref.p).Parameters()
+		if size < m || hashIter != ref.k {
+			t.Fatalf("expected NewBitsetWithEstimate to use m = %d and k = %d, got %d and %d", m, ref.k, size, hashIter)
+		}
+	}
+}
+
+func TestBitsetEstimateFalsePositiveRate(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	rate, err := b.EstimateFalsePositiveRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 0 {
+		t.Fatalf("empty filter should have a false positive rate of 0, got %f", rate)
+	}
+
+	n := 1500
+	for i := 0; i < n; i++ {
+		b.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+	b.Save()
+
+	rate, err = b.EstimateFalsePositiveRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := math.Pow(1-math.Exp(-7*float64(n)/15000), 7)
+	if math.Abs(rate-expected) > expected*0.2 {
+		t.Fatalf("estimated false positive rate %f isn't close to %f", rate, expected)
+	}
+}
+
+func TestBitsetIsEmpty(t *testing.T) {
+	for _, b := range []*BF{NewBitset(15000, 7), NewBitsetAtomic(15000, 7, WithPartitions(3))} {
+		for _, expected := range []bool{true, false} {
+			empty, err := b.IsEmpty()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if empty != expected {
+				t.Fatalf("expected the filter to be empty %t, got %t", expected, empty)
+			}
+
+			b.Add(Value("afi"))
+			b.Save()
+		}
+
+		b.Clear()
+		if empty, _ := b.IsEmpty(); !empty {
+			t.Fatal("the filter should be empty once cleared")
+		}
+	}
+}
+
+func TestBitsetEstimatedItemCount(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		b.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+	b.Save()
+
+	count, err := b.EstimatedItemCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(count)-float64(n)) > float64(n)*0.05 {
+		t.Fatalf("estimated item count %d isn't close to %d", count, n)
+	}
+
+	full := NewBitset(8, 1)
+	for i := 0; i < 100; i++ {
+		full.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+	full.Save()
+
+	if _, err := full.EstimatedItemCount(); err != ErrSaturated {
+		t.Fatalf("expected ErrSaturated from a full filter, got %v", err)
+	}
+}
+
+func TestBitsetWithHasher(t *testing.T) {
+	fnv1 := NewBitset(15000, 7)
+	fnv1a := NewBitset(15000, 7, WithHasher(fnv.New64a))
+
+	for _, b := range []*BF{fnv1, fnv1a} {
+		b.Append([]byte("afi"))
+		b.Save()
+
+		exists, err := b.Exists([]byte("afi"))
+		if !exists {
+			t.Fatal("afi should exist in the Bitset backend")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	same := true
+	for index := range fnv1.filters {
+		a := fnv1.filters[index].storage.(*BitsetStorage)
+		b := fnv1a.filters[index].storage.(*BitsetStorage)
+		if !a.store.Equal(b.store) {
+			same = false
+		}
+	}
+	if same {
+		t.Fatal("filters using different hashers shouldn't set the same bits")
+	}
+}
+
+func TestBitsetWithSeed(t *testing.T) {
+	unseeded := NewBitset(15000, 7)
+	seeded := NewBitset(15000, 7, WithSeed(42))
+	other := NewBitset(15000, 7, WithSeed(43))
+
+	for _, b := range []*BF{unseeded, seeded, other} {
+		b.Append([]byte("afi"))
+		b.Save()
+	}
+
+	for _, b := range []*BF{unseeded, other} {
+		for index := range seeded.filters {
+			a := seeded.filters[index].storage.(*BitsetStorage)
+			o := b.filters[index].storage.(*BitsetStorage)
+			if a.store.Equal(o.store) {
+				t.Fatalf("filters using different seeds shouldn't set the same bits in partition %d", index)
+			}
+		}
+	}
+
+	data, err := seeded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := restored.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the restored seeded filter")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := seeded.Union(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeded.Union(other); err == nil {
+		t.Fatal("filters using different seeds shouldn't be merged")
+	}
+}
+
+func TestBitsetUint64(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithSeed(42)}, {WithHasher(fnv.New64a)}, {WithEnhancedDoubleHashing()}} {
+		ids := NewBitset(15000, 7, opts...)
+		values := NewBitset(15000, 7, opts...)
+
+		for id := uint64(0); id < 100; id++ {
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], id)
+
+			ids.AddUint64(id)
+			values.Add(Value(buf[:]))
+		}
+		ids.Save()
+		values.Save()
+
+		for index := range ids.filters {
+			a := ids.filters[index].storage.(*BitsetStorage)
+			b := values.filters[index].storage.(*BitsetStorage)
+			if !a.store.Equal(b.store) {
+				t.Fatalf("integers should set the same bits as their big endian bytes in partition %d", index)
+			}
+		}
+
+		for id := uint64(0); id < 100; id++ {
+			exists, err := ids.ExistsUint64(id)
+			if !exists {
+				t.Fatalf("%d should exist in the Bitset backend", id)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestBitsetHash(t *testing.T) {
+	hashes := NewBitset(15000, 7)
+	values := NewBitset(15000, 7)
+
+	for i := 0; i < 100; i++ {
+		value := []byte(fmt.Sprintf("afi.%d", i))
+		h := fnv.New64()
+		h.Write(value)
+
+		hashes.AddHash(h.Sum64())
+		values.Add(value)
+	}
+	hashes.Save()
+	values.Save()
+
+	for index := range hashes.filters {
+		a := hashes.filters[index].storage.(*BitsetStorage)
+		b := values.filters[index].storage.(*BitsetStorage)
+		if !a.store.Equal(b.store) {
+			t.Fatalf("the FNV-1 hashes of the values should set the same bits as the values in partition %d", index)
+		}
+	}
+
+	h := fnv.New64()
+	h.Write([]byte("afi.0"))
+	exists, err := hashes.ExistsHash(h.Sum64())
+	if !exists {
+		t.Fatal("afi.0 should exist by its hash")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetRehash(t *testing.T) {
+	values := []Value{Value("afi"), Value("amma"), Value("langafi")}
+
+	b := NewBitset(1500, 3, WithSeed(37))
+	b.Add(values...)
+	b.Save()
+
+	rehashed, err := b.Rehash(15000, 7, func(yield func(Value)) {
+		for _, value := range values {
+			yield(value)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size, hashIter := rehashed.Parameters(); size != 15001 || hashIter != 7 {
+		t.Fatalf("expected the rehashed filter to have 15001 bits and 7 hash iterations, got %d and %d", size, hashIter)
+	}
+	if rehashed.filters[0].seed != 37 {
+		t.Fatal("the rehashed filter should keep the seed")
+	}
+
+	little := NewBitset(1500, 3, WithHashExtraction(binary.LittleEndian, 1, 3))
+	if rehashed, _ := little.Rehash(15000, 7, func(func(Value)) {}); rehashed.filters[0].extraction != little.filters[0].extraction {
+		t.Fatal("the rehashed filter should keep the hash extraction")
+	}
+
+	all, err := rehashed.ExistsAll(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all {
+		t.Fatal("every reinserted value should exist in the rehashed filter")
+	}
+
+	if _, err := b.Rehash(0, 7, func(func(Value)) {}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters, got %v", err)
+	}
+}
+
+// countingObserver counts the calls of every Observer method.
+type countingObserver struct {
+	adds, hits, misses int
+	fill               float64
+}
+
+func (o *countingObserver) IncAdd() { o.adds++ }
+func (o *countingObserver) IncQuery(hit bool) {
+	if hit {
+		o.hits++
+	} else {
+		o.misses++
+	}
+}
+func (o *countingObserver) SetFill(ratio float64) { o.fill = ratio }
+
+func TestBitsetWithObserver(t *testing.T) {
+	observer := &countingObserver{}
+	b := NewBitset(14000, 7, WithObserver(observer))
+
+	b.Add(Value("afi"), Value("amma"))
+	b.Append([]byte("langafi"))
+	b.Save()
+
+	b.Exists([]byte("afi"))
+	b.Exist(Value("amma"), Value("langamma"))
+	b.ExistsAll(Value("afi"), Value("langamma"))
+
+	if observer.adds != 3 {
+		t.Fatalf("expected 3 adds to be observed, got %d", observer.adds)
+	}
+	if observer.hits != 2 || observer.misses != 2 {
+		t.Fatalf("expected 2 hits and 2 misses to be observed, got %d and %d", observer.hits, observer.misses)
+	}
+	if observer.fill != 0.0015 {
+		t.Fatalf("expected a fill ratio of 0.0015 to be observed, got %g", observer.fill)
+	}
+}
+
+func TestBitsetWithCapacityThreshold(t *testing.T) {
+	var fills []float64
+	b := NewBitset(7000, 7, WithCapacityThreshold(0.5, func(fill float64) {
+		fills = append(fills, fill)
+	}))
+
+	for i := 0; i < 2000; i++ {
+		b.Add(Value(fmt.Sprintf("afi.%d", i)))
+		if err := b.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := b.Stats()
+		if len(fills) == 0 && stats.FillRatio >= 0.5 {
+			t.Fatalf("expected the callback once the fill ratio reached %v", stats.FillRatio)
+		}
+	}
+
+	if len(fills) != 1 || fills[0] < 0.5 || fills[0] > 0.51 {
+		t.Fatalf("expected a single callback right after crossing the threshold, got %v", fills)
+	}
+
+	var counted uint
+	for _, f := range b.partitions() {
+		counted += f.storage.(*BitsetStorage).store.Count()
+	}
+	if stats := b.Stats(); stats.SetBits != counted {
+		t.Fatalf("expected the running count of %d bits to match the Bitset, got %d", counted, stats.SetBits)
+	}
+
+	b.Clear()
+	b.Add(Value("afi"))
+	b.Save()
+	for i := 0; i < 1000; i++ {
+		b.Add(Value(fmt.Sprintf("afi.%d", i)))
+	}
+	b.Save()
+	if len(fills) != 2 {
+		t.Fatalf("expected another callback after clearing and filling up again, got %v", fills)
+	}
+}
+
+func TestBitsetSetBitCounter(t *testing.T) {
+	popcount := func(b *BF) (counted, cached uint) {
+		for _, f := range b.partitions() {
+			s := f.storage.(*BitsetStorage)
+			counted += s.store.Count()
+			cached += s.set
+		}
+		return
+	}
+	check := func(b *BF, step string) {
+		if counted, cached := popcount(b); counted != cached {
+			t.Fatalf("expected the counter to match the %d bits set after %s, got %d", counted, step, cached)
+		}
+	}
+
+	a := NewBitset(15000, 7, WithPartitions(3))
+	b := NewBitset(15000, 7, WithPartitions(3))
+
+	for i := 0; i < 100; i++ {
+		a.Add(Value(fmt.Sprintf("afi.%d", i)), Value(fmt.Sprintf("afi.%d", i)))
+		b.Add(Value(fmt.Sprintf("afi.%d", i+50)))
+	}
+	a.Save()
+	b.Save()
+	check(a, "duplicate adds")
+
+	a.Add(Value("afi.0"))
+	a.Save()
+	check(a, "adding a saved value again")
+
+	clone, err := a.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(clone, "cloning")
+
+	if err := a.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	check(a, "a union")
+
+	if err := clone.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+	check(clone, "an intersection")
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	check(&restored, "decoding")
+
+	a.Clear()
+	check(a, "clearing")
+	if counted, _ := popcount(a); counted != 0 {
+		t.Fatalf("expected no bits set once cleared, got %d", counted)
+	}
+}
+
+func TestBitsetClone(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"))
+	b.Save()
+
+	clone, err := b.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Add(Value("amma"))
+	b.Save()
+
+	exists, err := clone.Exist(Value("afi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || exists[1] {
+		t.Fatalf("expected only afi to exist in the cloned Bitset filter, got %v", exists)
+	}
+
+	clone.Add(Value("langafi"))
+	clone.Save()
+
+	if exists, _ := b.Exists([]byte("langafi")); exists {
+		t.Fatal("values added to the clone shouldn't exist in the original filter")
+	}
+}
+
+func TestBitsetClear(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	values := make([]Value, 100)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(values...)
+	b.Save()
+
+	if err := b.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	b.Save()
+
+	exists, err := b.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exist := range exists {
+		if exist {
+			t.Fatalf("%s shouldn't exist in the Bitset backend after Clear", values[i])
+		}
+	}
+}
+
+func TestBitsetUnion(t *testing.T) {
+	a := NewBitset(15000, 7)
+	b := NewBitset(15000, 7)
+
+	values := make([]Value, 200)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	a.Add(values[:100]...)
+	a.Save()
+	b.Add(values[100:]...)
+	b.Save()
+
+	if err := a.Union(b); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := a.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exist := range exists {
+		if !exist {
+			t.Fatalf("%s should exist in the union", values[i])
+		}
+	}
+
+	if err := a.Union(NewBitset(15000, 5)); err == nil {
+		t.Fatal("union of filters with different hash iterations should fail")
+	}
+	if err := a.Union(NewBitset(20000, 7)); err == nil {
+		t.Fatal("union of filters with different sizes should fail")
+	}
+	if err := a.Union(NewBitset(15000, 7, WithHasher(fnv.New64a))); err == nil {
+		t.Fatal("union of filters with different hashers should fail")
+	}
+}
+
+func TestBitsetIntersect(t *testing.T) {
+	a := NewBitset(15000, 7)
+	b := NewBitset(15000, 7)
+
+	values := make([]Value, 600)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	a.Add(values[:400]...)
+	a.Save()
+	b.Add(values[200:]...)
+	b.Save()
+
+	if err := a.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := a.Exist(values[200:400]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exist := range exists {
+		if !exist {
+			t.Fatalf("%s should exist in the intersection", values[200+i])
+		}
+	}
+
+	spurious := 0
+	exists, err = a.Exist(append(values[:200:200], values[400:]...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exist := range exists {
+		if exist {
+			spurious++
+		}
+	}
+	if rate := float64(spurious) / float64(len(exists)); rate > 0.05 {
+		t.Fatalf("spurious membership rate %f is too high", rate)
+	}
+
+	if err := a.Intersect(NewBitset(15000, 5)); err == nil {
+		t.Fatal("intersection of filters with different hash iterations should fail")
+	}
+}
+
+func TestBitsetEqual(t *testing.T) {
+	b := NewBitset(15000, 7)
+	b.Add(Value("afi"), Value("amma"))
+	b.Save()
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBitset(1, 1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Equal(restored) || !restored.Equal(b) {
+		t.Fatal("a filter should equal its serialization round trip")
+	}
+
+	union := NewBitset(15000, 7)
+	union.Add(Value("afi"))
+	union.Save()
+	other := NewBitset(15000, 7)
+	other.Add(Value("amma"))
+	other.Save()
+	if err := union.Union(other); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Equal(union) {
+		t.Fatal("the union of afi and amma should equal a filter of afi and amma")
+	}
+
+	if b.Equal(other) {
+		t.Fatal("filters with different bits shouldn't be equal")
+	}
+	if b.Equal(NewBitset(15000, 7, WithSeed(42))) || NewBitset(15000, 7).Equal(NewBitset(16000, 7)) {
+		t.Fatal("filters with different fingerprints shouldn't be equal")
+	}
+	if !NewBitset(15000, 7).Equal(NewBitsetAtomic(15000, 7)) {
+		t.Fatal("empty filters with the same parameters should be equal")
+	}
+}
+
+func TestBitsetJaccardSimilarity(t *testing.T) {
+	for _, overlap := range []int{0, 250, 500, 1000} {
+		a := NewBitset(50000, 7)
+		b := NewBitset(50000, 7)
+
+		for i := 0; i < 1000; i++ {
+			a.Add(Value(fmt.Sprintf("afi.%d", i)))
+			b.Add(Value(fmt.Sprintf("afi.%d", i+1000-overlap)))
+		}
+		a.Save()
+		b.Save()
+
+		similarity, err := a.JaccardSimilarity(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := float64(overlap) / float64(2000-overlap)
+		if math.Abs(similarity-expected) > 0.03 {
+			t.Fatalf("expected a similarity around %v for %d shared values, got %v", expected, overlap, similarity)
+		}
+	}
+
+	if _, err := NewBitset(50000, 7).JaccardSimilarity(NewBitset(50000, 7, WithSeed(42))); !errors.Is(err, ErrIncompatibleFilter) {
+		t.Fatalf("expected ErrIncompatibleFilter for filters using different seeds, got %v", err)
+	}
+}
+
+func TestBitsetFingerprint(t *testing.T) {
+	b := NewBitset(15000, 7)
+	if b.Fingerprint() != NewBitset(15000, 7).Fingerprint() {
+		t.Fatal("filters with the same parameters should share a fingerprint")
+	}
+
+	for _, other := range []*BF{
+		NewBitset(15000, 6),
+		NewBitset(16000, 7),
+		NewBitset(15000, 7, WithSeed(43)),
+		NewBitset(15000, 7, WithHasher(fnv.New64a)),
+		NewBitset(15000, 7, WithEnhancedDoubleHashing()),
+	} {
+		if b.Fingerprint() == other.Fingerprint() {
+			t.Fatal("filters with different parameters shouldn't share a fingerprint")
+		}
+		if err := b.Union(other); !errors.Is(err, ErrIncompatibleFilter) {
+			t.Fatalf("expected ErrIncompatibleFilter from Union, got %v", err)
+		}
+		if err := b.Intersect(other); !errors.Is(err, ErrIncompatibleFilter) {
+			t.Fatalf("expected ErrIncompatibleFilter from Intersect, got %v", err)
+		}
+	}
+
+	data, err := NewBitset(15000, 7, WithHasher(fnv.New64a)).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != ErrIncompatibleFilter {
+		t.Fatalf("expected ErrIncompatibleFilter when restoring with a different hasher, got %v", err)
+	}
+	if err := NewBitset(1, 1, WithHasher(fnv.New64a)).UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBitsetMarshalBinary(t *testing.T) {
+	b := NewBitset(150000, 7)
+
+	values := make([]Value, 20000)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(values[:10000]...)
+	b.Save()
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored BF
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := b.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := restored.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		if exists[i] != expected[i] {
+			t.Fatalf("%s membership changed after the round trip", values[i])
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated for a truncated buffer, got %v", err)
+	}
+	data[0] = encodingVersion + 1
+	if err := restored.UnmarshalBinary(data); err == nil {
+		t.Fatal("unmarshaling an unknown version should fail")
+	}
+}
+
+func TestBitsetGob(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	for i := 0; i < 1000; i++ {
+		b.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+	b.Save()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(b); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := new(BF)
+	if err := gob.NewDecoder(&buf).Decode(restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.filters) != len(b.filters) {
+		t.Fatalf("expected %d partitions after decoding, got %d", len(b.filters), len(restored.filters))
+	}
+	for index, f := range b.filters {
+		store := f.storage.(*BitsetStorage).store
+		if !store.Equal(restored.filters[index].storage.(*BitsetStorage).store) {
+			t.Fatalf("partition %d bits changed after the gob round trip", index)
+		}
+	}
+}
+
+func TestBitsetWriteTo(t *testing.T) {
+	b := NewBitset(150000, 7)
+
+	values := make([]Value, 20000)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	b.Add(values[:10000]...)
+	b.Save()
+
+	expected, err := b.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(rw io.ReadWriter, rewind func() error) {
+		written, err := b.WriteTo(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rewind(); err != nil {
+			t.Fatal(err)
+		}
+
+		var restored BF
+		read, err := restored.ReadFrom(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read != written {
+			t.Fatalf("read %d bytes but %d were written", read, written)
+		}
+
+		exists, err := restored.Exist(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range values {
+			if exists[i] != expected[i] {
+				t.Fatalf("%s membership changed after the round trip", values[i])
+			}
+		}
+	}
+
+	check(new(bytes.Buffer), func() error { return nil })
+
+	file, err := ioutil.TempFile("", "go-bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	check(file, func() error {
+		_, err := file.Seek(0, io.SeekStart)
+		return err
+	})
+}
+
+func TestBitsetReadFromConcurrency(t *testing.T) {
+	b := NewBitset(15000, 7, WithPartitions(3))
+	b.Add(Value("afi"), Value("amma"))
+	b.Save()
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrent := range []bool{false, true} {
+		var opts []Option
+		if concurrent {
+			opts = append(opts, WithConcurrency())
+		}
+		restored := NewBitset(15000, 7, opts...)
+		if _, err := restored.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		for index, f := range restored.partitions() {
+			store := f.storage.(*BitsetStorage)
+			if (store.mu != nil) != concurrent {
+				t.Fatalf("expected partition %d to be guarded by a mutex: %t, got %t", index, concurrent, store.mu != nil)
+			}
+			if count := b.filters[index].storage.(*BitsetStorage).set; store.set != count {
+				t.Fatalf("expected partition %d to count %d bits set, got %d", index, count, store.set)
+			}
+		}
+	}
+}
+
+func TestBitsetReadFromCorruptHeader(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, encodingHeader{encodingVersion, 1 << 40, 1})
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, doubleHashing)
+	buf.Write([]byte{0, 0, 4})
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, uint64(1))
+	binary.Write(&buf, binary.BigEndian, partitionHeader{1, 1 << 40})
+	buf.Write(make([]byte, 64))
+
+	var b BF
+	if _, err := b.ReadFrom(&buf); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated for a header claiming more bits than the stream holds, got %v", err)
+	}
+
+	buf.Reset()
+	binary.Write(&buf, binary.BigEndian, encodingHeader{encodingVersion, 64, 1 << 27})
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, doubleHashing)
+	buf.Write([]byte{0, 0, 4})
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, uint64(1))
+	binary.Write(&buf, binary.BigEndian, partitionHeader{1, 64})
+	buf.Write(make([]byte, 8))
+
+	if _, err := b.ReadFrom(&buf); err == nil || err == ErrTruncated {
+		t.Fatalf("expected an error for a header claiming %d hash iterations, got %v", 1<<27, err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewFile(file.Name(), 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Add(Value("afi"), Value("amma"))
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b.filters[0].storage.(*FileStorage).file.Close()
+
+	reopened, err := NewFile(file.Name(), 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.filters[0].storage.(*FileStorage).file.Close()
+
+	exists, err := reopened.Exist(Value("afi"), Value("amma"), Value("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exists, []bool{true, true, false}) {
+		t.Fatalf("expected afi and amma to exist in the reopened file, got %v", exists)
+	}
+
+	if stats := reopened.Stats(); stats.SetBits == 0 || stats.SetBits > 14 {
+		t.Fatalf("expected up to 14 bits set in the reopened file, got %d", stats.SetBits)
+	}
+
+	for _, opts := range [][]Option{{WithPartitions(3), WithSeed(42)}, {}} {
+		if _, err := NewFile(file.Name(), 15000, 7, opts...); err != ErrIncompatibleFilter {
+			t.Fatalf("expected ErrIncompatibleFilter reopening the file with other options, got %v", err)
+		}
+	}
+	if _, err := NewFile(file.Name(), 20000, 7, WithPartitions(3)); err != ErrIncompatibleFilter {
+		t.Fatalf("expected ErrIncompatibleFilter reopening the file with another size, got %v", err)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := reopened.Exists([]byte("afi")); exists {
+		t.Fatal("afi shouldn't exist once the file is cleared")
+	}
+}
+
+func TestFileSaveSyncsOnce(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-file-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewFile(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
 
-			if _, err := c.Do("AUTH", os.Getenv("REDIS_PASSWORD")); err != nil {
-				c.Close()
-				return nil, err
-			}
-			return c, nil
-		},
+	store := b.filters[0].storage.(*FileStorage)
+	b.Add(Value("afi"))
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(store.unsynced) != 1 {
+		t.Fatal("saving a partition shouldn't sync the file")
 	}
-}
 
-func TestRedisInit(t *testing.T) {
-	pool := newRedisPool(5)
-	defer pool.Close()
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for index, f := range b.filters {
+		if f.storage.(*FileStorage).unsynced != store.unsynced {
+			t.Fatalf("partition %d doesn't share the sync flag of the file", index)
+		}
+	}
+	if atomic.LoadUint32(store.unsynced) != 0 {
+		t.Fatal("Save should sync the file once every partition is saved")
+	}
+}
 
-	_, _, err := NewRedis(pool, "redis-init-test", 15000, 7, -1)
+func TestFileClose(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-file")
 	if err != nil {
 		t.Fatal(err)
 	}
+	file.Close()
+	defer os.Remove(file.Name())
 
-	conn := pool.Get()
-	defer conn.Close()
+	b, err := NewFile(file.Name(), 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	conn.Do("FLUSHALL")
-}
+	b.Add(Value("afi"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("closing the filter again should be safe, got %v", err)
+	}
+	if _, err := b.filters[0].storage.(*FileStorage).file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected Close to close the file, got %v", err)
+	}
 
-func TestRedisSave(t *testing.T) {
-	pool := newRedisPool(5)
-	defer pool.Close()
+	reopened, err := NewFile(file.Name(), 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
 
-	r, _, err := NewRedis(pool, "redis-save-test", 15000, 7, -1)
+	exists, err := reopened.Has([]byte("afi"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !exists {
+		t.Fatal("afi should be saved by Close")
+	}
 
-	r.Append([]byte("afi"))
-	r.Save()
+	bitset := NewBitset(15000, 7)
+	bitset.Add(Value("afi"))
+	if err := bitset.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := bitset.Has([]byte("afi")); !exists {
+		t.Fatal("afi should be saved by Close")
+	}
+}
 
-	exists, err := r.Exists([]byte("afi"))
-	if !exists {
-		t.Fatal("afi should exist in the Redis backend")
+func TestBitsetFilter(t *testing.T) {
+	type user struct {
+		ID   uint64
+		Name string
 	}
-	if err != nil {
+
+	f := NewBitsetFilter(15000, 7, func(u user) []byte {
+		key := make([]byte, 8, 8+len(u.Name))
+		binary.BigEndian.PutUint64(key, u.ID)
+		return append(key, u.Name...)
+	})
+
+	f.Add(user{1, "afi"}, user{2, "amma"})
+	if err := f.Save(); err != nil {
 		t.Fatal(err)
 	}
 
-	exists, err = r.Exists([]byte("amma"))
-	if exists {
-		t.Fatal("amma shouldn't exist in the Redis backend")
+	for u, expected := range map[user]bool{{1, "afi"}: true, {2, "amma"}: true, {1, "amma"}: false, {3, "afi"}: false} {
+		exists, err := f.Exists(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Fatalf("expected %v to exist %t, got %t", u, expected, exists)
+		}
 	}
-	if err != nil {
+
+	var buf bytes.Buffer
+	gobbed := NewFilter(f.BF(), func(s string) []byte {
+		buf.Reset()
+		gob.NewEncoder(&buf).Encode(s)
+		return buf.Bytes()
+	})
+	gobbed.Add("foo")
+	gobbed.Save()
+	if exists, _ := gobbed.Exists("foo"); !exists {
+		t.Fatal("foo should exist when encoded with gob")
+	}
+}
+
+func TestBitsetWidePartitions(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("partitions of more than 2^32 bits need 64 bit integers")
+	}
+
+	// The partitions are only set up, without allocating their 2^34 bits. The shifts aren't constant so
+	// the test compiles where it's skipped.
+	wide, narrow := uint(34), uint(32)
+	partitionBits := uint(1) << wide
+	for _, opts := range []options{{}, {scheme: enhancedDoubleHashing}, {scheme: mixedHashing}} {
+		filters := filterSetup(4*partitionBits, 4, opts)
+
+		high, highest := make([]int, len(filters)), make([]int, len(filters))
+		for i := 0; i < 1000; i++ {
+			a, b := filters[0].hashValue([]byte(fmt.Sprintf("afi.%d", i)))
+			for k, f := range filters {
+				bit := f.position(a, b)
+				if bit >= partitionBits {
+					t.Fatalf("position %d is outside of the partition", bit)
+				}
+				if bit > 1<<narrow {
+					high[k]++
+				}
+				if bit > partitionBits/4*3 {
+					highest[k]++
+				}
+			}
+		}
+		// Uniform positions are above 2^32 3 times out of 4, and in the last quarter once out of 4, in every
+		// partition.
+		for k := range filters {
+			if high[k] < 700 || highest[k] < 200 {
+				t.Fatalf("expected positions to span partition %d with scheme %d, got %d above 2^32 and %d in the last quarter out of 1000", k, opts.scheme, high[k], highest[k])
+			}
+		}
+	}
+
+	// Partitions of up to 2^32 bits keep their positions.
+	f := filterSetup(7<<narrow, 7, options{})[3]
+	a, b := f.hashValue([]byte("afi"))
+	if bit := f.position(a, b); bit != (a+b*4)%(1<<narrow) {
+		t.Fatalf("expected double hashing a partition of 2^32 bits to stay the same, got %d", bit)
+	}
+}
+
+func TestBitsetHugePartitions(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("partitions of more than 2^32 bits need 64 bit integers")
+	}
+
+	// Enhanced double hashing doesn't overflow, even with partitions of close to 2^64 bits. The sizes
+	// aren't constants so the test compiles where it's skipped.
+	large, largest := uint(50), uint(63)
+	for _, m := range []uint{1<<large + 3, 1<<largest + 12345, ^uint(0) - 58} {
+		filters := filterSetup(m, 32, options{partitions: 1, scheme: enhancedDoubleHashing})
+		size := new(big.Int).SetUint64(uint64(filters[0].partitionBits))
+
+		for i := 0; i < 100; i++ {
+			a, b := filters[0].hashValue([]byte(fmt.Sprintf("afi.%d", i)))
+			wa, wb := widen(a, b)
+			for _, f := range filters {
+				expected := new(big.Int).Mul(new(big.Int).SetUint64(uint64(wb)), new(big.Int).SetUint64(uint64(f.multiplier)))
+				expected.Add(expected, new(big.Int).SetUint64(uint64(wa)))
+				expected.Add(expected, new(big.Int).SetUint64(uint64(tetrahedral(f.multiplier))))
+				expected.Mod(expected, size)
+				if bit := f.position(a, b); uint64(bit) != expected.Uint64() {
+					t.Fatalf("expected bit %s of a partition of %d bits for multiplier %d, got %d", expected, f.partitionBits, f.multiplier, bit)
+				}
+			}
+		}
+	}
+}
+func TestBitsetDiscard(t *testing.T) {
+	b := NewBitset(15000, 7, WithConcurrency())
+	b.Add(Value("afi"))
+	if err := b.Save(); err != nil {
 		t.Fatal(err)
 	}
 
-	conn := pool.Get()
-	defer conn.Close()
+	b.Add(Value("amma"))
+	b.Discard()
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := b.Exists([]byte("amma")); exists {
+		t.Fatal("amma shouldn't exist once discarded")
+	}
+	if exists, _ := b.Exists([]byte("afi")); !exists {
+		t.Fatal("afi was saved before Discard and should still exist")
+	}
 
-	conn.Do("FLUSHALL")
+	atomic := NewBitsetAtomic(15000, 7)
+	atomic.Add(Value("amma"))
+	atomic.Discard()
+	if exists, _ := atomic.Exists([]byte("amma")); !exists {
+		t.Fatal("the atomic Bitset sets the bits as they're added, so Discard shouldn't remove them")
+	}
 }
 
-func TestBitsetSave(t *testing.T) {
-	b := NewBitset(15000, 7)
+func TestGenerateKeys(t *testing.T) {
+	keys := GenerateKeys(1000, 42)
+	if len(keys) != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", len(keys))
+	}
+	if !reflect.DeepEqual(keys, GenerateKeys(1000, 42)) {
+		t.Fatal("expected the same seed to generate the same keys")
+	}
+	if !reflect.DeepEqual(keys[:10], GenerateKeys(10, 42)) {
+		t.Fatal("expected fewer keys of the same seed to be a prefix")
+	}
 
-	b.Append([]byte("afi"))
-	b.Save()
+	seen := make(map[string]bool)
+	for _, key := range append(keys, GenerateKeys(1000, 43)...) {
+		if len(key) != 16 {
+			t.Fatalf("expected keys of 16 bytes, got %d", len(key))
+		}
+		if seen[string(key)] {
+			t.Fatalf("expected the keys to be distinct, got %x twice", key)
+		}
+		seen[string(key)] = true
+	}
 
-	exists, err := b.Exists([]byte("afi"))
+	// Appending to a key mustn't overwrite the next one.
+	_ = append(keys[0], 0)
+	if !bytes.Equal(keys[1], GenerateKeys(2, 42)[1]) {
+		t.Fatal("expected the keys not to share their capacity")
+	}
+	if GenerateKeys(0, 42) != nil {
+		t.Fatal("expected no keys for n = 0")
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	c := NewNegativeCache(NewBitset(15000, 7))
+
+	for _, key := range []string{"afi", "amma"} {
+		if err := c.Learn([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hits, misses := 0, 0
+	for key, learned := range map[string]bool{"afi": true, "amma": true, "langafi": false, "naab": false} {
+		query, err := c.ShouldQuery([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if learned && !query {
+			t.Fatalf("learned key %s should be queried", key)
+		}
+		if query {
+			misses++
+		} else {
+			hits++
+		}
+	}
+	for i := 0; i < 100; i++ {
+		query, _ := c.ShouldQuery([]byte(fmt.Sprintf("unknown.%d", i)))
+		if query {
+			misses++
+		} else {
+			hits++
+		}
+	}
+
+	stats := c.Stats()
+	if stats != (NegativeCacheStats{uint64(hits), uint64(misses), 2}) {
+		t.Fatalf("expected %d hits, %d misses and 2 learned keys, got %+v", hits, misses, stats)
+	}
+	if hits < 100 {
+		t.Fatalf("expected most unknown keys to be skipped, got %d hits", hits)
+	}
+	if rate := c.HitRate(); rate != float64(hits)/float64(hits+misses) {
+		t.Fatalf("unexpected hit rate %f", rate)
+	}
+	if rate := NewNegativeCache(NewBitset(15000, 7)).HitRate(); rate != 0 {
+		t.Fatalf("expected a zero hit rate without queries, got %f", rate)
+	}
+
+	failing := NewBitset(15000, 7)
+	failing.filters[2].storage = failingStorage{failing.filters[2].storage.(*BitsetStorage)}
+	c = NewNegativeCache(failing)
+	if err := c.Learn([]byte("afi")); err == nil {
+		t.Fatal("Learn should fail when the filter can't be saved")
+	}
+	if stats := c.Stats(); stats.Learned != 0 {
+		t.Fatalf("a key which failed to be learned shouldn't be counted, got %+v", stats)
+	}
+}
+
+func TestCountingRemove(t *testing.T) {
+	c := NewCountingBitset(15000, 7)
+
+	c.Add(Value("afi"), Value("amma"))
+	c.Save()
+
+	if !c.Remove([]byte("afi")) {
+		t.Fatal("afi should be removed from the counting filter")
+	}
+	if c.Remove([]byte("langafi")) {
+		t.Fatal("langafi was never added so it shouldn't be removed")
+	}
+
+	exists, err := c.Exists([]byte("afi"))
+	if exists {
+		t.Fatal("afi shouldn't exist after being removed")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = c.Exists([]byte("amma"))
 	if !exists {
-		t.Fatal("afi should exist in the Bitset backend")
+		t.Fatal("amma should still exist after removing afi")
 	}
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	exists, err = b.Exists([]byte("amma"))
-	if exists {
-		t.Fatal("amma shouldn't exist in the Bitset backend")
+	saturated := NewCountingBitset(1, 1)
+	for i := 0; i < 300; i++ {
+		saturated.Append([]byte("afi"))
+	}
+	saturated.Save()
+	saturated.Remove([]byte("afi"))
+
+	exists, err = saturated.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should still exist since its counter is saturated")
 	}
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func BenchmarkRedisQueueAppend(b *testing.B) {
-	pool := newRedisPool(7)
-	defer pool.Close()
+func TestScalableBitset(t *testing.T) {
+	var initialN uint = 1000
+	p := 0.01
 
-	r, _, err := NewRedis(pool, "redis-queue-append-benchmark", 15000, 7, -1)
-	if err != nil {
-		b.Fatal(err)
+	s := NewScalableBitset(initialN, p, 2)
+
+	n := int(initialN) * 10
+	for i := 0; i < n; i++ {
+		s.Add(Value(fmt.Sprintf("present.%d", i)))
 	}
 
-	for i := 0; i < b.N; i++ {
-		r.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	if len(s.filters) < 2 {
+		t.Fatalf("expected the scalable filter to grow, got %d filters", len(s.filters))
+	}
+
+	for i := 0; i < n; i++ {
+		exists, err := s.Exists([]byte(fmt.Sprintf("present.%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("present.%d should exist in the scalable filter", i)
+		}
+	}
+
+	falsePositives := 0
+	tries := 100000
+	for i := 0; i < tries; i++ {
+		exists, err := s.Exists([]byte(fmt.Sprintf("absent.%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			falsePositives++
+		}
+	}
+
+	if rate := float64(falsePositives) / float64(tries); rate > p {
+		t.Fatalf("false positive rate %f is above the target %f", rate, p)
+	}
+}
+
+func TestScalableBitsetZeroInitialN(t *testing.T) {
+	s := NewScalableBitset(0, 0.01, 2)
+	for i := 0; i < 100; i++ {
+		s.Add(Value(fmt.Sprintf("present.%d", i)))
+	}
+
+	// Capacities of 1, 2, 4 and so on hold 100 values in 7 filters.
+	if len(s.filters) != 7 {
+		t.Fatalf("expected the capacities to double from 1, got %d filters for 100 values", len(s.filters))
+	}
+	for i := 0; i < 100; i++ {
+		if exists, err := s.Exists([]byte(fmt.Sprintf("present.%d", i))); err != nil || !exists {
+			t.Fatalf("present.%d should exist in the scalable filter, got %t, %v", i, exists, err)
+		}
+	}
+}
+
+func TestScalableBitsetFillRatio(t *testing.T) {
+	s := NewScalableBitset(100, 0.01, 2)
+	for i := 0; i < 10000; i++ {
+		s.Add(Value(fmt.Sprintf("present.%d", i%50)))
+	}
+	if len(s.filters) != 1 {
+		t.Fatalf("adding the same values again shouldn't grow the chain, got %d filters", len(s.filters))
+	}
+
+	for i := 0; i < 1000; i++ {
+		s.Add(Value(fmt.Sprintf("present.%d", i)))
+	}
+	for index, f := range s.filters[:len(s.filters)-1] {
+		if fill := f.Stats().FillRatio; fill < scalableFillRatio {
+			t.Fatalf("filter %d was sealed at a fill ratio of %f", index, fill)
+		}
+	}
+}
+
+func TestStableBitset(t *testing.T) {
+	s := NewStableBitset(10000, 3, 3)
+
+	zeros := func() float64 {
+		count := 0
+		for _, cell := range s.cells {
+			if cell == 0 {
+				count++
+			}
+		}
+		return float64(count) / float64(len(s.cells))
+	}
+
+	var stable []float64
+	for i := 0; i < 200000; i++ {
+		value := Value(fmt.Sprintf("afi.%d", i))
+		s.Add(value)
+
+		exists, _ := s.Exists(value)
+		if !exists {
+			t.Fatalf("afi.%d should exist right after being added", i)
+		}
+
+		if (i+1)%50000 == 0 {
+			stable = append(stable, zeros())
+		}
+	}
+
+	if len(s.cells) != 10000 || cap(s.cells) != 10000 {
+		t.Fatalf("expected the stable bloom filter to keep 10000 cells, got %d", len(s.cells))
+	}
+	for _, z := range stable[1:] {
+		if math.Abs(z-stable[0]) > 0.05 {
+			t.Fatalf("expected the fraction of empty cells to stay stable, got %v", stable)
+		}
+	}
+
+	forgotten := 0
+	for i := 0; i < 1000; i++ {
+		if exists, _ := s.Exists([]byte(fmt.Sprintf("afi.%d", i))); !exists {
+			forgotten++
+		}
+	}
+	if forgotten < 900 {
+		t.Fatalf("expected the oldest values to age out, only %d of 1000 did", forgotten)
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if exists, _ := s.Exists([]byte(fmt.Sprintf("amma.%d", i))); exists {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.02 {
+		t.Fatalf("expected a false positive rate around 1%% at the stable point, got %v", rate)
+	}
+}
+
+func TestTimedBitset(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTimedBitset(time.Minute, 3, 1000, 4, func() time.Time { return now })
+
+	b.Add(Value("afi"))
+	now = now.Add(25 * time.Second)
+	b.Add(Value("amma"))
+
+	for _, value := range []string{"afi", "amma"} {
+		exists, err := b.Exists([]byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("%s should exist within the window", value)
+		}
+	}
+
+	// afi was added in the first bucket, which is cleared when the fourth one starts at 60s.
+	now = now.Add(35 * time.Second)
+	exists, _ := b.Exists([]byte("afi"))
+	if exists {
+		t.Fatal("afi should have expired after the window")
+	}
+	exists, _ = b.Exists([]byte("amma"))
+	if !exists {
+		t.Fatal("amma should still exist, it was added 35s ago")
+	}
+
+	now = now.Add(time.Hour)
+	exists, _ = b.Exists([]byte("amma"))
+	if exists {
+		t.Fatal("amma should have expired after the window")
 	}
 
-	conn := pool.Get()
-	defer conn.Close()
+	b.Add(Value("nanay"))
+	exists, _ = b.Exists([]byte("nanay"))
+	if !exists {
+		t.Fatal("nanay should exist right after being added")
+	}
 
-	conn.Do("FLUSHALL")
+	r := NewTimedBitset(time.Millisecond, 2, 1000, 4)
+	defer r.Stop()
+	r.Add(Value("afi"))
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	epoch := r.epoch
+	r.mu.Unlock()
+	if epoch == 0 {
+		t.Fatal("expected the buckets to be rotated in the background")
+	}
 }
 
-func BenchmarkRedisSave(b *testing.B) {
-	pool := newRedisPool(7)
-	defer pool.Close()
+func TestBitsetString(t *testing.T) {
+	strFilter := NewBitset(15000, 7)
+	byteFilter := NewBitset(15000, 7)
+
+	strFilter.AddString("afi", "amma")
+	strFilter.Save()
+	byteFilter.Add(Value("afi"), Value("amma"))
+	byteFilter.Save()
 
-	r, _, err := NewRedis(pool, "redis-save-benchmark", 15000, 7, -1)
+	for index, f := range strFilter.filters {
+		if !f.storage.(*BitsetStorage).store.Equal(byteFilter.filters[index].storage.(*BitsetStorage).store) {
+			t.Fatalf("partition %d differs between AddString and Add", index)
+		}
+	}
+
+	exists, err := strFilter.ExistsString("afi")
+	if !exists {
+		t.Fatal("afi should exist in the Bitset backend")
+	}
 	if err != nil {
-		b.Fatal(err)
+		t.Fatal(err)
 	}
 
-	for i := 0; i < b.N; i++ {
-		r.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	batch, err := strFilter.ExistString("afi", "langafi", "amma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !batch[0] || batch[1] || !batch[2] {
+		t.Fatalf("expected only afi and amma to exist in the Bitset backend, got %v", batch)
 	}
-	r.Save()
 
-	conn := pool.Get()
-	defer conn.Close()
+	seeded := NewBitset(15000, 7, WithSeed(42), WithHashExtraction(binary.LittleEndian, 4, 0))
+	for _, f := range seeded.filters {
+		a, h := f.hashString("afi")
+		if ea, eh := f.hashValue([]byte("afi")); a != ea || h != eh {
+			t.Fatalf("expected the string hash %d, %d to match the byte slice hash %d, %d", a, h, ea, eh)
+		}
+	}
 
-	conn.Do("FLUSHALL")
+	if allocs := testing.AllocsPerRun(100, func() { strFilter.ExistsString("afi") }); allocs != 0 {
+		t.Fatalf("expected ExistsString not to allocate with the default hasher, got %v allocations", allocs)
+	}
 }
 
-func BenchmarkRedisExists(b *testing.B) {
-	pool := newRedisPool(7)
-	defer pool.Close()
+func TestBitsetStats(t *testing.T) {
+	b := NewBitset(14000, 7)
+
+	stats := b.Stats()
+	if stats.Partitions != 7 || stats.BitsPerPartition != 2000 || stats.TotalBits != 14000 || stats.HashIterations != 7 {
+		t.Fatalf("unexpected filter metadata %+v", stats)
+	}
+	if stats.SetBits != 0 || stats.FillRatio != 0 || stats.EstimatedFPRate != 0 {
+		t.Fatalf("empty filter shouldn't have any bits set, got %+v", stats)
+	}
+
+	b.Append([]byte("afi"))
+	b.Save()
+
+	stats = b.Stats()
+	if stats.SetBits != 7 || stats.FillRatio != 0.0005 {
+		t.Fatalf("expected 7 bits to be set, got %+v", stats)
+	}
+	if expected := math.Pow(1.0/2000, 7); math.Abs(stats.EstimatedFPRate-expected) > 1e-30 {
+		t.Fatalf("expected a false positive rate of %g, got %g", expected, stats.EstimatedFPRate)
+	}
 
-	r, _, err := NewRedis(pool, "redis-exists-benchmark", 15000, 7, -1)
+	data, err := json.Marshal(stats)
 	if err != nil {
-		b.Fatal(err)
+		t.Fatal(err)
 	}
+	if !bytes.Contains(data, []byte(`"set_bits":7`)) {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
 
-	r.Append([]byte("afi.7500"))
-	r.Save()
+func TestBitsetTTL(t *testing.T) {
+	b := NewBitset(15000, 7)
+
+	if _, err := b.TTL(); err != ErrUnsupportedBackend {
+		t.Fatalf("expected ErrUnsupportedBackend, got %v", err)
+	}
+	if err := b.Persist(); err != ErrUnsupportedBackend {
+		t.Fatalf("expected ErrUnsupportedBackend, got %v", err)
+	}
+}
 
-	conn := pool.Get()
-	defer conn.Close()
+func TestBitsetConfig(t *testing.T) {
+	tests := []struct {
+		b       *BF
+		backend string
+	}{
+		{NewBitset(14000, 7), "bitset"},
+		{NewBitsetAtomic(14000, 7), "atomic"},
+		{&NewCountingBitset(14000, 7).BF, "counting"},
+	}
 
-	conn.Do("FLUSHALL")
+	for _, test := range tests {
+		config := test.b.Config()
+		if config != (Config{14000, 7, 7, test.backend, ""}) {
+			t.Fatalf("unexpected %s config %+v", test.backend, config)
+		}
+	}
 
-	for i := 0; i < b.N; i++ {
-		r.Exists([]byte("afi.7500"))
+	data, err := json.Marshal(NewBitset(14000, 7).Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"size":14000,"hash_iter":7,"partitions":7,"backend":"bitset"}` {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
+
+// workloadConfigs are the sizes and hash iterations compared by the workload benchmarks, for 10000 values
+// with false positive rates of about 10%, 1% and 0.1%.
+var workloadConfigs = []struct{ size, hashIter uint }{{47926, 3}, {95851, 7}, {143776, 10}}
+
+// BenchmarkBitsetWorkload adds and checks the keys of GenerateKeys in Bitset backed filters of every
+// workloadConfigs size, reporting the false positive rate of the keys of another seed, so configurations
+// can be compared with: go test -bench BitsetWorkload -run '^$'.
+func BenchmarkBitsetWorkload(b *testing.B) {
+	keys, absent := GenerateKeys(10000, 1), GenerateKeys(10000, 2)
+	for _, config := range workloadConfigs {
+		name := fmt.Sprintf("m=%d/k=%d", config.size, config.hashIter)
+
+		b.Run(name+"/add", func(b *testing.B) {
+			bf := NewBitset(config.size, config.hashIter)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bf.Add(keys[i%len(keys)])
+				if i%1000 == 999 {
+					bf.Save()
+				}
+			}
+		})
+
+		b.Run(name+"/exists", func(b *testing.B) {
+			bf := NewBitset(config.size, config.hashIter)
+			bf.Add(keys...)
+			bf.Save()
+			rate := workloadFalsePositives(bf, absent)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bf.Exists(keys[i%len(keys)])
+			}
+			b.ReportMetric(rate, "fp_rate")
+		})
+	}
+}
+
+// workloadFalsePositives returns the share of the absent keys the bloom filter reports as present.
+func workloadFalsePositives(bf *BF, absent []Value) float64 {
+	exists, err := bf.Exist(absent...)
+	if err != nil {
+		return math.NaN()
 	}
 
-	conn.Do("FLUSHALL")
+	positives := 0
+	for _, present := range exists {
+		if present {
+			positives++
+		}
+	}
+	return float64(positives) / float64(len(absent))
 }
 
 func BenchmarkBitsetAppend(b *testing.B) {
@@ -181,6 +2869,7 @@ func BenchmarkBitsetSave(b *testing.B) {
 
 func BenchmarkBitsetExists(b *testing.B) {
 	bits := NewBitset(15000, 7)
+	b.ReportAllocs()
 
 	bits.Append([]byte("afi.7500"))
 	bits.Save()
@@ -189,3 +2878,103 @@ func BenchmarkBitsetExists(b *testing.B) {
 		bits.Exists([]byte("afi.7500"))
 	}
 }
+
+func BenchmarkBitsetExistsUint64(b *testing.B) {
+	bits := NewBitset(15000, 7)
+	b.ReportAllocs()
+
+	bits.AddUint64(7500)
+	bits.Save()
+
+	for i := 0; i < b.N; i++ {
+		bits.ExistsUint64(7500)
+	}
+}
+
+func BenchmarkBitsetExistsString(b *testing.B) {
+	bits := NewBitset(15000, 7)
+	b.ReportAllocs()
+
+	bits.AddString("afi.7500")
+	bits.Save()
+
+	for i := 0; i < b.N; i++ {
+		bits.ExistsString("afi.7500")
+	}
+}
+
+func BenchmarkBitsetConcurrentExists(b *testing.B) {
+	bits := NewBitset(15000, 7, WithConcurrency())
+
+	bits.Append([]byte("afi.7500"))
+	bits.Save()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bits.Exists([]byte("afi.7500"))
+		}
+	})
+}
+
+func BenchmarkBitsetConcurrentAdd(b *testing.B) {
+	bits := NewBitset(15000, 7, WithConcurrency())
+
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			bits.Add(Value(fmt.Sprintf("afi.%d", i)))
+			i++
+		}
+	})
+}
+
+func BenchmarkBitsetAtomicAdd(b *testing.B) {
+	bits := NewBitsetAtomic(15000, 7)
+
+	for i := 0; i < b.N; i++ {
+		bits.Add(Value(fmt.Sprintf("afi.%d", i)))
+	}
+}
+
+func BenchmarkBitsetAtomicAddBytes(b *testing.B) {
+	bits := NewBitsetAtomic(15000, 7)
+	b.ReportAllocs()
+
+	var buf [8]byte
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(buf[:], uint64(i))
+		bits.Add(Value(buf[:]))
+	}
+}
+
+func BenchmarkBitsetAtomicAddUint64(b *testing.B) {
+	bits := NewBitsetAtomic(15000, 7)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		bits.AddUint64(uint64(i))
+	}
+}
+
+func BenchmarkBitsetAtomicAddHash(b *testing.B) {
+	bits := NewBitsetAtomic(15000, 7)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		bits.AddHash(uint64(i) * 0x9e3779b97f4a7c15)
+	}
+}
+
+func BenchmarkBitsetAtomicConcurrentAdd(b *testing.B) {
+	bits := NewBitsetAtomic(15000, 7)
+
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			bits.Add(Value(fmt.Sprintf("afi.%d", i)))
+			i++
+		}
+	})
+}
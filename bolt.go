@@ -0,0 +1,278 @@
+//go:build !nobolt
+// +build !nobolt
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// NewBolt creates and returns a new bloom filter using a bbolt database as a backend, for embedded
+// deployments needing durability without a network service. The bits of every partition are kept in the
+// key of the bucket, in consecutive byte aligned ranges, and the key followed by .meta holds the same
+// header as the file backend. The bucket and the key are created if they don't exist, and an existing
+// header has to match the size, hash iterations and Fingerprint of the filter, otherwise
+// ErrIncompatibleFilter is returned. Saving the filter writes the bits of every partition in a single
+// write transaction, while checks use read-only transactions. The database belongs to the caller, so
+// Close leaves it open.
+func NewBolt(db *bbolt.DB, bucket, key string, size, hashIter uint, opts ...Option) (*BF, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	bloom := BF{filterSetup(size, hashIter, o), o.observer, o.saves, o.capacityWatch()}
+
+	value := &boltValue{db: db, bucket: []byte(bucket), key: []byte(key)}
+	var offset uint
+	for index, f := range bloom.filters {
+		if f.partition != index {
+			f.storage = bloom.filters[f.partition].storage
+		} else {
+			f.storage = &BoltStorage{value, offset, f.partitionBits, make([]uint, 0)}
+			offset += (f.partitionBits + 7) / 8 * 8
+		}
+		bloom.filters[index] = f
+	}
+	value.length = int(offset / 8)
+
+	header := fileHeader{fileMagic, uint64(size), uint64(hashIter), uint64(len(bloom.partitions())), bloom.Fingerprint()}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, header)
+	if err := value.init(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &bloom, nil
+}
+
+// boltValue is the value of a bbolt bucket holding the bits of every partition of a bloom filter, along
+// with the bits the partitions saved, waiting to be written in a single transaction once they're all
+// saved.
+type boltValue struct {
+	db      *bbolt.DB
+	bucket  []byte
+	key     []byte
+	length  int
+	mu      sync.Mutex
+	pending []uint
+}
+
+// init creates the bucket, and the key with its header if they don't exist, or checks the header and
+// length of the existing ones.
+func (v *boltValue) init(header []byte) error {
+	metaKey := append(append([]byte(nil), v.key...), ".meta"...)
+
+	return v.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(v.bucket)
+		if err != nil {
+			return err
+		}
+
+		existing := bucket.Get(metaKey)
+		if existing == nil {
+			if err := bucket.Put(metaKey, header); err != nil {
+				return err
+			}
+			return bucket.Put(v.key, make([]byte, v.length))
+		}
+		if !bytes.Equal(existing, header) {
+			return ErrIncompatibleFilter
+		}
+
+		return v.check(bucket.Get(v.key))
+	})
+}
+
+// check checks that the data of the key holds the bits of every partition.
+func (v *boltValue) check(data []byte) error {
+	if len(data) != v.length {
+		return fmt.Errorf("%w: key %s holds %d bytes instead of %d", ErrSizeMismatch, v.key, len(data), v.length)
+	}
+
+	return nil
+}
+
+// view calls fn with the data of the key in a read-only transaction. The data is only valid until fn
+// returns.
+func (v *boltValue) view(fn func(data []byte) error) error {
+	return v.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(v.bucket)
+		if bucket == nil {
+			return fmt.Errorf("bloom: bucket %s doesn't exist", v.bucket)
+		}
+
+		data := bucket.Get(v.key)
+		if err := v.check(data); err != nil {
+			return err
+		}
+		return fn(data)
+	})
+}
+
+// update calls fn with a copy of the data of the key in a write transaction, and puts it back if fn
+// doesn't fail.
+func (v *boltValue) update(fn func(data []byte)) error {
+	return v.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(v.bucket)
+		if bucket == nil {
+			return fmt.Errorf("bloom: bucket %s doesn't exist", v.bucket)
+		}
+
+		existing := bucket.Get(v.key)
+		if err := v.check(existing); err != nil {
+			return err
+		}
+
+		data := append([]byte(nil), existing...)
+		fn(data)
+		return bucket.Put(v.key, data)
+	})
+}
+
+// drop forgets the pending bits between the offset and the end of the size bits following it, which a
+// partition cleared or discarded.
+func (v *boltValue) drop(offset, size uint) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	kept := v.pending[:0]
+	for _, bit := range v.pending {
+		if bit < offset || bit >= offset+size {
+			kept = append(kept, bit)
+		}
+	}
+	v.pending = kept
+}
+
+// BoltStorage is a struct representing the bbolt backend for the bloom filter. Its bits start at the
+// offset of the value shared by the partitions, bit 0 being the most significant bit of its first byte.
+type BoltStorage struct {
+	value  *boltValue
+	offset uint
+	size   uint
+	queue  []uint
+}
+
+// Append appends the bit, which is to be saved, to the queue.
+func (s *BoltStorage) Append(bit uint) {
+	s.queue = append(s.queue, bit)
+}
+
+// Save hands the bits of the queue over to the value shared by the partitions, which writes them in a
+// single write transaction once every partition of the bloom filter is saved, instead of one per
+// partition.
+func (s *BoltStorage) Save() error {
+	if len(s.queue) == 0 {
+		return nil
+	}
+
+	s.value.mu.Lock()
+	for _, bit := range uniqueBits(s.queue) {
+		s.value.pending = append(s.value.pending, s.offset+bit)
+	}
+	s.value.mu.Unlock()
+
+	s.queue = s.queue[:0]
+	return nil
+}
+
+// sync writes the bits saved by the partitions in a single write transaction, keeping them for the next
+// Save if it fails.
+func (s *BoltStorage) sync() error {
+	s.value.mu.Lock()
+	pending := s.value.pending
+	s.value.pending = nil
+	s.value.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := s.value.update(func(data []byte) {
+		for _, bit := range pending {
+			data[bit/8] |= 0x80 >> (bit % 8)
+		}
+	})
+	if err != nil {
+		s.value.mu.Lock()
+		s.value.pending = append(pending, s.value.pending...)
+		s.value.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Exists checks if the given bit exists in the bbolt backend.
+func (s *BoltStorage) Exists(bit uint) (bool, error) {
+	exists, err := s.ExistsMany([]uint{bit})
+	if err != nil {
+		return false, err
+	}
+
+	return exists[0], nil
+}
+
+// ExistsMany checks if each of the given bits exists in the bbolt backend, in a single read-only
+// transaction.
+func (s *BoltStorage) ExistsMany(bits []uint) ([]bool, error) {
+	ret := make([]bool, len(bits))
+	err := s.value.view(func(data []byte) error {
+		for i, bit := range bits {
+			bit += s.offset
+			ret[i] = data[bit/8]&(0x80>>(bit%8)) != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set in the range of the partition.
+func (s *BoltStorage) Count() (uint, error) {
+	var count uint
+	err := s.value.view(func(data []byte) error {
+		for _, b := range data[s.offset/8 : s.offset/8+(s.size+7)/8] {
+			count += uint(bits.OnesCount8(b))
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Clear unsets every bit in the range of the partition, in its own write transaction, and empties the
+// queue, along with the bits of the partition waiting to be written.
+func (s *BoltStorage) Clear() error {
+	s.queue = s.queue[:0]
+	s.value.drop(s.offset, s.size)
+
+	return s.value.update(func(data []byte) {
+		region := data[s.offset/8 : s.offset/8+(s.size+7)/8]
+		for i := range region {
+			region[i] = 0
+		}
+	})
+}
+
+// discard empties the queue without handing its bits over to the value, and forgets the bits of the
+// partition waiting to be written since a failed Save.
+func (s *BoltStorage) discard() {
+	s.queue = s.queue[:0]
+	s.value.drop(s.offset, s.size)
+}
+
+// backend names the bbolt backend in Config.
+func (s *BoltStorage) backend() string {
+	return "bolt"
+}
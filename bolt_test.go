@@ -0,0 +1,198 @@
+//go:build !nobolt
+// +build !nobolt
+
+package bloom
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// openBolt opens the bbolt database of a temporary file, which the returned function removes.
+func openBolt(t *testing.T) (*bbolt.DB, func()) {
+	file, err := ioutil.TempFile("", "go-bloom-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err := bbolt.Open(file.Name(), 0600, nil)
+	if err != nil {
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func TestBolt(t *testing.T) {
+	db, remove := openBolt(t)
+	defer remove()
+
+	b, err := NewBolt(db, "filters", "bolt-test", 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := b.Config(); config.Backend != "bolt" {
+		t.Fatalf("expected the bolt backend, got %q", config.Backend)
+	}
+
+	b.Add(Value("afi"), Value("amma"))
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := db.Path()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reopened, err := NewBolt(db, "filters", "bolt-test", 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := reopened.Exist(Value("afi"), Value("amma"), Value("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exists, []bool{true, true, false}) {
+		t.Fatalf("expected afi and amma to exist in the reopened database, got %v", exists)
+	}
+	if stats := reopened.Stats(); stats.SetBits == 0 || stats.SetBits > 14 {
+		t.Fatalf("expected up to 14 bits set in the reopened database, got %d", stats.SetBits)
+	}
+
+	for _, opts := range [][]Option{{WithPartitions(3), WithSeed(42)}, {}} {
+		if _, err := NewBolt(db, "filters", "bolt-test", 15000, 7, opts...); err != ErrIncompatibleFilter {
+			t.Fatalf("expected ErrIncompatibleFilter reopening the key with other options, got %v", err)
+		}
+	}
+	if _, err := NewBolt(db, "filters", "bolt-test", 20000, 7, WithPartitions(3)); err != ErrIncompatibleFilter {
+		t.Fatalf("expected ErrIncompatibleFilter reopening the key with another size, got %v", err)
+	}
+	if _, err := NewBolt(db, "filters", "bolt-other-test", 20000, 7); err != nil {
+		t.Fatalf("expected another key of the bucket to hold another filter, got %v", err)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := reopened.Exists([]byte("afi")); exists {
+		t.Fatal("afi shouldn't exist once the database is cleared")
+	}
+}
+
+func TestBoltSizeMismatch(t *testing.T) {
+	db, remove := openBolt(t)
+	defer remove()
+
+	b, err := NewBolt(db, "filters", "bolt-size-test", 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("filters")).Put([]byte("bolt-size-test"), make([]byte, 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBolt(db, "filters", "bolt-size-test", 15000, 7); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch reopening a truncated value, got %v", err)
+	}
+	if _, err := b.Has(Value("afi")); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch checking a truncated value, got %v", err)
+	}
+	b.Add(Value("afi"))
+	if err := b.Save(); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch saving to a truncated value, got %v", err)
+	}
+	if pending := b.filters[0].storage.(*BoltStorage).value.pending; len(pending) == 0 {
+		t.Fatal("the bits of a failed Save should stay pending")
+	}
+}
+
+func TestBoltSaveSingleTransaction(t *testing.T) {
+	db, remove := openBolt(t)
+	defer remove()
+
+	b, err := NewBolt(db, "filters", "bolt-save-test", 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Add(Value("afi"))
+	store := b.filters[1].storage.(*BoltStorage)
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := b.Has(Value("afi")); err != nil || exists {
+		t.Fatalf("saving a partition shouldn't write its bits yet, got %t, %v", exists, err)
+	}
+
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if pending := store.value.pending; len(pending) != 0 {
+		t.Fatalf("expected Save to write the bits of every partition, got %d pending", len(pending))
+	}
+	if exists, err := b.Has(Value("afi")); err != nil || !exists {
+		t.Fatalf("afi should exist once saved, got %t, %v", exists, err)
+	}
+}
+
+func TestBoltClearPending(t *testing.T) {
+	db, remove := openBolt(t)
+	defer remove()
+
+	b, err := NewBolt(db, "filters", "bolt-pending-test", 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resize := func(length int) {
+		err := db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte("filters")).Put([]byte("bolt-pending-test"), make([]byte, length))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	value := b.filters[0].storage.(*BoltStorage).value
+
+	for _, reset := range []func() error{b.Clear, func() error { b.Discard(); return nil }} {
+		resize(10)
+		b.Add(Value("afi"))
+		if err := b.Save(); !errors.Is(err, ErrSizeMismatch) {
+			t.Fatalf("expected ErrSizeMismatch saving to a truncated value, got %v", err)
+		}
+		resize(value.length)
+
+		if err := reset(); err != nil {
+			t.Fatal(err)
+		}
+		if len(value.pending) != 0 {
+			t.Fatalf("expected the pending bits to be dropped, got %v", value.pending)
+		}
+		if err := b.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := b.Has(Value("afi")); err != nil || exists {
+			t.Fatalf("afi shouldn't be written once cleared or discarded, got %t, %v", exists, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bloom
+
+import (
+	"context"
+	"sync"
+)
+
+// capacityWatch calls the callback set WithCapacityThreshold once the fill ratio of the bloom filter
+// crosses the threshold.
+type capacityWatch struct {
+	ratio    float64
+	callback func(fill float64)
+	mu       sync.Mutex
+	crossed  bool
+}
+
+// WithCapacityThreshold calls cb with the fill ratio of the bloom filter, the ratio of bits set, after
+// the first Save that takes it to the given ratio or above. It's called again if the fill ratio drops
+// below the ratio, after Clear, and then crosses it once more. The Bitset backend keeps a count of the
+// bits set as they're saved, so checking the fill ratio is cheap, while other backends count their bits
+// after every Save, which costs a BITCOUNT per partition with the Redis backend. cb is called from Save,
+// so it shouldn't Save the bloom filter itself.
+func WithCapacityThreshold(ratio float64, cb func(fill float64)) Option {
+	return func(o *options) {
+		o.capacityRatio = ratio
+		o.capacityCallback = cb
+	}
+}
+
+// capacityWatch returns the capacityWatch set WithCapacityThreshold, or nil.
+func (o options) capacityWatch() *capacityWatch {
+	if o.capacityCallback == nil {
+		return nil
+	}
+
+	return &capacityWatch{ratio: o.capacityRatio, callback: o.capacityCallback}
+}
+
+// clone returns a capacityWatch calling the same callback, which has the same state as this one.
+func (w *capacityWatch) clone() *capacityWatch {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return &capacityWatch{ratio: w.ratio, callback: w.callback, crossed: w.crossed}
+}
+
+// checkCapacity calls the callback set WithCapacityThreshold if the fill ratio just crossed the
+// threshold. It's skipped if the bits can't be counted.
+func (b *BF) checkCapacity(ctx context.Context) {
+	w := b.capacity
+	if w == nil {
+		return
+	}
+
+	counts, err := b.counts(ctx)
+	if err != nil {
+		return
+	}
+
+	var set uint
+	for _, count := range counts {
+		set += count
+	}
+	size, _ := b.Parameters()
+	fill := float64(set) / float64(size)
+
+	w.mu.Lock()
+	crossed := fill >= w.ratio && !w.crossed
+	w.crossed = fill >= w.ratio
+	w.mu.Unlock()
+
+	if crossed {
+		w.callback(fill)
+	}
+}
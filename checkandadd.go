@@ -0,0 +1,159 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+)
+
+// checkAndAddScript sets the bit ARGV[i] of every key KEYS[i], and returns 1 if all of them were already
+// set. The keys are expired after ARGV[#KEYS + 1] seconds if it's positive.
+var checkAndAddScript = redis.NewScript(-1, `
+local present = 1
+for i, key in ipairs(KEYS) do
+  if redis.call('SETBIT', key, ARGV[i], 1) == 0 then
+    present = 0
+  end
+end
+local ttl = tonumber(ARGV[#KEYS + 1])
+if ttl > 0 then
+  for _, key in ipairs(KEYS) do
+    redis.call('EXPIRE', key, ttl)
+  end
+end
+return present
+`)
+
+// CheckAndAdd adds the value to a Redis backed bloom filter and reports whether it already existed, in a
+// single Lua script, so other clients can't add the value in between. Unlike Add, the value is saved right
+// away, without going through the queue. The script is evaluated with EVALSHA, and only sent with EVAL
+// when the server doesn't have it yet. In Redis Cluster every key of the filter has to be in the same
+// slot, by creating it WithClusterHashTag or WithSingleKey. Other backends return ErrUnsupportedBackend.
+func (b *BF) CheckAndAdd(value []byte) (wasPresent bool, err error) {
+	defer func() { b.observeQueries(err, wasPresent) }()
+
+	if len(b.filters) == 0 {
+		return false, nil
+	}
+
+	wasPresent, err = redis.Bool(b.evalSetBits(checkAndAddScript, value))
+	if err == nil && !wasPresent {
+		b.observeAdd(1)
+	}
+
+	return
+}
+
+// addObservedScript sets the bit ARGV[i] of every key KEYS[i], like checkAndAddScript, and returns the
+// number of bits which were already set.
+var addObservedScript = redis.NewScript(-1, `
+local set = 0
+for i, key in ipairs(KEYS) do
+  set = set + redis.call('SETBIT', key, ARGV[i], 1)
+end
+local ttl = tonumber(ARGV[#KEYS + 1])
+if ttl > 0 then
+  for _, key in ipairs(KEYS) do
+    redis.call('EXPIRE', key, ttl)
+  end
+end
+return set
+`)
+
+// AddObserved adds the value to a Redis backed bloom filter like CheckAndAdd, and returns how many of the
+// bits of its hash iterations were already set, to measure how novel the added values are: a value which
+// was already present returns the number of hash iterations, and a new one fewer. Since the bits are read
+// and set by a single Lua script, which Redis runs without interleaving other commands, the count is the
+// one of the bits right before they were set, and concurrent adds of the same value see the bits of the
+// ones that ran before. Hash iterations sharing a partition WithPartitions which happen to pick the same
+// bit count it as already set for the second one. It has the same requirements as CheckAndAdd, and other
+// backends return ErrUnsupportedBackend.
+func (b *BF) AddObserved(value []byte) (alreadySetBits int, err error) {
+	defer func() { b.observeQueries(err, alreadySetBits == len(b.filters)) }()
+
+	if len(b.filters) == 0 {
+		return 0, nil
+	}
+
+	alreadySetBits, err = redis.Int(b.evalSetBits(addObservedScript, value))
+	if err == nil && alreadySetBits < len(b.filters) {
+		b.observeAdd(1)
+	}
+
+	return
+}
+
+// evalSetBits evaluates the script setting the bits of the value in every partition filter, which all
+// need to be Redis backends sharing their connections.
+func (b *BF) evalSetBits(script *redis.Script, value []byte) (interface{}, error) {
+	first, ok := b.filters[0].storage.(*RedisStorage)
+	if !ok {
+		return nil, ErrUnsupportedBackend
+	}
+
+	stores := make([]storage, len(b.filters))
+	for index, f := range b.filters {
+		store, ok := f.storage.(*RedisStorage)
+		if !ok || store.conns != first.conns {
+			return nil, ErrUnsupportedBackend
+		}
+		stores[index] = store
+	}
+
+	return first.evalAcross(context.Background(), script, stores, b.positionsFor(value))
+}
+
+// addAcross sets bits[i] in each of the Redis backends stores[i], which share the connections of this one,
+// with the script of CheckAndAdd.
+func (s *RedisStorage) addAcross(ctx context.Context, stores []storage, bits []uint) (bool, error) {
+	return redis.Bool(s.evalAcross(ctx, checkAndAddScript, stores, bits))
+}
+
+// evalAcross evaluates the script with the keys of the Redis backends stores, which share the connections
+// of this one, followed by the bits[i] of each of them and the sliding TTL of this one.
+func (s *RedisStorage) evalAcross(ctx context.Context, script *redis.Script, stores []storage, bits []uint) (interface{}, error) {
+	keysAndArgs := make([]interface{}, 1, 2*len(stores)+2)
+	keysAndArgs[0] = len(stores)
+	for _, store := range stores {
+		keysAndArgs = append(keysAndArgs, store.(*RedisStorage).key)
+	}
+	for i, store := range stores {
+		keysAndArgs = append(keysAndArgs, store.(*RedisStorage).offset+bits[i])
+	}
+
+	var ttl int64
+	if s.slidingTTL && s.expiredAfterSeconds > 0 {
+		ttl = s.expiredAfterSeconds
+	}
+	keysAndArgs = append(keysAndArgs, ttl)
+
+	conn, err := s.conns.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return script.Do(scriptConn(conn), keysAndArgs...)
+}
+
+// scriptConn returns the connection as a redis.Conn, which redis.Script needs, wrapping connections that
+// only implement Conn.
+func scriptConn(conn Conn) redis.Conn {
+	if rc, ok := conn.(redis.Conn); ok {
+		return rc
+	}
+
+	return errlessConn{conn}
+}
+
+// errlessConn is a redis.Conn never reporting a broken connection.
+type errlessConn struct {
+	Conn
+}
+
+// Err returns nil, since Conn doesn't expose whether the connection is broken.
+func (errlessConn) Err() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package bloom
+
+import (
+	"fmt"
+	"time"
+)
+
+// cloner is implemented by the storages that can be copied into an independent storage.
+type cloner interface {
+	clone(suffix string) (storage, error)
+}
+
+// Clone returns an independent copy of the bloom filter, so values added to one of them don't show up
+// in the other. Queued values are copied along with the saved ones.
+//
+// The Bitset and counting backends copy their bits in memory. The Redis backend copies every partition
+// key with COPY, which needs Redis 6.2 or later, to a key made of the partition key and a ".clone-"
+// suffix holding the time of the call. The copies keep the expiration of the original keys, but are not
+// deleted otherwise. Other backends return ErrUnsupportedBackend. The clone reports to the same Observer.
+func (b *BF) Clone() (*BF, error) {
+	suffix := fmt.Sprintf(".clone-%d", time.Now().UnixNano())
+
+	filters := make([]filter, len(b.filters))
+	for index, f := range b.filters {
+		if f.partition != index {
+			f.storage = filters[f.partition].storage
+			filters[index] = f
+			continue
+		}
+
+		c, ok := f.storage.(cloner)
+		if !ok {
+			return nil, ErrUnsupportedBackend
+		}
+
+		store, err := c.clone(suffix)
+		if err != nil {
+			return nil, &PartitionError{index, err}
+		}
+
+		f.storage = store
+		filters[index] = f
+	}
+
+	return &BF{filters, b.observer, b.saves, b.capacity.clone()}, nil
+}
@@ -0,0 +1,141 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// compressedBlockSize is the number of set bits of a compressed partition decoded at most by a lookup.
+const compressedBlockSize = 64
+
+// CompressedBF is a read-only snapshot of a bloom filter, keeping the set bits of every partition as
+// varint encoded gaps instead of a bitset, which takes a lot less memory for a lightly filled filter: a
+// bit set every 100 bits takes about a byte instead of 100 bits. Checking a value decodes up to 64 gaps
+// per partition, so it's slower than with a Bitset. Values can't be added to it, nor can it be cleared,
+// and it doesn't change along with the filter it was created from.
+type CompressedBF struct {
+	filters    []filter
+	partitions []compressedPartition
+}
+
+// compressedPartition holds the set bits of a partition in increasing order, each encoded as the uvarint
+// of its gap from the previous one minus one, the first gap being from -1. Every compressedBlockSize bits
+// start a block, whose first bit is kept in blocks along with the offset of the gap of the next bit, so a
+// lookup only decodes a block.
+type compressedPartition struct {
+	data   []byte
+	blocks []compressedBlock
+	count  int
+}
+
+// compressedBlock is the first bit of a block of a compressed partition, and the offset in data of the
+// gap following it.
+type compressedBlock struct {
+	first  uint
+	offset int
+}
+
+// Compress returns a CompressedBF holding the bits of the bloom filter, which answers the same as the
+// filter when checking values. The bits are read like with SetBits, so values waiting in the queue are
+// not included, and the filter can be of any backend; CompressedBF itself only keeps them in memory.
+func (b *BF) Compress() (*CompressedBF, error) {
+	partitions := b.partitions()
+	compressed := &CompressedBF{make([]filter, len(b.filters)), make([]compressedPartition, len(partitions))}
+	for index, f := range partitions {
+		set, err := setBits(f)
+		if err != nil {
+			return nil, &PartitionError{index, err}
+		}
+		compressed.partitions[index] = compressPartition(set)
+	}
+	for index, f := range b.filters {
+		f.storage = nil
+		compressed.filters[index] = f
+	}
+
+	return compressed, nil
+}
+
+// compressPartition encodes the set bits of a partition, in increasing order.
+func compressPartition(set []uint) compressedPartition {
+	p := compressedPartition{count: len(set)}
+	buf := make([]byte, binary.MaxVarintLen64)
+	next := uint(0)
+	for index, bit := range set {
+		n := binary.PutUvarint(buf, uint64(bit-next))
+		if index%compressedBlockSize == 0 {
+			p.blocks = append(p.blocks, compressedBlock{bit, len(p.data) + n})
+		}
+		p.data = append(p.data, buf[:n]...)
+		next = bit + 1
+	}
+
+	return p
+}
+
+// has reports whether the bit is set in the partition.
+func (p *compressedPartition) has(bit uint) bool {
+	index := sort.Search(len(p.blocks), func(i int) bool {
+		return p.blocks[i].first > bit
+	}) - 1
+	if index < 0 {
+		return false
+	}
+
+	block := p.blocks[index]
+	current, offset := block.first, block.offset
+	remaining := p.count - index*compressedBlockSize - 1
+	if remaining > compressedBlockSize-1 {
+		remaining = compressedBlockSize - 1
+	}
+	for ; current < bit && remaining > 0; remaining-- {
+		gap, n := binary.Uvarint(p.data[offset:])
+		offset += n
+		current += uint(gap) + 1
+	}
+
+	return current == bit
+}
+
+// Exists checks if the value was in the bloom filter when it was compressed. It never fails; the error is
+// only returned for symmetry with BF.
+func (c *CompressedBF) Exists(value []byte) (bool, error) {
+	if len(c.filters) == 0 {
+		return true, nil
+	}
+	a, b := c.filters[0].hashValue(value)
+
+	return c.existsHash(a, b), nil
+}
+
+// ExistsString is like Exists, but the default hasher hashes the string without copying it, as do hashers
+// implementing io.StringWriter.
+func (c *CompressedBF) ExistsString(value string) (bool, error) {
+	if len(c.filters) == 0 {
+		return true, nil
+	}
+	a, b := c.filters[0].hashString(value)
+
+	return c.existsHash(a, b), nil
+}
+
+// existsHash checks the bits of the hash of a value in every partition.
+func (c *CompressedBF) existsHash(a, b uint) bool {
+	for _, f := range c.filters {
+		if !c.partitions[f.partition].has(f.position(a, b)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MemoryBytes returns the number of bytes taken by the compressed bits of the filter.
+func (c *CompressedBF) MemoryBytes() uint64 {
+	var total uint64
+	for _, p := range c.partitions {
+		total += uint64(len(p.data)) + uint64(len(p.blocks))*16
+	}
+
+	return total
+}
@@ -0,0 +1,139 @@
+package bloom
+
+import "math"
+
+// CountingStorage is a struct representing the in-memory counter backend for the counting bloom filter.
+// Every bit is represented by a uint8 counter, which saturates at 255.
+type CountingStorage struct {
+	counters []uint8
+	queue    []uint
+	size     uint
+}
+
+// NewCountingStorage creates a counter backend storage to be used with the counting bloom filter, holding
+// the counters of a single partition.
+func NewCountingStorage(partitionBits uint) *CountingStorage {
+	return &CountingStorage{make([]uint8, partitionBits), make([]uint, 0), partitionBits}
+}
+
+// Append appends the bit, which is to be saved, to the queue.
+func (s *CountingStorage) Append(bit uint) {
+	s.queue = append(s.queue, bit)
+}
+
+// Save increments the counters of the bits in the queue and empties it.
+func (s *CountingStorage) Save() error {
+	for _, bit := range s.queue {
+		if s.counters[bit] < math.MaxUint8 {
+			s.counters[bit]++
+		}
+	}
+	s.queue = s.queue[:0]
+
+	return nil
+}
+
+// Exists checks if the counter of the given bit is above zero.
+func (s *CountingStorage) Exists(bit uint) (bool, error) {
+	return s.counters[bit] > 0, nil
+}
+
+// ExistsMany checks if the counter of each of the given bits is above zero.
+func (s *CountingStorage) ExistsMany(bits []uint) ([]bool, error) {
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		ret[i] = s.counters[bit] > 0
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of counters above zero.
+func (s *CountingStorage) Count() (uint, error) {
+	var count uint
+	for _, counter := range s.counters {
+		if counter > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Clear resets every counter to zero and empties the queue.
+func (s *CountingStorage) Clear() error {
+	for i := range s.counters {
+		s.counters[i] = 0
+	}
+	s.queue = s.queue[:0]
+
+	return nil
+}
+
+// discard empties the queue without incrementing its counters.
+func (s *CountingStorage) discard() {
+	s.queue = s.queue[:0]
+}
+
+// backend names the counter backend in Config.
+func (s *CountingStorage) backend() string {
+	return "counting"
+}
+
+// clone copies the counters and the queue into a new counter backend.
+func (s *CountingStorage) clone(string) (storage, error) {
+	return &CountingStorage{append([]uint8(nil), s.counters...), append([]uint(nil), s.queue...), s.size}, nil
+}
+
+// Remove decrements the counter of the given bit. Saturated counters are left as they are, since the
+// number of values sharing them is no longer known.
+func (s *CountingStorage) Remove(bit uint) {
+	if s.counters[bit] > 0 && s.counters[bit] < math.MaxUint8 {
+		s.counters[bit]--
+	}
+}
+
+// CountingBF is a bloom filter that supports removing values, by keeping a counter instead of a bit for
+// every position. Values are added and saved like with BF, while removals are applied immediately.
+type CountingBF struct {
+	BF
+}
+
+// NewCountingBitset creates and returns a new counting bloom filter using the in-memory counter backend.
+// It panics if size or hashIter is zero.
+func NewCountingBitset(size, hashIter uint, opts ...Option) *CountingBF {
+	if err := validateParameters(size, hashIter); err != nil {
+		panic(err)
+	}
+
+	o := newOptions(opts)
+	filters := filterSetup(size, hashIter, o)
+
+	for index, filter := range filters {
+		if filter.partition != index {
+			filter.storage = filters[filter.partition].storage
+		} else {
+			filter.storage = NewCountingStorage(filter.partitionBits)
+		}
+		filters[index] = filter
+	}
+
+	return &CountingBF{BF{filters, o.observer, o.saves, o.capacityWatch()}}
+}
+
+// Remove removes a saved value from the counting bloom filter, returning false if the value didn't
+// exist. Only values that were added should be removed, as removing a false positive can remove other
+// values. Values sharing a saturated counter can't be safely removed, so the counter keeps them existing.
+func (c *CountingBF) Remove(value []byte) bool {
+	exists, _ := c.Has(value)
+	if !exists {
+		return false
+	}
+
+	for _, f := range c.filters {
+		a, b := f.hashValue(value)
+		f.storage.(*CountingStorage).Remove(f.position(a, b))
+	}
+
+	return true
+}
@@ -0,0 +1,107 @@
+package bloom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// dumpMagic starts the header written by Dump.
+var dumpMagic = [8]byte{'g', 'o', 'b', 'l', 'o', 'o', 'm', 'd'}
+
+// dumper is implemented by the storages whose bits can be read and written as a whole, in one command
+// each.
+type dumper interface {
+	// dump returns the bytes of the storage, bit 0 being the most significant bit of the first byte.
+	dump(ctx context.Context) ([]byte, error)
+	// restore replaces the bits of the storage with the bytes returned by dump.
+	restore(ctx context.Context, data []byte) error
+}
+
+// Dump writes a snapshot of the bits of a Redis backed bloom filter to w, reading every partition with a
+// single GET instead of a command per bit. The snapshot is a header with the size, hash iterations,
+// number of partitions and Fingerprint of the filter, like the one of NewFile, followed by the bytes of
+// every partition. Values waiting in the queue are not included, so Save should be called first. Other
+// backends return ErrUnsupportedBackend; Bitset backed filters can be serialized with WriteTo instead.
+func (b *BF) Dump(w io.Writer) error {
+	partitions := b.partitions()
+	dumpers, err := partitionDumpers(partitions)
+	if err != nil {
+		return err
+	}
+
+	size, hashIter := b.Parameters()
+	header := fileHeader{dumpMagic, uint64(size), uint64(hashIter), uint64(len(partitions)), b.Fingerprint()}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	for index, d := range dumpers {
+		data, err := d.dump(context.Background())
+		if err != nil {
+			return &PartitionError{index, err}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore replaces the bits of a Redis backed bloom filter with a snapshot written by Dump, setting every
+// partition with a single command and applying the expiration of the filter again. The snapshot is read
+// entirely before anything is written, and ErrIncompatibleFilter is returned if it was taken from a filter
+// with a different Fingerprint. Values waiting in the queue are kept. Other backends return
+// ErrUnsupportedBackend.
+func (b *BF) Restore(r io.Reader) error {
+	partitions := b.partitions()
+	dumpers, err := partitionDumpers(partitions)
+	if err != nil {
+		return err
+	}
+
+	var header fileHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return readError(err)
+	}
+	if header.Magic != dumpMagic {
+		return errors.New("bloom: not a bloom filter dump")
+	}
+	size, hashIter := b.Parameters()
+	if header.Size != uint64(size) || header.HashIter != uint64(hashIter) || header.Partitions != uint64(len(partitions)) || header.Fingerprint != b.Fingerprint() {
+		return ErrIncompatibleFilter
+	}
+
+	data := make([][]byte, len(partitions))
+	for index, f := range partitions {
+		data[index] = make([]byte, (f.partitionBits+7)/8)
+		if _, err := io.ReadFull(r, data[index]); err != nil {
+			return readError(err)
+		}
+	}
+
+	for index, d := range dumpers {
+		if err := d.restore(context.Background(), data[index]); err != nil {
+			return &PartitionError{index, err}
+		}
+	}
+
+	return nil
+}
+
+// partitionDumpers returns the storages of the partitions, or ErrUnsupportedBackend if any of them can't
+// be dumped.
+func partitionDumpers(partitions []filter) ([]dumper, error) {
+	dumpers := make([]dumper, len(partitions))
+	for index, f := range partitions {
+		d, ok := f.storage.(dumper)
+		if !ok {
+			return nil, ErrUnsupportedBackend
+		}
+		dumpers[index] = d
+	}
+
+	return dumpers, nil
+}
@@ -0,0 +1,343 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/willf/bitset"
+)
+
+// encodingVersion is written as the first byte of a serialized bloom filter.
+const encodingVersion = 6
+
+// maxEncodedHashIter caps the hash iterations of a serialized bloom filter, far above any useful filter,
+// so a corrupt header can't make it allocate a filter per hash iteration before the fingerprint is checked.
+const maxEncodedHashIter = 1 << 16
+
+// encodingChunk is the number of bit words written or read at a time when streaming a bloom filter.
+const encodingChunk = 1024
+
+// ErrTruncated is returned when a serialized bloom filter ends before all the data has been read.
+var ErrTruncated = errors.New("bloom: serialized filter is truncated")
+
+// encodingHeader is written at the start of a serialized bloom filter.
+type encodingHeader struct {
+	Version  uint8
+	Size     uint64
+	HashIter uint64
+}
+
+// partitionHeader is written before the bit words of every partition.
+type partitionHeader struct {
+	Multiplier uint64
+	Size       uint64
+}
+
+// MarshalBinary serializes a bloom filter using the Bitset backend. Values waiting in the queue are not
+// included, so Save should be called first.
+//
+// The format is a version byte followed by the total size, hash iterations and seed, a byte for the hashing
+// scheme, three bytes for the hash extraction (little endian order, offset of a and offset of b), the
+// Fingerprint, the number of partitions, and then the multiplier, size and bit words of every partition,
+// all as big endian uint64s. When there are fewer partitions than hash iterations, the filters sharing the
+// partitions use the multipliers following the ones of the partitions. Filters serialized before version
+// 2 have no seed, before version 3 use double hashing, before version 4 have no fingerprint, before
+// version 5 have a partition per hash iteration, and before version 6 use the default hash extraction.
+func (b *BF) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a bloom filter serialized by MarshalBinary, replacing the current filters with
+// Bitset backed ones. The hasher of the bloom filter is kept, so a filter created with WithHasher must be
+// restored into a filter using the same hasher, otherwise the fingerprints don't match and
+// ErrIncompatibleFilter is returned. The seed and the hashing scheme are restored from the serialized
+// filter, along with the hash extraction.
+func (b *BF) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := b.ReadFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() > 0 {
+		return fmt.Errorf("bloom: %d unexpected bytes after the serialized filter", r.Len())
+	}
+
+	return nil
+}
+
+// GobEncode serializes a bloom filter using the Bitset backend for encoding/gob, using the same format as
+// MarshalBinary. Redis backed filters return ErrUnsupportedBackend.
+func (b *BF) GobEncode() ([]byte, error) {
+	return b.MarshalBinary()
+}
+
+// GobDecode restores a bloom filter encoded by GobEncode.
+func (b *BF) GobDecode(data []byte) error {
+	return b.UnmarshalBinary(data)
+}
+
+// WriteTo streams a bloom filter using the Bitset backend to w, in the same format as MarshalBinary.
+func (b *BF) WriteTo(w io.Writer) (int64, error) {
+	partitions := b.partitions()
+	stores := make([]*BitsetStorage, len(partitions))
+	for index, f := range partitions {
+		store, ok := f.storage.(*BitsetStorage)
+		if !ok {
+			return 0, ErrUnsupportedBackend
+		}
+		stores[index] = store
+	}
+
+	cw := &countingWriter{w: w}
+
+	size, hashIter := b.Parameters()
+	if err := binary.Write(cw, binary.BigEndian, encodingHeader{encodingVersion, uint64(size), uint64(hashIter)}); err != nil {
+		return cw.n, err
+	}
+	var seed uint64
+	var scheme hashScheme
+	extraction := defaultExtraction
+	if len(b.filters) > 0 {
+		seed, scheme, extraction = b.filters[0].seed, b.filters[0].scheme, b.filters[0].extraction
+	}
+	if err := binary.Write(cw, binary.BigEndian, seed); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(cw, binary.BigEndian, scheme); err != nil {
+		return cw.n, err
+	}
+	var order byte
+	if extraction.littleEndian {
+		order = 1
+	}
+	if _, err := cw.Write([]byte{order, extraction.a, extraction.b}); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(cw, binary.BigEndian, b.Fingerprint()); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(cw, binary.BigEndian, uint64(len(partitions))); err != nil {
+		return cw.n, err
+	}
+
+	buf := make([]byte, 8*encodingChunk)
+	for index, f := range partitions {
+		if err := binary.Write(cw, binary.BigEndian, partitionHeader{uint64(f.multiplier), uint64(f.partitionBits)}); err != nil {
+			return cw.n, err
+		}
+
+		words := stores[index].store.Bytes()
+		for len(words) > 0 {
+			chunk := words
+			if len(chunk) > encodingChunk {
+				chunk = chunk[:encodingChunk]
+			}
+			words = words[len(chunk):]
+
+			for i, word := range chunk {
+				binary.BigEndian.PutUint64(buf[8*i:], word)
+			}
+			if _, err := cw.Write(buf[:8*len(chunk)]); err != nil {
+				return cw.n, err
+			}
+		}
+	}
+
+	return cw.n, nil
+}
+
+// ReadFrom restores a bloom filter streamed by WriteTo from r, replacing the current filters with Bitset
+// backed ones, which stay safe for concurrent use if the filter was created WithConcurrency. The header is validated before reading the bit words, which are read in chunks so a
+// corrupt header can't make it allocate more memory than the stream actually holds.
+func (b *BF) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+
+	params, err := readParams(cr)
+	if err != nil {
+		return cr.n, err
+	}
+	header, seed, scheme, extraction, partitions := params.header, params.seed, params.scheme, params.extraction, params.partitions
+
+	var hasher func() hash.Hash64
+	var concurrent bool
+	if len(b.filters) > 0 {
+		hasher = b.filters[0].hasher
+		store, ok := b.filters[0].storage.(*BitsetStorage)
+		concurrent = ok && store.mu != nil
+	}
+
+	var total uint64
+	var filters []filter
+	buf := make([]byte, 8*encodingChunk)
+	for k := uint64(0); k < partitions; k++ {
+		var partition partitionHeader
+		if err := binary.Read(cr, binary.BigEndian, &partition); err != nil {
+			return cr.n, readError(err)
+		}
+		if partition.Size == 0 || partition.Size > header.Size-total {
+			return cr.n, fmt.Errorf("bloom: invalid partition %d size %d", k, partition.Size)
+		}
+		total += partition.Size
+
+		remaining := partition.Size / 64
+		if partition.Size%64 != 0 {
+			remaining++
+		}
+
+		var words []uint64
+		for remaining > 0 {
+			chunk := uint64(encodingChunk)
+			if remaining < chunk {
+				chunk = remaining
+			}
+			remaining -= chunk
+
+			if _, err := io.ReadFull(cr, buf[:8*chunk]); err != nil {
+				return cr.n, readError(err)
+			}
+			for i := uint64(0); i < chunk; i++ {
+				words = append(words, binary.BigEndian.Uint64(buf[8*i:]))
+			}
+		}
+
+		store := &BitsetStorage{bitset.From(words).Shrink(uint(partition.Size - 1)), make([]uint, 0), uint(partition.Size), nil, 0}
+		store.set = store.store.Count()
+		if concurrent {
+			store.mu = new(sync.RWMutex)
+		}
+		filters = append(filters, filter{uint(partition.Size), store, hasher, uint(partition.Multiplier), seed, scheme, extraction, int(k)})
+	}
+
+	if total != header.Size {
+		return cr.n, fmt.Errorf("bloom: partition sizes add up to %d instead of %d", total, header.Size)
+	}
+
+	for k := partitions; k < header.HashIter; k++ {
+		shared := filters[k%partitions]
+		filters = append(filters, filter{shared.partitionBits, shared.storage, hasher, uint(k + 1), seed, scheme, extraction, shared.partition})
+	}
+
+	restored := BF{filters, b.observer, b.saves, b.capacity}
+	if header.Version >= 4 && restored.Fingerprint() != params.fingerprint {
+		return cr.n, ErrIncompatibleFilter
+	}
+
+	b.filters = filters
+	return cr.n, nil
+}
+
+// encodingParams are the parameters of a serialized bloom filter, read before its partitions.
+type encodingParams struct {
+	header      encodingHeader
+	seed        uint64
+	scheme      hashScheme
+	extraction  hashExtraction
+	fingerprint uint64
+	partitions  uint64
+}
+
+// readParams reads and validates the parameters of a serialized bloom filter, up to its first partition.
+func readParams(r io.Reader) (encodingParams, error) {
+	var header encodingHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return encodingParams{}, readError(err)
+	}
+	if header.Version == 0 || header.Version > encodingVersion {
+		return encodingParams{}, fmt.Errorf("bloom: unsupported encoding version %d", header.Version)
+	}
+	if header.HashIter == 0 || header.Size == 0 || header.HashIter > maxEncodedHashIter {
+		return encodingParams{}, fmt.Errorf("bloom: invalid size %d and hash iterations %d", header.Size, header.HashIter)
+	}
+	if header.Size > uint64(^uint(0)) {
+		return encodingParams{}, fmt.Errorf("bloom: size %d is too large for this platform", header.Size)
+	}
+
+	var seed uint64
+	if header.Version >= 2 {
+		if err := binary.Read(r, binary.BigEndian, &seed); err != nil {
+			return encodingParams{}, readError(err)
+		}
+	}
+	var scheme hashScheme
+	if header.Version >= 3 {
+		if err := binary.Read(r, binary.BigEndian, &scheme); err != nil {
+			return encodingParams{}, readError(err)
+		}
+		if scheme > mixedHashing {
+			return encodingParams{}, fmt.Errorf("bloom: unsupported hashing scheme %d", scheme)
+		}
+	}
+	extraction := defaultExtraction
+	if header.Version >= 6 {
+		var fields [3]byte
+		if _, err := io.ReadFull(r, fields[:]); err != nil {
+			return encodingParams{}, readError(err)
+		}
+		extraction = hashExtraction{fields[0] == 1, fields[1], fields[2]}
+		if fields[0] > 1 || !extraction.valid() {
+			return encodingParams{}, fmt.Errorf("bloom: invalid hash extraction %v", fields)
+		}
+	}
+	var fingerprint uint64
+	if header.Version >= 4 {
+		if err := binary.Read(r, binary.BigEndian, &fingerprint); err != nil {
+			return encodingParams{}, readError(err)
+		}
+	}
+	partitions := header.HashIter
+	if header.Version >= 5 {
+		if err := binary.Read(r, binary.BigEndian, &partitions); err != nil {
+			return encodingParams{}, readError(err)
+		}
+	}
+	if partitions == 0 || partitions > header.HashIter || header.Size < partitions {
+		return encodingParams{}, fmt.Errorf("bloom: invalid size %d and %d partitions for %d hash iterations", header.Size, partitions, header.HashIter)
+	}
+
+	return encodingParams{header, seed, scheme, extraction, fingerprint, partitions}, nil
+}
+
+// readError reports a stream ending in the middle of a bloom filter as ErrTruncated.
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+
+	return err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
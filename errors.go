@@ -0,0 +1,46 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PartitionError is an error returned by the backend storage of a single partition filter.
+type PartitionError struct {
+	Partition int
+	Err       error
+}
+
+func (e *PartitionError) Error() string {
+	return fmt.Sprintf("bloom: partition %d: %v", e.Partition, e.Err)
+}
+
+// Unwrap returns the error returned by the backend storage.
+func (e *PartitionError) Unwrap() error {
+	return e.Err
+}
+
+// SaveError is returned by Save when some of the partition filters failed to save.
+type SaveError []*PartitionError
+
+func (e SaveError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Is reports whether any of the partition errors matches the target, so errors.Is can be used to look
+// for a specific failure such as context.Canceled.
+func (e SaveError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
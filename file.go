@@ -0,0 +1,240 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sync/atomic"
+)
+
+// fileMagic starts the header of the files of the file backend.
+var fileMagic = [8]byte{'g', 'o', 'b', 'l', 'o', 'o', 'm', 'f'}
+
+// fileHeader is written at the start of the files of the file backend, followed by the bytes of every
+// partition.
+type fileHeader struct {
+	Magic       [8]byte
+	Size        uint64
+	HashIter    uint64
+	Partitions  uint64
+	Fingerprint uint64
+}
+
+// fileHeaderSize is the number of bytes taken by fileHeader.
+const fileHeaderSize = 40
+
+// FileStorage is a struct representing the file backend for the bloom filter. Every partition filter owns
+// a region of the file, with bit 0 being the most significant bit of the first byte of the region. Bits
+// are read and written with ReadAt and WriteAt, so it works on filesystems where mmap doesn't. The
+// partitions share the flag telling whether bits were written since the file was last synced.
+type FileStorage struct {
+	file     *os.File
+	offset   int64
+	queue    []uint
+	size     uint
+	unsynced *uint32
+}
+
+// NewFile creates and returns a new bloom filter using a file as a backend. The file is created with a
+// header describing the filter if it doesn't exist, and an existing file needs a header matching the size,
+// hash iterations and Fingerprint of the filter, otherwise ErrIncompatibleFilter is returned. Bits are
+// written to the file on Save, which also syncs it to disk.
+func NewFile(path string, size, hashIter uint, opts ...Option) (*BF, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	bloom := BF{filterSetup(size, hashIter, o), o.observer, o.saves, o.capacityWatch()}
+
+	length := int64(fileHeaderSize)
+	for _, f := range bloom.partitions() {
+		length += int64((f.partitionBits + 7) / 8)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64 = fileHeaderSize
+	unsynced := new(uint32)
+	for index, f := range bloom.filters {
+		if f.partition != index {
+			f.storage = bloom.filters[f.partition].storage
+		} else {
+			f.storage = &FileStorage{file, offset, make([]uint, 0), f.partitionBits, unsynced}
+			offset += int64((f.partitionBits + 7) / 8)
+		}
+		bloom.filters[index] = f
+	}
+
+	header := fileHeader{fileMagic, uint64(size), uint64(hashIter), uint64(len(bloom.partitions())), bloom.Fingerprint()}
+	if err := checkFileHeader(file, header, length); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &bloom, nil
+}
+
+// checkFileHeader writes the header to an empty file and sizes it to the given length, or checks that the
+// header and length of an existing file match.
+func checkFileHeader(file *os.File, header fileHeader, length int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, header)
+
+	if info.Size() == 0 {
+		if _, err := file.WriteAt(buf.Bytes(), 0); err != nil {
+			return err
+		}
+		if err := file.Truncate(length); err != nil {
+			return err
+		}
+
+		return file.Sync()
+	}
+
+	existing := make([]byte, fileHeaderSize)
+	if _, err := file.ReadAt(existing, 0); err != nil {
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	if !bytes.Equal(existing[:len(fileMagic)], fileMagic[:]) {
+		return fmt.Errorf("bloom: %s isn't a bloom filter file", file.Name())
+	}
+	if !bytes.Equal(existing, buf.Bytes()) {
+		return ErrIncompatibleFilter
+	}
+	if info.Size() != length {
+		return fmt.Errorf("bloom: %s is %d bytes instead of %d", file.Name(), info.Size(), length)
+	}
+
+	return nil
+}
+
+// Append appends the bit, which is to be saved, to the queue.
+func (s *FileStorage) Append(bit uint) {
+	s.queue = append(s.queue, bit)
+}
+
+// Save sets the bits from the queue in the file, reading and writing every modified byte once. The file
+// is synced to disk once every partition of the bloom filter is saved, instead of once per partition.
+func (s *FileStorage) Save() error {
+	if len(s.queue) == 0 {
+		return nil
+	}
+
+	s.queue = uniqueBits(s.queue)
+	atomic.StoreUint32(s.unsynced, 1)
+
+	buf := make([]byte, 1)
+	for i := 0; i < len(s.queue); {
+		index := s.queue[i] / 8
+		if _, err := s.file.ReadAt(buf, s.offset+int64(index)); err != nil {
+			return err
+		}
+		for ; i < len(s.queue) && s.queue[i]/8 == index; i++ {
+			buf[0] |= 0x80 >> (s.queue[i] % 8)
+		}
+		if _, err := s.file.WriteAt(buf, s.offset+int64(index)); err != nil {
+			return err
+		}
+	}
+
+	s.queue = s.queue[:0]
+	return nil
+}
+
+// sync syncs the file to disk if a partition wrote bits to it since it was last synced.
+func (s *FileStorage) sync() error {
+	if atomic.SwapUint32(s.unsynced, 0) == 0 {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		atomic.StoreUint32(s.unsynced, 1)
+		return err
+	}
+
+	return nil
+}
+
+// Exists checks if the given bit exists in the file, reading its byte.
+func (s *FileStorage) Exists(bit uint) (bool, error) {
+	buf := make([]byte, 1)
+	if _, err := s.file.ReadAt(buf, s.offset+int64(bit/8)); err != nil {
+		return false, err
+	}
+
+	return buf[0]&(0x80>>(bit%8)) != 0, nil
+}
+
+// ExistsMany checks if each of the given bits exists in the file.
+func (s *FileStorage) ExistsMany(bits []uint) ([]bool, error) {
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		exists, err := s.Exists(bit)
+		if err != nil {
+			return ret, err
+		}
+		ret[i] = exists
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set in the region of the file.
+func (s *FileStorage) Count() (uint, error) {
+	data := make([]byte, (s.size+7)/8)
+	if _, err := s.file.ReadAt(data, s.offset); err != nil {
+		return 0, err
+	}
+
+	var count uint
+	for _, b := range data {
+		count += uint(bits.OnesCount8(b))
+	}
+
+	return count, nil
+}
+
+// Clear unsets every bit in the region of the file, syncs it and empties the queue.
+func (s *FileStorage) Clear() error {
+	if _, err := s.file.WriteAt(make([]byte, (s.size+7)/8), s.offset); err != nil {
+		return err
+	}
+	s.queue = s.queue[:0]
+
+	return s.file.Sync()
+}
+
+// discard empties the queue without writing its bits to the file.
+func (s *FileStorage) discard() {
+	s.queue = s.queue[:0]
+}
+
+// close closes the file shared by the partitions. The first partition closes it, so the others find it
+// already closed.
+func (s *FileStorage) close() error {
+	if err := s.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// backend names the file backend in Config.
+func (s *FileStorage) backend() string {
+	return "file"
+}
@@ -0,0 +1,55 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// ErrIncompatibleFilter is returned when bloom filters with different parameters are combined, or when a
+// serialized filter doesn't match the parameters it's restored with.
+var ErrIncompatibleFilter = errors.New("bloom: incompatible filter")
+
+// Fingerprint returns a stable hash of the parameters of the bloom filter: its size, hash iterations,
+// the multiplier and size of every partition filter, the number of partitions when they're shared, the
+// seed, the hashing scheme, the hash extraction when it isn't the default one and the hasher, which is
+// identified by what it hashes a fixed probe value to. Filters sharing a fingerprint map values to the
+// same bits.
+func (b *BF) Fingerprint() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	write := func(v uint64) {
+		binary.BigEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+
+	size, hashIter := b.Parameters()
+	write(uint64(size))
+	write(uint64(hashIter))
+	for _, f := range b.filters {
+		write(uint64(f.multiplier))
+		write(uint64(f.partitionBits))
+	}
+	if partitions := len(b.partitions()); partitions != len(b.filters) {
+		write(uint64(partitions))
+	}
+
+	if len(b.filters) > 0 {
+		f := b.filters[0]
+		write(f.seed)
+		write(uint64(f.scheme))
+		if e := f.extraction; e != defaultExtraction {
+			order := uint64(0)
+			if e.littleEndian {
+				order = 1
+			}
+			write(order<<16 | uint64(e.a)<<8 | uint64(e.b))
+		}
+
+		probe := f.newHasher()
+		probe.Write(hasherProbe)
+		write(probe.Sum64())
+	}
+
+	return h.Sum64()
+}
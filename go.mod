@@ -1,8 +1,12 @@
 module github.com/curls/go-bloom
 
-go 1.13
+go 1.18
 
 require (
+	github.com/bradfitz/gomemcache v0.0.0-20260422231931-4d751bb6e37c
 	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/willf/bitset v1.1.10
+	go.etcd.io/bbolt v1.3.9
 )
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,54 @@
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+)
+
+// AddFrom adds every value read from r, the values being separated by sep, and saves them once all of
+// them are read. Empty values are skipped, and with a newline separator a trailing carriage return is
+// dropped too. It returns the number of values added, which are saved even if reading r fails. Values
+// longer than bufio.MaxScanTokenSize make it fail with bufio.ErrTooLong.
+func (b *BF) AddFrom(r io.Reader, sep byte) (count int, err error) {
+	return b.AddFromContext(context.Background(), r, sep)
+}
+
+// AddFromContext is like AddFrom, but saves the values using the given context.
+func (b *BF) AddFromContext(ctx context.Context, r io.Reader, sep byte) (count int, err error) {
+	scanner := bufio.NewScanner(r)
+	if sep != '\n' {
+		scanner.Split(scanSeparated(sep))
+	}
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		b.Add(scanner.Bytes())
+		count++
+	}
+
+	err = b.SaveContext(ctx)
+	if scanErr := scanner.Err(); scanErr != nil {
+		err = scanErr
+	}
+
+	return
+}
+
+// scanSeparated returns a bufio.SplitFunc splitting the input on sep.
+func scanSeparated(sep byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	}
+}
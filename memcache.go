@@ -0,0 +1,336 @@
+//go:build !nomemcache
+// +build !nomemcache
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// memcachedChunkBytes is the size of the items the bits of a partition are split into, half the default
+// 1MB item size limit of Memcached.
+const memcachedChunkBytes = 512 * 1024
+
+// memcachedCASAttempts is the number of times Save reads and writes back an item before giving up on
+// other clients changing it in between.
+const memcachedCASAttempts = 100
+
+// memcachedMaxTTL is the longest relative expiration time of Memcached, which treats longer ones as Unix
+// timestamps.
+const memcachedMaxTTL = 30 * 24 * 60 * 60
+
+// ErrEvicted is returned when an item of a Memcached backed bloom filter is missing, since Memcached
+// evicted or expired it, losing the values whose bits it held.
+var ErrEvicted = errors.New("bloom: Memcached item was evicted")
+
+// NewMemcached creates and returns a new bloom filter using Memcached as a backend, like NewRedis. Every
+// partition is stored in items of up to 512KB, under the key and the multiplier of the partition filter
+// followed by the index of the item, and the filter reports whether the items already existed. The items
+// expire after expiredAfterSeconds if it's positive, up to 30 days.
+//
+// Memcached has no SETBIT, so Save reads every item holding queued bits and writes it back with a
+// compare-and-swap, retrying when another client changed it in between, which makes concurrent writers
+// slower than with Redis. Items are saved one by one: unlike the MULTI/EXEC transaction of Redis, other
+// clients can see a value saved in some partitions but not in others until Save returns. Every write
+// expires the item again after expiredAfterSeconds, so the expiration slides on writes like
+// WithSlidingTTL. Memcached is a cache and evicts items under memory pressure, losing their bits, which
+// is reported as ErrEvicted instead of values silently going missing.
+func NewMemcached(client *memcache.Client, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*BF, bool, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, false, err
+	}
+	if expiredAfterSeconds > memcachedMaxTTL {
+		return nil, false, fmt.Errorf("bloom: Memcached items can't expire after more than %d seconds, got %d", memcachedMaxTTL, expiredAfterSeconds)
+	}
+
+	o := newOptions(opts)
+	bloom := BF{filterSetup(size, hashIter, o), o.observer, o.saves, o.capacityWatch()}
+
+	var err error
+	var exist bool
+	for index, filter := range bloom.filters {
+		if filter.partition != index {
+			filter.storage = bloom.filters[filter.partition].storage
+			bloom.filters[index] = filter
+			continue
+		}
+
+		var store *MemcachedStorage
+		store, exist, err = newMemcachedStorage(client, partitionKey(key, filter.multiplier, o), filter.partitionBits, expiredAfterSeconds)
+		filter.storage = store
+		bloom.filters[index] = filter
+		if err != nil {
+			return &bloom, exist, err
+		}
+	}
+
+	return &bloom, exist, nil
+}
+
+// MemcachedStorage is a struct representing the Memcached backend for the bloom filter. The bits of the
+// partition are split into items of memcachedChunkBytes, bit 0 being the most significant bit of the first
+// byte of the first item, like with SETBIT.
+type MemcachedStorage struct {
+	client     *memcache.Client
+	key        string
+	size       uint
+	queue      []uint
+	expiration int32
+	mu         *sync.Mutex
+}
+
+// newMemcachedStorage creates a Memcached backend storage holding the size bits of a partition under the
+// key, adding the items that don't exist yet. Some of the items existing while others don't means the
+// missing ones were evicted, which returns ErrEvicted.
+func newMemcachedStorage(client *memcache.Client, key string, size uint, expiredAfterSeconds int64) (*MemcachedStorage, bool, error) {
+	store := &MemcachedStorage{client, key, size, make([]uint, 0), 0, new(sync.Mutex)}
+	if expiredAfterSeconds > 0 {
+		store.expiration = int32(expiredAfterSeconds)
+	}
+
+	var added, existing int
+	for chunk := 0; chunk < store.chunks(); chunk++ {
+		err := client.Add(&memcache.Item{Key: store.chunkKey(chunk), Value: make([]byte, store.chunkLength(chunk)), Expiration: store.expiration})
+		switch err {
+		case nil:
+			added++
+		case memcache.ErrNotStored:
+			existing++
+		default:
+			return store, existing > 0, err
+		}
+	}
+	if existing == 0 {
+		return store, false, nil
+	}
+	if added > 0 {
+		return store, true, fmt.Errorf("%w: %d of the %d items of %s were missing", ErrEvicted, added, store.chunks(), key)
+	}
+
+	keys := make([]string, store.chunks())
+	for chunk := range keys {
+		keys[chunk] = store.chunkKey(chunk)
+	}
+	items, err := client.GetMulti(keys)
+	if err != nil {
+		return store, true, err
+	}
+	for chunk, key := range keys {
+		if err := store.checkItem(chunk, items[key]); err != nil {
+			return store, true, err
+		}
+	}
+
+	return store, true, nil
+}
+
+// chunks returns the number of items holding the bits of the partition.
+func (s *MemcachedStorage) chunks() int {
+	return int(((s.size+7)/8 + memcachedChunkBytes - 1) / memcachedChunkBytes)
+}
+
+// chunkLength returns the number of bytes of the item, which is memcachedChunkBytes but for the last one.
+func (s *MemcachedStorage) chunkLength(chunk int) int {
+	length := int((s.size+7)/8) - chunk*memcachedChunkBytes
+	if length > memcachedChunkBytes {
+		return memcachedChunkBytes
+	}
+
+	return length
+}
+
+// chunkKey returns the key of the item.
+func (s *MemcachedStorage) chunkKey(chunk int) string {
+	return fmt.Sprintf("%s.%d", s.key, chunk)
+}
+
+// checkItem checks that the item of the chunk exists and holds as many bytes as its bits need.
+func (s *MemcachedStorage) checkItem(chunk int, item *memcache.Item) error {
+	if item == nil {
+		return fmt.Errorf("%w: %s", ErrEvicted, s.chunkKey(chunk))
+	}
+	if len(item.Value) != s.chunkLength(chunk) {
+		return fmt.Errorf("%w: item %s holds %d bytes instead of %d", ErrSizeMismatch, item.Key, len(item.Value), s.chunkLength(chunk))
+	}
+
+	return nil
+}
+
+// Append appends the bit, which is to be saved, to the queue. It's safe to call concurrently with Save.
+func (s *MemcachedStorage) Append(bit uint) {
+	s.mu.Lock()
+	s.queue = append(s.queue, bit)
+	s.mu.Unlock()
+}
+
+// Save sets the bits from the queue in their items, reading and writing back every item holding some of
+// them with a compare-and-swap, which is retried up to memcachedCASAttempts times when another client
+// changes the item in between. Items whose bits are all set already aren't written. The bits of the items
+// that couldn't be saved stay queued for the next Save.
+func (s *MemcachedStorage) Save() error {
+	s.mu.Lock()
+	bits := uniqueBits(s.queue)
+	s.queue = nil
+	s.mu.Unlock()
+
+	chunkBits := uint(memcachedChunkBytes * 8)
+	for start := 0; start < len(bits); {
+		chunk := bits[start] / chunkBits
+		end := start
+		for end < len(bits) && bits[end]/chunkBits == chunk {
+			end++
+		}
+
+		if err := s.setBits(int(chunk), bits[start:end]); err != nil {
+			s.mu.Lock()
+			s.queue = append(bits[start:], s.queue...)
+			s.mu.Unlock()
+			return err
+		}
+		start = end
+	}
+
+	return nil
+}
+
+// setBits sets the bits of a single item with a compare-and-swap.
+func (s *MemcachedStorage) setBits(chunk int, bits []uint) error {
+	chunkBits := uint(memcachedChunkBytes * 8)
+	for attempt := 0; attempt < memcachedCASAttempts; attempt++ {
+		item, err := s.client.Get(s.chunkKey(chunk))
+		if err == memcache.ErrCacheMiss {
+			item, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.checkItem(chunk, item); err != nil {
+			return err
+		}
+
+		changed := false
+		for _, bit := range bits {
+			offset := bit % chunkBits
+			if mask := byte(0x80) >> (offset % 8); item.Value[offset/8]&mask == 0 {
+				item.Value[offset/8] |= mask
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+
+		item.Expiration = s.expiration
+		switch err := s.client.CompareAndSwap(item); err {
+		case memcache.ErrCASConflict:
+			continue
+		case memcache.ErrCacheMiss, memcache.ErrNotStored:
+			return fmt.Errorf("%w: %s", ErrEvicted, item.Key)
+		default:
+			return err
+		}
+	}
+
+	return fmt.Errorf("bloom: Memcached item %s kept changing after %d attempts", s.chunkKey(chunk), memcachedCASAttempts)
+}
+
+// Exists checks if the given bit exists in the Memcached backend.
+func (s *MemcachedStorage) Exists(bit uint) (bool, error) {
+	exists, err := s.ExistsMany([]uint{bit})
+	if err != nil {
+		return false, err
+	}
+
+	return exists[0], nil
+}
+
+// ExistsMany checks if each of the given bits exists in the Memcached backend, getting every item holding
+// some of them at once.
+func (s *MemcachedStorage) ExistsMany(bits []uint) ([]bool, error) {
+	chunkBits := uint(memcachedChunkBytes * 8)
+	seen := make(map[int]bool)
+	var keys []string
+	for _, bit := range bits {
+		if chunk := int(bit / chunkBits); !seen[chunk] {
+			seen[chunk] = true
+			keys = append(keys, s.chunkKey(chunk))
+		}
+	}
+
+	items, err := s.client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		chunk := int(bit / chunkBits)
+		item := items[s.chunkKey(chunk)]
+		if err := s.checkItem(chunk, item); err != nil {
+			return nil, err
+		}
+
+		offset := bit % chunkBits
+		ret[i] = item.Value[offset/8]&(0x80>>(offset%8)) != 0
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set in the items of the partition.
+func (s *MemcachedStorage) Count() (uint, error) {
+	keys := make([]string, s.chunks())
+	for chunk := range keys {
+		keys[chunk] = s.chunkKey(chunk)
+	}
+
+	items, err := s.client.GetMulti(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint
+	for chunk, key := range keys {
+		if err := s.checkItem(chunk, items[key]); err != nil {
+			return 0, err
+		}
+		for _, b := range items[key].Value {
+			count += uint(bits.OnesCount8(b))
+		}
+	}
+
+	return count, nil
+}
+
+// Clear unsets every bit by replacing the items with zeroed ones, recreating the evicted ones, and empties
+// the queue.
+func (s *MemcachedStorage) Clear() error {
+	s.mu.Lock()
+	s.queue = nil
+	s.mu.Unlock()
+
+	for chunk := 0; chunk < s.chunks(); chunk++ {
+		if err := s.client.Set(&memcache.Item{Key: s.chunkKey(chunk), Value: make([]byte, s.chunkLength(chunk)), Expiration: s.expiration}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discard empties the queue without sending its bits to Memcached.
+func (s *MemcachedStorage) discard() {
+	s.mu.Lock()
+	s.queue = nil
+	s.mu.Unlock()
+}
+
+// backend names the Memcached backend in Config.
+func (s *MemcachedStorage) backend() string {
+	return "memcached"
+}
@@ -0,0 +1,184 @@
+//go:build !nomemcache
+// +build !nomemcache
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// newMemcachedClient returns a client of the Memcached server at MEMCACHED_HOST, skipping the test if
+// there's none.
+func newMemcachedClient(t *testing.T) *memcache.Client {
+	host := os.Getenv("MEMCACHED_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+
+	client := memcache.New(fmt.Sprintf("%s:11211", host))
+	if err := client.Ping(); err != nil {
+		t.Skipf("no Memcached server at %s: %v", host, err)
+	}
+
+	return client
+}
+
+func TestMemcached(t *testing.T) {
+	client := newMemcachedClient(t)
+	defer client.DeleteAll()
+
+	m, exists, err := NewMemcached(client, "memcached-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("memcached-test shouldn't exist yet")
+	}
+	if config := m.Config(); config.Backend != "memcached" {
+		t.Fatalf("expected the memcached backend, got %q", config.Backend)
+	}
+
+	m.Add(Value("afi"), Value("amma"))
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, exists, err := NewMemcached(client, "memcached-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("memcached-test should exist once created")
+	}
+
+	found, err := reopened.Exist(Value("afi"), Value("amma"), Value("langafi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found[0] || !found[1] || found[2] {
+		t.Fatalf("expected only afi and amma to exist in the reopened filter, got %v", found)
+	}
+	if stats := reopened.Stats(); stats.SetBits == 0 || stats.SetBits > 14 {
+		t.Fatalf("expected up to 14 bits set, got %d", stats.SetBits)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := m.Has(Value("afi")); err != nil || exists {
+		t.Fatalf("afi shouldn't exist once cleared, got %t, %v", exists, err)
+	}
+
+	if _, _, err := NewMemcached(client, "memcached-test", 15000, 7, 31*24*60*60); err == nil {
+		t.Fatal("expected an error for a TTL longer than 30 days")
+	}
+}
+
+func TestMemcachedChunks(t *testing.T) {
+	client := newMemcachedClient(t)
+	defer client.DeleteAll()
+
+	// A single partition of 10M bits takes three items.
+	m, _, err := NewMemcached(client, "memcached-chunks-test", 10000000, 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := m.filters[0].storage.(*MemcachedStorage)
+	if store.chunks() != 3 || store.chunkLength(2) != 1250000-2*memcachedChunkBytes {
+		t.Fatalf("expected 3 items, the last one of %d bytes, got %d items, the last one of %d bytes", 1250000-2*memcachedChunkBytes, store.chunks(), store.chunkLength(store.chunks()-1))
+	}
+
+	for _, bit := range []uint{0, 4194303, 4194304, 9999999} {
+		store.Append(bit)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.ExistsMany([]uint{0, 1, 4194303, 4194304, 9999998, 9999999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []bool{true, false, true, true, false, true}; fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("expected the bits %v across the items, got %v", expected, found)
+	}
+	if count, err := store.Count(); err != nil || count != 4 {
+		t.Fatalf("expected 4 bits set, got %d, %v", count, err)
+	}
+
+	if err := client.Delete(store.chunkKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Exists(4194304); !errors.Is(err, ErrEvicted) {
+		t.Fatalf("expected ErrEvicted checking a bit of an evicted item, got %v", err)
+	}
+	store.Append(4194305)
+	if err := store.Save(); !errors.Is(err, ErrEvicted) {
+		t.Fatalf("expected ErrEvicted saving a bit of an evicted item, got %v", err)
+	}
+	if _, _, err := NewMemcached(client, "memcached-chunks-test", 10000000, 1, -1); !errors.Is(err, ErrEvicted) {
+		t.Fatalf("expected ErrEvicted reopening a filter with an evicted item, got %v", err)
+	}
+}
+
+func TestMemcachedSizeMismatch(t *testing.T) {
+	client := newMemcachedClient(t)
+	defer client.DeleteAll()
+
+	if _, _, err := NewMemcached(client, "memcached-size-test", 1000, 1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewMemcached(client, "memcached-size-test", 2000, 1, -1); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch for an item created with a smaller size, got %v", err)
+	}
+}
+
+func TestMemcachedConcurrentSave(t *testing.T) {
+	client := newMemcachedClient(t)
+	defer client.DeleteAll()
+
+	filters := make([]*BF, 4)
+	for index := range filters {
+		m, _, err := NewMemcached(client, "memcached-concurrent-test", 15000, 7, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters[index] = m
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(filters))
+	for index, m := range filters {
+		wg.Add(1)
+		go func(index int, m *BF) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				m.Add(Value(fmt.Sprintf("afi.%d.%d", index, i)))
+				if err := m.Save(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(index, m)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for index := range filters {
+		for i := 0; i < 20; i++ {
+			value := Value(fmt.Sprintf("afi.%d.%d", index, i))
+			if exists, err := filters[0].Has(value); err != nil || !exists {
+				t.Fatalf("%s should exist once saved by another client, got %t, %v", value, exists, err)
+			}
+		}
+	}
+}
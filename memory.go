@@ -0,0 +1,64 @@
+package bloom
+
+import "fmt"
+
+// defaultMaxBytes is the largest number of bytes the in-memory backends allocate for a bloom filter unless
+// set WithMaxBytes.
+const defaultMaxBytes = 1 << 30
+
+// memorySizer is implemented by the storages keeping their bits in the process memory.
+type memorySizer interface {
+	memoryBytes() uint64
+}
+
+// bitsetBytes returns the number of bytes taken by a bitset of the given size, made of 64 bit words.
+func bitsetBytes(size uint) uint64 {
+	return (uint64(size) + 63) / 64 * 8
+}
+
+// checkMemory returns ErrTooLarge if the bitsets of the partitions would take more bytes than allowed by
+// the options, before they're allocated.
+func checkMemory(filters []filter, o options) error {
+	limit := o.maxBytes
+	if limit == 0 {
+		limit = defaultMaxBytes
+	}
+
+	var total uint64
+	for index, f := range filters {
+		if f.partition == index {
+			total += bitsetBytes(f.partitionBits)
+		}
+	}
+	if total > limit {
+		return fmt.Errorf("%w, %d bytes requested for a limit of %d", ErrTooLarge, total, limit)
+	}
+
+	return nil
+}
+
+// MemoryBytes returns the number of bytes the bloom filter keeps in memory for its bits, not counting the
+// values waiting in the queue. Only the Bitset, atomic Bitset and counting backends count, the bits stored
+// in Redis, a file or a memory mapped file aren't allocated by the process.
+func (b *BF) MemoryBytes() uint64 {
+	var total uint64
+	for _, f := range b.partitions() {
+		if sizer, ok := f.storage.(memorySizer); ok {
+			total += sizer.memoryBytes()
+		}
+	}
+
+	return total
+}
+
+func (s *BitsetStorage) memoryBytes() uint64 {
+	return uint64(len(s.store.Bytes())) * 8
+}
+
+func (s *AtomicBitsetStorage) memoryBytes() uint64 {
+	return uint64(len(s.words)) * 8
+}
+
+func (s *CountingStorage) memoryBytes() uint64 {
+	return uint64(len(s.counters))
+}
@@ -0,0 +1,172 @@
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+)
+
+// hasherProbe is hashed to tell whether two filters use the same hash function.
+var hasherProbe = []byte("go-bloom")
+
+// Union adds every value of the other bloom filter to this one by OR-ing the partition filters.
+// Both bloom filters need to use the Bitset backend and share the same Fingerprint, otherwise
+// ErrIncompatibleFilter is returned.
+func (b *BF) Union(other *BF) error {
+	stores, err := b.bitsetPairs(other)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range stores {
+		pair[0].store.InPlaceUnion(pair[1].store)
+		pair[0].set = pair[0].store.Count()
+	}
+
+	return nil
+}
+
+// Intersect keeps only the bits set in both bloom filters by AND-ing the partition filters. Values added
+// to both filters keep existing, but the intersection is only approximate: a value added to just one of
+// the filters (or to neither) can still exist if its bits were set by other values in the other filter,
+// so the false positive rate can be higher than for a filter built from the intersection of the sets.
+// Both bloom filters need to use the Bitset backend and share the same Fingerprint, otherwise
+// ErrIncompatibleFilter is returned.
+func (b *BF) Intersect(other *BF) error {
+	stores, err := b.bitsetPairs(other)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range stores {
+		pair[0].store.InPlaceIntersection(pair[1].store)
+		pair[0].set = pair[0].store.Count()
+	}
+
+	return nil
+}
+
+// Equal reports whether both bloom filters have the same Fingerprint and the same bits set, not counting
+// the values waiting in their queues. Bitset backed filters are compared bit by bit. Other backends, like
+// Redis, only compare the number of bits set in every partition, which is a cheap heuristic rather than
+// an exact comparison: filters with as many but different bits set are reported equal. Filters whose
+// bits can't be counted are reported different.
+func (b *BF) Equal(other *BF) bool {
+	if b.Fingerprint() != other.Fingerprint() {
+		return false
+	}
+
+	if stores, err := b.bitsetPairs(other); err == nil {
+		for _, pair := range stores {
+			if !pair[0].store.Equal(pair[1].store) {
+				return false
+			}
+		}
+		return true
+	}
+
+	counts, err := b.counts(context.Background())
+	if err != nil {
+		return false
+	}
+	otherCounts, err := other.counts(context.Background())
+	if err != nil || len(counts) != len(otherCounts) {
+		return false
+	}
+	for index, count := range counts {
+		if count != otherCounts[index] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// JaccardSimilarity estimates the Jaccard similarity |A∩B| / |A∪B| of the sets of values added to both
+// bloom filters. The sizes of A, B and A∪B are estimated from the bits set in both filters and in their
+// union, like EstimatedItemCount, and |A∩B| from |A| + |B| - |A∪B|. It's only an estimate, which gets
+// less accurate as the filters fill up, since every estimated size then varies more. Both bloom filters
+// need to use the Bitset backend and share the same Fingerprint, otherwise ErrIncompatibleFilter is
+// returned. ErrSaturated is returned if every bit of the union is set, and two empty filters have a
+// similarity of 0.
+func (b *BF) JaccardSimilarity(other *BF) (float64, error) {
+	stores, err := b.bitsetPairs(other)
+	if err != nil {
+		return 0, err
+	}
+
+	var a, o, union uint
+	for _, pair := range stores {
+		a += pair[0].store.Count()
+		o += pair[1].store.Count()
+		union += pair[0].store.UnionCardinality(pair[1].store)
+	}
+
+	size, hashIter := b.Parameters()
+	if union >= size {
+		return 0, ErrSaturated
+	}
+	if union == 0 {
+		return 0, nil
+	}
+
+	unionItems := estimateItems(union, size, hashIter)
+	intersection := estimateItems(a, size, hashIter) + estimateItems(o, size, hashIter) - unionItems
+
+	return math.Max(0, math.Min(1, intersection/unionItems)), nil
+}
+
+// bitsetPairs validates that both bloom filters are compatible Bitset filters and returns their
+// partition storages side by side.
+func (b *BF) bitsetPairs(other *BF) ([][2]*BitsetStorage, error) {
+	if len(b.filters) != len(other.filters) {
+		return nil, fmt.Errorf("%w: hash iterations mismatch: %d != %d", ErrIncompatibleFilter, len(b.filters), len(other.filters))
+	}
+
+	var stores [][2]*BitsetStorage
+	for index, f := range b.filters {
+		o := other.filters[index]
+
+		if f.partitionBits != o.partitionBits {
+			return nil, fmt.Errorf("%w: partition %d size mismatch: %d != %d", ErrIncompatibleFilter, index, f.partitionBits, o.partitionBits)
+		}
+		if f.multiplier != o.multiplier {
+			return nil, fmt.Errorf("%w: partition %d multiplier mismatch: %d != %d", ErrIncompatibleFilter, index, f.multiplier, o.multiplier)
+		}
+		if f.scheme != o.scheme {
+			return nil, fmt.Errorf("%w: partition %d hashing scheme mismatch", ErrIncompatibleFilter, index)
+		}
+		if !sameHasher(f, o) {
+			return nil, fmt.Errorf("%w: partition %d hasher mismatch", ErrIncompatibleFilter, index)
+		}
+
+		store, ok := f.storage.(*BitsetStorage)
+		if !ok {
+			return nil, ErrUnsupportedBackend
+		}
+		otherStore, ok := o.storage.(*BitsetStorage)
+		if !ok {
+			return nil, ErrUnsupportedBackend
+		}
+		if f.partition == index {
+			stores = append(stores, [2]*BitsetStorage{store, otherStore})
+		}
+	}
+
+	if b.Fingerprint() != other.Fingerprint() {
+		return nil, ErrIncompatibleFilter
+	}
+
+	return stores, nil
+}
+
+// sameHasher reports whether both filters hash values the same way, which includes using the same seed.
+func sameHasher(f, o filter) bool {
+	a := f.newHasher()
+	a.Write(hasherProbe)
+	b := o.newHasher()
+	b.Write(hasherProbe)
+
+	return bytes.Equal(a.Sum(nil), b.Sum(nil))
+}
@@ -0,0 +1,211 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrMetadataNotFound is returned by OpenRedis when there's no filter stored under the key.
+var ErrMetadataNotFound = errors.New("bloom: filter metadata not found")
+
+// OpenRedis opens the Redis backed bloom filter stored under the key, creating it with the size, hash
+// iterations, seed, hashing scheme, hash extraction, partitions and expiration it was first created with,
+// which NewRedis, NewRedisSharded and NewRedisWithConn store in the Redis hash key.meta when the filter
+// doesn't exist yet.
+// The options naming the keys, WithKeyPrefix and WithClusterHashTag, have to be given again, as well as
+// WithHasher, since the hasher can't be stored: it's checked against the Fingerprint of the filter, and
+// ErrIncompatibleFilter is returned if it differs. Other options, like WithRetry, apply as usual.
+// ErrMetadataNotFound is returned if the hash doesn't exist, like for a filter created before it was
+// stored, and an error if the filter is sharded, since it needs all of its pools.
+//
+// The hash expires with the partition keys, except WithSlidingTTL, where it doesn't expire at all since
+// the partition keys would outlive it. A filter whose partition keys expired is opened empty.
+func OpenRedis(pool *redis.Pool, key string, opts ...Option) (*BF, error) {
+	conns := poolConns(pool)
+	o := newOptions(opts)
+
+	conn, err := conns.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := redis.StringMap(boundConn(conn, o.redisTimeout).Do("HGETALL", metadataKey(key, o)))
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrMetadataNotFound
+	}
+
+	var parseErr error
+	parse := func(name string) uint64 {
+		value, err := strconv.ParseUint(fields[name], 10, 64)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("bloom: invalid metadata field %s: %w", name, err)
+		}
+		return value
+	}
+
+	size, hashIter, partitions := parse("size"), parse("hash_iter"), parse("partitions")
+	seed, scheme, fingerprint := parse("seed"), hashScheme(parse("scheme")), parse("fingerprint")
+	exactSize, singleKey, slidingTTL := parse("exact_size") == 1, parse("single_key") == 1, parse("sliding_ttl") == 1
+	shards := parse("shards")
+	expiredAfterSeconds, err := strconv.ParseInt(fields["expire"], 10, 64)
+	if parseErr == nil && err != nil {
+		parseErr = fmt.Errorf("bloom: invalid metadata field expire: %w", err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if scheme > mixedHashing {
+		return nil, fmt.Errorf("bloom: unsupported hashing scheme %d", scheme)
+	}
+	extraction, err := parseExtraction(fields["extraction"])
+	if err != nil {
+		return nil, err
+	}
+	if shards != 1 {
+		return nil, fmt.Errorf("bloom: filter %s is sharded over %d Redis servers", key, shards)
+	}
+
+	stored := func(o *options) {
+		o.seed = seed
+		o.scheme = scheme
+		o.extraction = extraction
+		o.partitions = uint(partitions)
+		o.exactSize = exactSize
+		o.singleKey = singleKey
+		o.slidingTTL = slidingTTL
+	}
+	bloom, _, err := newRedis([]*redisConns{conns}, key, uint(size), uint(hashIter), expiredAfterSeconds, append(opts[:len(opts):len(opts)], stored)...)
+	if err != nil {
+		return nil, err
+	}
+	if bloom.Fingerprint() != fingerprint {
+		return nil, ErrIncompatibleFilter
+	}
+
+	return bloom, nil
+}
+
+// metadataKey returns the key of the Redis hash holding the parameters of the filter, next to the
+// partition keys and in the same hash slot WithClusterHashTag.
+func metadataKey(key string, o options) string {
+	if o.clusterHashTag {
+		return fmt.Sprintf("%s{%s}.meta", o.keyPrefix, key)
+	}
+
+	return o.keyPrefix + key + ".meta"
+}
+
+// storeMetadata stores the parameters the bloom filter was created with in a Redis hash, for OpenRedis,
+// unless the hash already exists. The size is the one given to the constructor, which the partitions are
+// set up from again. The partitions on the same connections keep the key of the hash, for Destroy.
+func storeMetadata(conns *redisConns, key string, bloom *BF, size, hashIter uint, expiredAfterSeconds int64, shards int, o options) error {
+	metaKey := metadataKey(key, o)
+	for _, f := range bloom.partitions() {
+		if store := f.storage.(*RedisStorage); store.conns == conns {
+			store.metaKey = metaKey
+		}
+	}
+
+	conn, err := conns.get(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", metaKey))
+	if err != nil || exists {
+		return err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	err = conn.Send("HSET", metaKey,
+		"size", size,
+		"hash_iter", hashIter,
+		"partitions", o.partitions,
+		"exact_size", boolField(o.exactSize),
+		"seed", o.seed,
+		"scheme", uint8(o.scheme),
+		"extraction", extractionField(o.hashExtraction()),
+		"single_key", boolField(o.singleKey),
+		"sliding_ttl", boolField(o.slidingTTL),
+		"expire", expiredAfterSeconds,
+		"shards", shards,
+		"fingerprint", bloom.Fingerprint())
+	if err != nil {
+		return err
+	}
+	if expiredAfterSeconds > 0 && !o.slidingTTL {
+		if err := conn.Send("EXPIRE", metaKey, expiredAfterSeconds); err != nil {
+			return err
+		}
+	}
+
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractionField returns the value of the extraction field of the Redis hash, the byte order followed by
+// the offsets of a and b, like "big:0:4".
+func extractionField(e hashExtraction) string {
+	order := "big"
+	if e.littleEndian {
+		order = "little"
+	}
+
+	return fmt.Sprintf("%s:%d:%d", order, e.a, e.b)
+}
+
+// parseExtraction parses the extraction field of the Redis hash, which is missing from the hashes stored
+// before it was, meaning defaultExtraction.
+func parseExtraction(field string) (hashExtraction, error) {
+	if field == "" {
+		return defaultExtraction, nil
+	}
+
+	var order string
+	var e hashExtraction
+	parts := strings.Split(field, ":")
+	if len(parts) == 3 {
+		order = parts[0]
+		a, errA := strconv.ParseUint(parts[1], 10, 8)
+		b, errB := strconv.ParseUint(parts[2], 10, 8)
+		if errA == nil && errB == nil {
+			e = hashExtraction{order == "little", uint8(a), uint8(b)}
+		}
+	}
+	if (order != "big" && order != "little") || !e.valid() {
+		return e, fmt.Errorf("bloom: invalid metadata field extraction: %q", field)
+	}
+
+	return e, nil
+}
+
+// boolField returns the value of a boolean field of the Redis hash.
+func boolField(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
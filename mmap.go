@@ -0,0 +1,440 @@
+//go:build darwin || dragonfly || freebsd || linux || openbsd
+// +build darwin dragonfly freebsd linux openbsd
+
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// mmapFile is a memory mapped file shared by the partition filters of a bloom filter. unsynced is set
+// once a partition writes bits to the mapping, until the mapping is synced.
+type mmapFile struct {
+	file     *os.File
+	data     []byte
+	once     sync.Once
+	err      error
+	unsynced uint32
+}
+
+// sync flushes the whole mapping to disk with a single msync, if bits were written since the last sync.
+func (m *mmapFile) sync() error {
+	if atomic.SwapUint32(&m.unsynced, 0) == 0 {
+		return nil
+	}
+	if err := msync(m.data); err != nil {
+		atomic.StoreUint32(&m.unsynced, 1)
+		return err
+	}
+
+	return nil
+}
+
+// close unmaps and closes the file. It's safe to call multiple times.
+func (m *mmapFile) close() error {
+	m.once.Do(func() {
+		m.err = syscall.Munmap(m.data)
+		if err := m.file.Close(); m.err == nil {
+			m.err = err
+		}
+	})
+
+	return m.err
+}
+
+// MmapStorage is a struct representing the memory mapped file backend for the bloom filter. Every
+// partition filter owns a region of the file, with bit 0 being the most significant bit of the first
+// byte of the region.
+type MmapStorage struct {
+	mapping *mmapFile
+	data    []byte
+	queue   []uint
+	size    uint
+}
+
+// NewMmap creates and returns a new bloom filter using a memory mapped file as a backend. The file is
+// created if it doesn't exist, and an existing file needs to have the size the bloom filter requires.
+// Bits are written to the mapping on Save, which also syncs them to the file with msync.
+func NewMmap(path string, size, hashIter uint, opts ...Option) (*BF, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	bloom := BF{filterSetup(size, hashIter, o), o.observer, o.saves, o.capacityWatch()}
+
+	var length int64
+	for _, f := range bloom.partitions() {
+		length += int64((f.partitionBits + 7) / 8)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	switch info.Size() {
+	case 0:
+		if err := file.Truncate(length); err != nil {
+			file.Close()
+			return nil, err
+		}
+	case length:
+	default:
+		file.Close()
+		return nil, fmt.Errorf("bloom: %s is %d bytes instead of %d", path, info.Size(), length)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	mapping := &mmapFile{file: file, data: data}
+
+	var offset uint
+	for index, f := range bloom.filters {
+		if f.partition != index {
+			f.storage = bloom.filters[f.partition].storage
+		} else {
+			bytes := (f.partitionBits + 7) / 8
+			f.storage = &MmapStorage{mapping, data[offset : offset+bytes : offset+bytes], make([]uint, 0), f.partitionBits}
+			offset += bytes
+		}
+		bloom.filters[index] = f
+	}
+
+	return &bloom, nil
+}
+
+// Append appends the bit, which is to be saved, to the queue.
+func (s *MmapStorage) Append(bit uint) {
+	s.queue = append(s.queue, bit)
+}
+
+// Save sets the bits from the queue in the mapped file, which the bloom filter syncs to disk once every
+// partition is saved. It returns os.ErrClosed if values were added once the filter was closed, keeping
+// them queued.
+func (s *MmapStorage) Save() error {
+	if len(s.queue) == 0 {
+		return nil
+	}
+	if s.data == nil {
+		return os.ErrClosed
+	}
+
+	atomic.StoreUint32(&s.mapping.unsynced, 1)
+	for _, bit := range s.queue {
+		s.data[bit/8] |= 0x80 >> (bit % 8)
+	}
+	s.queue = s.queue[:0]
+
+	return nil
+}
+
+// Exists checks if the given bit exists in the mapped file.
+func (s *MmapStorage) Exists(bit uint) (bool, error) {
+	if s.data == nil {
+		return false, os.ErrClosed
+	}
+
+	return s.data[bit/8]&(0x80>>(bit%8)) != 0, nil
+}
+
+// ExistsMany checks if each of the given bits exists in the mapped file.
+func (s *MmapStorage) ExistsMany(bits []uint) ([]bool, error) {
+	if s.data == nil {
+		return nil, os.ErrClosed
+	}
+
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		ret[i] = s.data[bit/8]&(0x80>>(bit%8)) != 0
+	}
+
+	return ret, nil
+}
+
+// Count returns the number of bits set in the mapped file.
+func (s *MmapStorage) Count() (uint, error) {
+	if s.data == nil {
+		return 0, os.ErrClosed
+	}
+
+	var count uint
+	for _, b := range s.data {
+		count += uint(bits.OnesCount8(b))
+	}
+
+	return count, nil
+}
+
+// Clear unsets every bit in the mapped file, syncs it and empties the queue.
+func (s *MmapStorage) Clear() error {
+	if s.data == nil {
+		return os.ErrClosed
+	}
+
+	for i := range s.data {
+		s.data[i] = 0
+	}
+	s.queue = s.queue[:0]
+
+	return msync(s.data)
+}
+
+// discard empties the queue without setting its bits in the mapped file.
+func (s *MmapStorage) discard() {
+	s.queue = s.queue[:0]
+}
+
+// backend names the memory mapped backend in Config.
+func (s *MmapStorage) backend() string {
+	return "mmap"
+}
+
+// close unmaps the file shared by the partitions and forgets the region of this one, so a closed
+// storage returns os.ErrClosed instead of touching unmapped memory.
+func (s *MmapStorage) close() error {
+	s.data = nil
+
+	return s.mapping.close()
+}
+
+// sync flushes the mapping shared by the partitions to disk once they're all saved. A closed storage has
+// nothing left to sync.
+func (s *MmapStorage) sync() error {
+	if s.data == nil {
+		return nil
+	}
+
+	return s.mapping.sync()
+}
+
+// msync flushes the mapped data to disk. msync needs a page aligned address, so the data is extended back
+// to the start of its first page.
+func msync(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	page := uintptr(os.Getpagesize())
+	start := uintptr(unsafe.Pointer(&data[0]))
+	aligned := start &^ (page - 1)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, aligned, start-aligned+uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// OpenBitsetFile opens a bloom filter serialized by WriteTo or MarshalBinary into the file, mapping the
+// file read-only instead of reading its bits into memory, for filters too large to be loaded quickly, like
+// on query-only replicas. The header is read and validated like with ReadFrom, including the Fingerprint,
+// so a filter serialized WithHasher has to be opened with the same hasher; the other options only apply
+// if they don't change how values are hashed, like WithObserver. The bits are checked in place, in the
+// big endian words of the file. The filter is read-only: Save returns ErrReadOnly if values were added
+// since the last Save, discarding them, as does Clear, and the file has to be replaced to update the
+// filter. Close unmaps and closes the file.
+func OpenBitsetFile(path string, opts ...Option) (*BF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bloom, err := mapBitsetFile(file, newOptions(opts))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return bloom, nil
+}
+
+// mapBitsetFile reads the header of the serialized bloom filter in the file and maps its partitions.
+func mapBitsetFile(file *os.File, o options) (*BF, error) {
+	cr := &countingReader{r: bufio.NewReader(file)}
+	params, err := readParams(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every partition takes at least its header and a word, which bounds the partitions by the file size
+	// before allocating them.
+	if minimum := params.partitions * uint64(binary.Size(partitionHeader{})+8); uint64(info.Size()-cr.n) < minimum {
+		return nil, ErrTruncated
+	}
+
+	type region struct {
+		partition partitionHeader
+		offset    int64
+	}
+	regions := make([]region, params.partitions)
+	offset, total := cr.n, uint64(0)
+	for k := range regions {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var partition partitionHeader
+		if err := binary.Read(file, binary.BigEndian, &partition); err != nil {
+			return nil, readError(err)
+		}
+		if partition.Size == 0 || partition.Size > params.header.Size-total {
+			return nil, fmt.Errorf("bloom: invalid partition %d size %d", k, partition.Size)
+		}
+		total += partition.Size
+
+		offset += int64(binary.Size(partition))
+		regions[k] = region{partition, offset}
+		offset += int64((partition.Size + 63) / 64 * 8)
+		if offset > info.Size() {
+			return nil, ErrTruncated
+		}
+	}
+	if total != params.header.Size {
+		return nil, fmt.Errorf("bloom: partition sizes add up to %d instead of %d", total, params.header.Size)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(offset), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	mapping := &mmapFile{file: file, data: data}
+
+	filters := make([]filter, params.header.HashIter)
+	for k := range filters {
+		p := k % len(regions)
+		if k < len(regions) {
+			r := regions[k]
+			end := r.offset + int64((r.partition.Size+63)/64*8)
+			store := &mappedWordsStorage{mapping, data[r.offset:end:end], uint(r.partition.Size), false}
+			filters[k] = filter{uint(r.partition.Size), store, o.hasher, uint(r.partition.Multiplier), params.seed, params.scheme, params.extraction, k}
+		} else {
+			shared := filters[p]
+			filters[k] = filter{shared.partitionBits, shared.storage, o.hasher, uint(k + 1), params.seed, params.scheme, params.extraction, p}
+		}
+	}
+
+	bloom := &BF{filters, o.observer, o.saves, o.capacityWatch()}
+	if params.header.Version >= 4 && bloom.Fingerprint() != params.fingerprint {
+		mapping.close()
+		return nil, ErrIncompatibleFilter
+	}
+
+	return bloom, nil
+}
+
+// mappedWordsStorage is the read-only storage of a partition of a bloom filter opened with
+// OpenBitsetFile, checking its bits in the big endian 64 bit words of the file, where bit i is bit i % 64
+// of word i / 64, counting from the least significant bit.
+type mappedWordsStorage struct {
+	mapping  *mmapFile
+	data     []byte
+	size     uint
+	appended bool
+}
+
+// Append records that a value was added, so Save can report the filter is read-only.
+func (s *mappedWordsStorage) Append(uint) {
+	s.appended = true
+}
+
+// Save returns ErrReadOnly if values were added since the last Save, which are discarded.
+func (s *mappedWordsStorage) Save() error {
+	if s.appended {
+		s.appended = false
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// Exists checks if the given bit exists in its word of the mapped file.
+func (s *mappedWordsStorage) Exists(bit uint) (bool, error) {
+	if s.data == nil {
+		return false, os.ErrClosed
+	}
+
+	return s.exists(bit), nil
+}
+
+// ExistsMany checks if each of the given bits exists in the mapped file.
+func (s *mappedWordsStorage) ExistsMany(bits []uint) ([]bool, error) {
+	if s.data == nil {
+		return nil, os.ErrClosed
+	}
+
+	ret := make([]bool, len(bits))
+	for i, bit := range bits {
+		ret[i] = s.exists(bit)
+	}
+
+	return ret, nil
+}
+
+func (s *mappedWordsStorage) exists(bit uint) bool {
+	return s.data[bit/64*8+7-bit%64/8]&(1<<(bit%8)) != 0
+}
+
+// Count returns the number of bits set in the mapped file.
+func (s *mappedWordsStorage) Count() (uint, error) {
+	if s.data == nil {
+		return 0, os.ErrClosed
+	}
+
+	var count uint
+	for _, b := range s.data {
+		count += uint(bits.OnesCount8(b))
+	}
+
+	return count, nil
+}
+
+// Clear returns ErrReadOnly, since the mapped file can't be written, or os.ErrClosed once it's closed.
+func (s *mappedWordsStorage) Clear() error {
+	if s.data == nil {
+		return os.ErrClosed
+	}
+
+	return ErrReadOnly
+}
+
+// discard forgets the values added since the last Save, so Save doesn't return ErrReadOnly.
+func (s *mappedWordsStorage) discard() {
+	s.appended = false
+}
+
+// backend names the memory mapped backend in Config.
+func (s *mappedWordsStorage) backend() string {
+	return "mmap"
+}
+
+// close unmaps the file shared by the partitions, like MmapStorage.
+func (s *mappedWordsStorage) close() error {
+	s.data = nil
+
+	return s.mapping.close()
+}
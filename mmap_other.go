@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!openbsd
+
+package bloom
+
+// OpenBitsetFile is not supported on this platform, so it always returns ErrUnsupportedBackend.
+func OpenBitsetFile(path string, opts ...Option) (*BF, error) {
+	return nil, ErrUnsupportedBackend
+}
+
+// NewMmap is not supported on this platform, so it always returns ErrUnsupportedBackend.
+func NewMmap(path string, size, hashIter uint, opts ...Option) (*BF, error) {
+	return nil, ErrUnsupportedBackend
+}
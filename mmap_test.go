@@ -0,0 +1,313 @@
+//go:build darwin || dragonfly || freebsd || linux || openbsd
+// +build darwin dragonfly freebsd linux openbsd
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Append([]byte("afi"))
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b.filters[0].storage.(*MmapStorage).mapping.close()
+
+	reopened, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.filters[0].storage.(*MmapStorage).mapping.close()
+
+	exists, err := reopened.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the reopened file")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = reopened.Exists([]byte("amma"))
+	if exists {
+		t.Fatal("amma shouldn't exist in the reopened file")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewMmap(file.Name(), 20000, 7); err == nil {
+		t.Fatal("reopening the file with a different size should fail")
+	}
+}
+
+func TestMmapWithPartitions(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewMmap(file.Name(), 15000, 7, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.filters[0].storage.(*MmapStorage).mapping.close()
+
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 3*625 {
+		t.Fatalf("expected the file to hold 3 partitions of 625 bytes, got %d bytes", info.Size())
+	}
+
+	b.Append([]byte("afi"))
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := b.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the memory mapped backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMmapClose(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Add(Value("afi"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("closing the filter again should be safe, got %v", err)
+	}
+
+	reopened, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	exists, err := reopened.Has([]byte("afi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("afi should be saved by Close")
+	}
+}
+
+func TestMmapSaveSyncsOnce(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-mmap-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	store := b.filters[0].storage.(*MmapStorage)
+	b.Add(Value("afi"))
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&store.mapping.unsynced) != 1 {
+		t.Fatal("saving a partition shouldn't sync the mapping")
+	}
+
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for index, f := range b.filters {
+		if f.storage.(*MmapStorage).mapping != store.mapping {
+			t.Fatalf("partition %d doesn't share the mapping of the file", index)
+		}
+	}
+	if atomic.LoadUint32(&store.mapping.unsynced) != 0 {
+		t.Fatal("Save should sync the mapping once every partition is saved")
+	}
+}
+
+func TestMmapClosed(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b, err := NewMmap(file.Name(), 15000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(Value("afi"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Has([]byte("afi")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed checking a closed filter, got %v", err)
+	}
+	if _, err := b.Exist(Value("afi"), Value("amma")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed checking values of a closed filter, got %v", err)
+	}
+	if _, err := b.EstimateFalsePositiveRate(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed counting the bits of a closed filter, got %v", err)
+	}
+	if err := b.Clear(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed clearing a closed filter, got %v", err)
+	}
+	b.Add(Value("amma"))
+	if err := b.Save(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed saving to a closed filter, got %v", err)
+	}
+
+	serialized, err := ioutil.TempFile("", "go-bloom-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(serialized.Name())
+	if _, err := NewBitset(15000, 7).WriteTo(serialized); err != nil {
+		t.Fatal(err)
+	}
+	serialized.Close()
+
+	opened, err := OpenBitsetFile(serialized.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opened.Has([]byte("afi")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed checking a closed file, got %v", err)
+	}
+}
+
+func TestMmapOpenBitsetFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	b := NewBitset(15000, 7, WithPartitions(3), WithSeed(42))
+	b.AddString("foo")
+	b.AddString("bar")
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	opened, err := OpenBitsetFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, expected := range map[string]bool{"foo": true, "bar": true, "baz": false} {
+		exists, err := opened.ExistsString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Errorf("ExistsString(%q) = %v, expected %v", value, exists, expected)
+		}
+	}
+	if opened.Fingerprint() != b.Fingerprint() {
+		t.Error("opened filter has a different fingerprint")
+	}
+
+	opened.AddString("baz")
+	if err := opened.Save(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Save() = %v, expected ErrReadOnly", err)
+	}
+	if err := opened.Clear(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Clear() = %v, expected ErrReadOnly", err)
+	}
+	if exists, _ := opened.ExistsString("baz"); exists {
+		t.Error("baz shouldn't be added to a read-only filter")
+	}
+	if err := opened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenBitsetFile(file.Name(), WithHasher(fnv.New64a)); err != ErrIncompatibleFilter {
+		t.Errorf("OpenBitsetFile() with another hasher = %v, expected ErrIncompatibleFilter", err)
+	}
+}
+
+func TestMmapOpenBitsetFileCorrupt(t *testing.T) {
+	file, err := ioutil.TempFile("", "go-bloom-corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	header := func(size, hashIter, partitions uint64) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, encodingHeader{encodingVersion, size, hashIter})
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, doubleHashing)
+		buf.Write([]byte{0, 0, 4})
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, partitions)
+		return buf.Bytes()
+	}
+
+	if err := ioutil.WriteFile(file.Name(), header(1<<62, 1<<62, 1<<62), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBitsetFile(file.Name()); err == nil {
+		t.Fatal("expected an error opening a file claiming 2^62 hash iterations")
+	}
+
+	if err := ioutil.WriteFile(file.Name(), header(1<<30, maxEncodedHashIter, maxEncodedHashIter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBitsetFile(file.Name()); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated for partitions that can't fit in the file, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package bloom
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// NegativeCache wraps a bloom filter of the keys known to exist, like in a database, to skip the lookups
+// of keys that definitely don't: ShouldQuery only answers false for keys the filter never learned, and
+// false positives only cost a pointless lookup. It counts its answers, to measure how many lookups the
+// filter saves. The counters are safe for concurrent use, the filter only if its backend is, like with
+// NewBitsetAtomic, WithConcurrency or Redis.
+type NegativeCache struct {
+	bf      *BF
+	hits    uint64
+	misses  uint64
+	learned uint64
+}
+
+// NegativeCacheStats are the counters of a NegativeCache.
+type NegativeCacheStats struct {
+	// Hits is the number of keys ShouldQuery reported as definitely absent, which is the number of lookups
+	// saved.
+	Hits uint64
+	// Misses is the number of keys ShouldQuery reported as possibly present, including false positives.
+	Misses uint64
+	// Learned is the number of keys passed to Learn.
+	Learned uint64
+}
+
+// NewNegativeCache creates a NegativeCache over the bloom filter, which the keys that exist are added to.
+// The filter can already hold keys, like a filter restored from a snapshot of the database.
+func NewNegativeCache(bf *BF) *NegativeCache {
+	return &NegativeCache{bf: bf}
+}
+
+// ShouldQuery reports whether the key might exist and has to be looked up, which is false only when the
+// key was never learned. It returns true along with the error if the filter fails, so the key is looked up
+// anyway, and doesn't count it.
+func (c *NegativeCache) ShouldQuery(key []byte) (bool, error) {
+	return c.ShouldQueryContext(context.Background(), key)
+}
+
+// ShouldQueryContext is like ShouldQuery, but the Redis backend returns ctx.Err() once the context is done.
+func (c *NegativeCache) ShouldQueryContext(ctx context.Context, key []byte) (bool, error) {
+	exists, err := c.bf.HasContext(ctx, key)
+	if err != nil {
+		return true, err
+	}
+
+	if exists {
+		atomic.AddUint64(&c.misses, 1)
+	} else {
+		atomic.AddUint64(&c.hits, 1)
+	}
+	return exists, nil
+}
+
+// Learn records that the key exists, so ShouldQuery reports it has to be looked up. The key is added and
+// saved right away, unlike with Add, since a key missing from the filter would never be looked up again.
+func (c *NegativeCache) Learn(key []byte) error {
+	return c.LearnContext(context.Background(), key)
+}
+
+// LearnContext is like Learn, but the Redis backend returns ctx.Err() once the context is done.
+func (c *NegativeCache) LearnContext(ctx context.Context, key []byte) error {
+	c.bf.Add(key)
+	if err := c.bf.SaveContext(ctx); err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&c.learned, 1)
+	return nil
+}
+
+// Stats returns the counters of the NegativeCache.
+func (c *NegativeCache) Stats() NegativeCacheStats {
+	return NegativeCacheStats{atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses), atomic.LoadUint64(&c.learned)}
+}
+
+// HitRate returns the share of the keys checked by ShouldQuery that didn't have to be looked up, or 0 if
+// none were checked.
+func (c *NegativeCache) HitRate() float64 {
+	stats := c.Stats()
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+// BF returns the underlying bloom filter.
+func (c *NegativeCache) BF() *BF {
+	return c.bf
+}
@@ -0,0 +1,60 @@
+package bloom
+
+import "context"
+
+// Observer is notified about the use of a bloom filter, for exporting metrics without depending on a
+// metrics library. A Prometheus exporter would typically back IncAdd and IncQuery with counters and
+// SetFill with a gauge.
+type Observer interface {
+	// IncAdd is called for every value appended to the queue.
+	IncAdd()
+	// IncQuery is called for every value checked by Exist, Exists and their string variants, and once
+	// for every call of ExistsAll and ExistsAny, telling whether the value was found.
+	IncQuery(hit bool)
+	// SetFill is called with the ratio of bits set in the bloom filter after every successful Save.
+	SetFill(ratio float64)
+}
+
+// WithObserver sets the Observer notified about the use of the bloom filter. Updating the fill ratio
+// counts the bits set after every Save, which costs a BITCOUNT per partition with the Redis backend.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+// observeAdd reports n values appended to the queue.
+func (b *BF) observeAdd(n int) {
+	if b.observer == nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		b.observer.IncAdd()
+	}
+}
+
+// observeQueries reports the results of a membership query, unless it failed.
+func (b *BF) observeQueries(err error, hits ...bool) {
+	if b.observer == nil || err != nil {
+		return
+	}
+
+	for _, hit := range hits {
+		b.observer.IncQuery(hit)
+	}
+}
+
+// observeFill reports the ratio of bits set in the bloom filter. It's skipped if the bits can't be counted.
+func (b *BF) observeFill(ctx context.Context) {
+	if b.observer == nil {
+		return
+	}
+
+	stats, err := b.StatsContext(ctx)
+	if err != nil {
+		return
+	}
+
+	b.observer.SetFill(stats.FillRatio)
+}
@@ -0,0 +1,237 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"hash"
+	"time"
+)
+
+// Option is used to configure the bloom filter when it's created.
+type Option func(*options)
+
+// options holds the configuration shared by the bloom filter constructors.
+type options struct {
+	hasher         func() hash.Hash64
+	concurrent     bool
+	seed           uint64
+	scheme         hashScheme
+	extraction     hashExtraction
+	clusterHashTag bool
+	keyPrefix      string
+	singleKey      bool
+	observer       Observer
+	slidingTTL     bool
+	partitions     uint
+	exactSize      bool
+	saves          int
+	maxBytes       uint64
+	retryAttempts  int
+	retryBackoff   time.Duration
+	redisTimeout   time.Duration
+
+	// readConn is the func() Conn set WithReadConn. It's untyped since Conn belongs to the Redis
+	// backend, which the noredis build tag leaves out.
+	readConn interface{}
+
+	capacityRatio    float64
+	capacityCallback func(fill float64)
+}
+
+// newOptions applies the given options on top of the defaults.
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithHasher sets the hash function used by the bloom filter. A new hasher is created for every hashed
+// value. FNV-1 (fnv.New64) is used by default, or when the hasher is nil, and hashes integers without
+// allocating.
+func WithHasher(hasher func() hash.Hash64) Option {
+	return func(o *options) {
+		o.hasher = hasher
+	}
+}
+
+// WithSeed mixes a per instance seed into the hash of every value, so the bit positions of a value can't
+// be predicted without knowing the seed. Filters with different seeds map values to different bits, so a
+// persisted filter has to be reopened with the same seed; serialized filters store the seed themselves.
+// A zero seed hashes values without a seed.
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
+// WithClusterHashTag wraps the key of a Redis backed bloom filter in a hash tag, so the partition keys
+// are stored as {key}.1, {key}.2 and so on. Redis Cluster only hashes the tag, which puts all the
+// partitions of the filter in the same slot and allows multi key commands across them.
+func WithClusterHashTag() Option {
+	return func(o *options) {
+		o.clusterHashTag = true
+	}
+}
+
+// WithKeyPrefix prepends the prefix to the keys of a Redis backed bloom filter, so a filter created with
+// the key "bloom" and the prefix "app:env:" stores its partitions as app:env:bloom.1, app:env:bloom.2 and
+// so on, or app:env:bloom WithSingleKey. It lets several applications share a Redis database and have
+// their keys matched by a SCAN pattern. The prefix stays outside the hash tag WithClusterHashTag, and is
+// reported by Config, since a filter has to be reopened with the same prefix to find its keys.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithSingleKey stores every partition of a Redis backed filter in a single Redis string under the key,
+// instead of a key per partition. Partition i occupies the bits from i times the partition size, rounded
+// up to whole bytes so BITCOUNT can count each partition, so the filter only has one key to expire. The
+// layout differs from the key per partition one, so a filter has to be reopened with the same option.
+func WithSingleKey() Option {
+	return func(o *options) {
+		o.singleKey = true
+	}
+}
+
+// WithEnhancedDoubleHashing sets bit a + b*i + (i^3-i)/6 of partition i instead of a + b*i, where a and b
+// are the two 32 bit halves of the hash. The cubic term keeps values whose hashes only differ slightly
+// apart when the number of hash iterations is large. It changes the bits of every value, so a persisted
+// filter has to be reopened with the same option; serialized filters store the scheme themselves.
+func WithEnhancedDoubleHashing() Option {
+	return func(o *options) {
+		o.scheme = enhancedDoubleHashing
+	}
+}
+
+// WithHashMixing derives the bit of every hash iteration from the whole 64 bit hash of the value, as the
+// successive outputs of a splitmix64 generator seeded with it, instead of combining its two 32 bit halves
+// by double hashing. Double hashing only reaches a few distinct bits when many hash iterations share a
+// small partition and the second half of the hash has a common factor with its size, which raises the
+// false positive rate; mixed bits stay spread out however large the number of hash iterations is. Only the
+// last of WithHashMixing and WithEnhancedDoubleHashing applies, so NewBitsetStandard and NewStableBitset
+// keep using enhanced double hashing. It changes the bits of every value, so a persisted filter has to be
+// reopened with the same option; serialized filters store the scheme themselves.
+func WithHashMixing() Option {
+	return func(o *options) {
+		o.scheme = mixedHashing
+	}
+}
+
+// WithHashExtraction sets how the two 32 bit hash values combined into the bits of a value are read from
+// the 8 byte sum of the hasher, for sharing filters with other implementations deriving them differently:
+// a is read from the 4 bytes at offset a and b from those at offset b, in the given byte order, which is
+// binary.BigEndian or binary.LittleEndian. The default reads a from bytes 0 to 3 and b from bytes 4 to 7 as
+// big endian integers. The 64 bit hashes of AddHash and AddUint64 are read as their big endian bytes.
+// Serialized filters and Redis backed filters opened with OpenRedis keep the extraction. It panics if an
+// offset is larger than 4, if both are the same or if the order is another one.
+func WithHashExtraction(order binary.ByteOrder, a, b int) Option {
+	if (order != binary.BigEndian && order != binary.LittleEndian) || a < 0 || a > 4 || b < 0 || b > 4 || a == b {
+		panic("bloom: invalid hash extraction")
+	}
+
+	return func(o *options) {
+		o.extraction = hashExtraction{order == binary.LittleEndian, uint8(a), uint8(b)}
+	}
+}
+
+// hashExtraction returns the hashExtraction set WithHashExtraction, or defaultExtraction.
+func (o options) hashExtraction() hashExtraction {
+	if o.extraction == (hashExtraction{}) {
+		return defaultExtraction
+	}
+
+	return o.extraction
+}
+
+// WithSlidingTTL makes every Save of a Redis backed bloom filter reset the expiration of the partition
+// keys it writes to, so a filter expires expiredAfterSeconds after its last write instead of after it was
+// created. It doesn't do anything if expiredAfterSeconds isn't positive.
+func WithSlidingTTL() Option {
+	return func(o *options) {
+		o.slidingTTL = true
+	}
+}
+
+// WithRetry makes a Redis backed bloom filter try every operation up to attempts times when it fails with
+// a transient error, like a closed or reset connection or a LOADING, BUSY or READONLY reply during a
+// failover, waiting backoff before the first retry and twice as long before each of the next ones. Other
+// errors, like WRONGTYPE, are returned right away, as is the last error once the context is done. Retrying
+// is safe since SETBIT is idempotent and a failed transaction isn't applied. Operations are only tried
+// once by default, and creating the filter is never retried.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryBackoff = backoff
+	}
+}
+
+// WithRedisTimeout bounds the time a Redis backed bloom filter waits for every reply, so a slow or stuck
+// Redis server fails the operation with a timeout error instead of blocking it, like when the connections
+// are dialed with redis.DialReadTimeout. It applies to every command, including the ones creating the
+// filter, and a shorter context deadline still applies. A timed out attempt is retried WithRetry, and
+// the connection is closed since its reply would be read by the next command. Writes are only bounded by
+// redis.DialWriteTimeout, since redigo sets it when dialing. Connections of NewRedisWithConn which aren't a
+// redis.ConnWithTimeout aren't bounded. Zero or less waits as long as the connection does, by default.
+func WithRedisTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.redisTimeout = timeout
+	}
+}
+
+// WithConcurrency makes a Bitset backed bloom filter safe for concurrent use, by guarding every partition
+// filter with a sync.RWMutex. Appending, saving and clearing values take the write lock, while checking
+// values takes the read lock. Union, Intersect and serialization are not guarded.
+func WithConcurrency() Option {
+	return func(o *options) {
+		o.concurrent = true
+	}
+}
+
+// WithSaveConcurrency bounds the number of partitions saved at the same time by Save, which otherwise
+// saves up to 8 partitions at once. Every partition of a Redis backed filter holds a connection of
+// the pool while it's saved, so the pool needs to allow that many active connections. Zero or less
+// keeps the default.
+func WithSaveConcurrency(n int) Option {
+	return func(o *options) {
+		o.saves = n
+	}
+}
+
+// WithPartitions sets the number of partitions (p) the hash iterations set their bits in, instead of a
+// partition per hash iteration. The size is split evenly between the partitions, each getting
+// ceil(size / p) bits unless set WithExactSize, and hash iteration k sets its bits in partition k % p, so
+// 7 hash iterations over 4 partitions set 2 bits in each of the first 3 partitions and 1 in the last one.
+// A single partition is a standard bloom filter, like NewBitsetStandard. Zero, or more partitions than
+// hash iterations, keeps a partition per hash iteration.
+func WithPartitions(partitions uint) Option {
+	return func(o *options) {
+		o.partitions = partitions
+	}
+}
+
+// WithExactSize splits the size between the partitions so they add up to exactly the size, the first
+// size % p partitions getting one bit more than the others, instead of giving every partition
+// ceil(size / p) bits, which can add up to p - 1 bits more than the size. NewBitset(1000, 7) has 7
+// partitions of 143 bits, 1001 in total, while WithExactSize it has 6 partitions of 143 bits and one of
+// 142. The partition sizes change the bits of every value when the size isn't a multiple of the number
+// of partitions, so a persisted filter has to be reopened with the same option; serialized filters store
+// the partition sizes themselves. A size smaller than the number of partitions still gives every
+// partition a bit.
+func WithExactSize() Option {
+	return func(o *options) {
+		o.exactSize = true
+	}
+}
+
+// WithMaxBytes sets the largest number of bytes NewBitset, NewBitsetE and NewBitsetAtomic allocate for the
+// bits of the filter, 8 bytes per started 64 bits of every partition, so a mistyped size fails with
+// ErrTooLarge instead of exhausting the memory of the process. The limit is 1GB by default, or when n is
+// zero.
+func WithMaxBytes(n uint64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
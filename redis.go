@@ -1,24 +1,341 @@
+//go:build !noredis
+// +build !noredis
+
 package bloom
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gomodule/redigo/redis"
+	"sync"
+	"time"
 )
 
-// RedisStorage is a struct representing the Redis backend for the bloom filter.
+// NewRedis creates and returns a new bloom filter using Redis as a backend.
+// Every partition is stored under its own key, made of the key and the multiplier of the partition filter,
+// unless the filter is created WithSingleKey. The parameters of the filter are stored in the hash key.meta
+// the first time, so it can be opened again with OpenRedis. Existing keys have to hold exactly the bytes of
+// their bits, otherwise ErrSizeMismatch is returned, since a key created for another size would silently
+// read missing bits as unset.
+func NewRedis(pool *redis.Pool, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*BF, bool, error) {
+	return newRedis([]*redisConns{poolConns(pool)}, key, size, hashIter, expiredAfterSeconds, opts...)
+}
+
+// NewRedisSharded creates and returns a new bloom filter using several Redis servers as a backend, for
+// filters that don't fit in a single one. The partitions are spread over the pools, partition i being
+// stored on pools[i % len(pools)] under the same key as with NewRedis, so every bit is read and written
+// on the server of its partition and the partitions of a server share pipelined commands. Values are
+// checked with a round trip per server, pipelining the GETBITs of its partitions, instead of a single one
+// like with NewRedis. WithSingleKey and CheckAndAdd need a single server, so they're not supported. There
+// have to be at most as many pools as partitions, for every server to hold one.
+func NewRedisSharded(pools []*redis.Pool, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*BF, bool, error) {
+	if len(pools) == 0 {
+		return nil, false, errors.New("bloom: sharding needs at least one Redis pool")
+	}
+
+	shards := make([]*redisConns, len(pools))
+	for index, pool := range pools {
+		shards[index] = poolConns(pool)
+	}
+
+	return newRedis(shards, key, size, hashIter, expiredAfterSeconds, opts...)
+}
+
+// WithReadConn makes a Redis backed bloom filter check its bits over the connections of the factory, like
+// connections to a read replica, while saving and clearing them, counting the set bits and every other
+// command still go through the pool the filter was created with. Checks are then only as fresh as the
+// replica: a value saved on the primary might not exist for as long as the replication lag, usually a
+// few milliseconds but unbounded while the replica is disconnected, and cleared values still exist until
+// Clear reaches the replica. AddIfNotExists checks on the replica as well, so it can report a value added
+// by another client during the lag as added, while CheckAndAdd runs on the primary. With
+// WithClusterHashTag, every read connection is put in READONLY mode first, pipelined with the GETBITs, so
+// Redis Cluster replicas serve the checks instead of redirecting them to the primary. It isn't supported
+// by NewRedisSharded.
+func WithReadConn(factory func() Conn) Option {
+	return func(o *options) {
+		o.readConn = factory
+	}
+}
+
+// MustNewRedis is like NewRedis, but panics if the bloom filter can't be created, which makes it usable
+// for initializing package level variables. Use NewRedis to know whether the keys already existed.
+func MustNewRedis(pool *redis.Pool, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) *BF {
+	b, _, err := NewRedis(pool, key, size, hashIter, expiredAfterSeconds, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// NewRedisWithConn is like NewRedis, but gets its connections from the factory instead of a redis.Pool.
+// Every connection is closed once used.
+func NewRedisWithConn(factory func() Conn, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*BF, bool, error) {
+	return newRedis([]*redisConns{factoryConns(factory)}, key, size, hashIter, expiredAfterSeconds, opts...)
+}
+
+// newRedis creates the Redis backed bloom filter, with partition i using the connections of shard
+// i % len(shards).
+func newRedis(shards []*redisConns, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*BF, bool, error) {
+	if err := validateParameters(size, hashIter); err != nil {
+		return nil, false, err
+	}
+
+	o := newOptions(opts)
+	filters := filterSetup(size, hashIter, o)
+
+	bloom := BF{filters, o.observer, o.saves, o.capacityWatch()}
+	if partitions := len(bloom.partitions()); len(shards) > partitions {
+		return nil, false, fmt.Errorf("bloom: %d Redis shards for %d partitions", len(shards), partitions)
+	}
+	if o.readConn != nil {
+		if len(shards) > 1 {
+			return nil, false, errors.New("bloom: a sharded Redis filter can't use a single read connection")
+		}
+		shards[0].read = o.readConn.(func() Conn)
+		shards[0].readOnly = o.clusterHashTag
+	}
+	if o.redisTimeout > 0 {
+		for _, conns := range shards {
+			conns.withTimeout(o.redisTimeout)
+		}
+	}
+	if o.singleKey {
+		if len(shards) > 1 {
+			return nil, false, errors.New("bloom: a sharded Redis filter can't use a single key")
+		}
+		_, exist, err := newRedisSingleKey(shards[0], key, &bloom, expiredAfterSeconds, o)
+		if err != nil {
+			return &bloom, exist, err
+		}
+		return &bloom, exist, storeMetadata(shards[0], key, &bloom, size, hashIter, expiredAfterSeconds, 1, o)
+	}
+
+	var err error
+	var exist bool
+	for index, filter := range bloom.filters {
+		if filter.partition != index {
+			filter.storage = bloom.filters[filter.partition].storage
+			bloom.filters[index] = filter
+			continue
+		}
+
+		var store *RedisStorage
+		store, exist, err = newRedisStorage(shards[index%len(shards)], partitionKey(key, filter.multiplier, o), filter.partitionBits, expiredAfterSeconds)
+		store.slidingTTL = o.slidingTTL
+		store.prefix = o.keyPrefix
+		store.retry = retryPolicy{o.retryAttempts, o.retryBackoff}
+		filter.storage = store
+		if err != nil {
+			return &bloom, exist, err
+		}
+		bloom.filters[index] = filter
+	}
+
+	return &bloom, exist, storeMetadata(shards[0], key, &bloom, size, hashIter, expiredAfterSeconds, len(shards), o)
+}
+
+// newRedisSingleKey sets up the partitions of the bloom filter in consecutive byte aligned ranges of the
+// key, prefixed WithKeyPrefix, which is created as a whole if it doesn't exist.
+func newRedisSingleKey(conns *redisConns, key string, bloom *BF, expiredAfterSeconds int64, o options) (*BF, bool, error) {
+	var length uint
+	for _, f := range bloom.partitions() {
+		length += (f.partitionBits + 7) / 8 * 8
+	}
+
+	whole, exist, err := newRedisStorage(conns, o.keyPrefix+key, length, expiredAfterSeconds)
+	if err != nil {
+		return bloom, exist, err
+	}
+
+	var offset uint
+	for index, filter := range bloom.filters {
+		if filter.partition != index {
+			filter.storage = bloom.filters[filter.partition].storage
+		} else {
+			filter.storage = &RedisStorage{
+				conns:               whole.conns,
+				key:                 whole.key,
+				size:                filter.partitionBits,
+				queue:               make([]uint, 0),
+				expiredAfterSeconds: expiredAfterSeconds,
+				slidingTTL:          o.slidingTTL,
+				offset:              offset,
+				shared:              true,
+				prefix:              o.keyPrefix,
+				retry:               retryPolicy{o.retryAttempts, o.retryBackoff},
+				mu:                  new(sync.Mutex),
+			}
+			offset += (filter.partitionBits + 7) / 8 * 8
+		}
+		bloom.filters[index] = filter
+	}
+
+	return bloom, exist, nil
+}
+
+// RedisStorage is a struct representing the Redis backend for the bloom filter. Its bits start at the
+// offset of the key, which is only shared with other partitions WithSingleKey. The key already includes
+// the prefix, which is only kept for Config, and the metadata key is the one of the hash OpenRedis reads,
+// which Destroy deletes along with the partitions.
 type RedisStorage struct {
-	pool  *redis.Pool
-	key   string
-	size  uint
-	queue []uint
+	conns               *redisConns
+	key                 string
+	size                uint
+	queue               []uint
+	expiredAfterSeconds int64
+	slidingTTL          bool
+	offset              uint
+	shared              bool
+	prefix              string
+	retry               retryPolicy
+	metaKey             string
+	mu                  *sync.Mutex
+}
+
+// Conn is the subset of redis.Conn used by the Redis backend, for getting connections from something
+// else than a redis.Pool, like a mock in tests. With a redis.Conn, commands given a context use its
+// deadline as the read timeout.
+type Conn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Send(commandName string, args ...interface{}) error
+	Flush() error
+	Receive() (reply interface{}, err error)
+	Close() error
+}
+
+// redisConns gets the connections of the Redis backends, which share it when they belong to the same
+// bloom filter. Bits are checked over the connections of read if set WithReadConn, which are put in
+// READONLY mode first if readOnly is set.
+type redisConns struct {
+	get      func(ctx context.Context) (Conn, error)
+	read     func() Conn
+	readOnly bool
+}
+
+// poolConns gets the connections from the pool.
+func poolConns(pool *redis.Pool) *redisConns {
+	return &redisConns{func(ctx context.Context) (Conn, error) {
+		return pool.GetContext(ctx)
+	}, nil, false}
+}
+
+// factoryConns gets the connections from the factory, which doesn't use the context.
+func factoryConns(factory func() Conn) *redisConns {
+	return &redisConns{func(context.Context) (Conn, error) {
+		return factory(), nil
+	}, nil, false}
+}
+
+// withTimeout bounds the commands of the connections by the timeout, as set WithRedisTimeout.
+func (c *redisConns) withTimeout(timeout time.Duration) {
+	get, read := c.get, c.read
+	c.get = func(ctx context.Context) (Conn, error) {
+		conn, err := get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return boundConn(conn, timeout), nil
+	}
+	if read != nil {
+		c.read = func() Conn {
+			return boundConn(read(), timeout)
+		}
+	}
 }
 
-// NewRedisStorage creates a Redis backend storage to be used with the bloom filter.
+// timeoutConn is a connection whose replies are read with a timeout, like a connection dialed with
+// redis.DialReadTimeout, unless the command is given a shorter one, like the deadline of a context.
+type timeoutConn struct {
+	redis.Conn
+	timeout time.Duration
+}
+
+// boundConn returns the connection reading its replies with the timeout, unless the timeout isn't
+// positive or the connection doesn't support timeouts, like the ones of tests, which are returned as is.
+func boundConn(conn Conn, timeout time.Duration) Conn {
+	rc, ok := conn.(redis.Conn)
+	if _, timeouts := conn.(redis.ConnWithTimeout); !ok || !timeouts || timeout <= 0 {
+		return conn
+	}
+
+	return timeoutConn{rc, timeout}
+}
+
+// shorter returns the timeout, unless it's zero or longer than the one of the connection.
+func (c timeoutConn) shorter(timeout time.Duration) time.Duration {
+	if timeout <= 0 || timeout > c.timeout {
+		return c.timeout
+	}
+
+	return timeout
+}
+
+func (c timeoutConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, c.timeout, commandName, args...)
+}
+
+func (c timeoutConn) Receive() (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, c.timeout)
+}
+
+func (c timeoutConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, c.shorter(timeout), commandName, args...)
+}
+
+func (c timeoutConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, c.shorter(timeout))
+}
+
+// getRead gets a connection for checking bits. A READONLY command is sent on it without being flushed
+// when readOnly is set, so it takes no round trip of its own, and the number of replies it adds is
+// returned.
+func (c *redisConns) getRead(ctx context.Context) (Conn, int, error) {
+	if c.read == nil {
+		conn, err := c.get(ctx)
+		return conn, 0, err
+	}
+
+	conn := c.read()
+	if !c.readOnly {
+		return conn, 0, nil
+	}
+	if err := conn.Send("READONLY"); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	return conn, 1, nil
+}
+
+// NewRedisStorage creates a Redis backend storage to be used with the bloom filter, holding the size bits
+// of a single partition under the key. ErrSizeMismatch is returned if the key exists with another size.
 func NewRedisStorage(pool *redis.Pool, key string, size uint, expiredAfterSeconds int64) (*RedisStorage, bool, error) {
-	var err error
+	return newRedisStorage(poolConns(pool), key, size, expiredAfterSeconds)
+}
 
-	store := RedisStorage{pool, key, size, make([]uint, 0)}
+// NewRedisStorageWithConn is like NewRedisStorage, but gets its connections from the factory instead of
+// a redis.Pool. Every connection is closed once used.
+func NewRedisStorageWithConn(factory func() Conn, key string, size uint, expiredAfterSeconds int64) (*RedisStorage, bool, error) {
+	return newRedisStorage(factoryConns(factory), key, size, expiredAfterSeconds)
+}
 
-	conn := store.pool.Get()
+// newRedisStorage creates a Redis backend storage getting its connections from conns.
+func newRedisStorage(conns *redisConns, key string, size uint, expiredAfterSeconds int64) (*RedisStorage, bool, error) {
+	store := RedisStorage{
+		conns:               conns,
+		key:                 key,
+		size:                size,
+		queue:               make([]uint, 0),
+		expiredAfterSeconds: expiredAfterSeconds,
+		mu:                  new(sync.Mutex),
+	}
+
+	conn, err := store.conns.get(context.Background())
+	if err != nil {
+		return &store, false, err
+	}
 	defer conn.Close()
 	exists, err := redis.Bool(conn.Do("EXISTS", key))
 	if err != nil {
@@ -29,56 +346,713 @@ func NewRedisStorage(pool *redis.Pool, key string, size uint, expiredAfterSecond
 		if err := store.init(expiredAfterSeconds); err != nil {
 			return &store, exists, err
 		}
+		return &store, exists, nil
+	}
+
+	length, err := redis.Uint64(conn.Do("STRLEN", key))
+	if err != nil {
+		return &store, exists, err
+	}
+	if expected := uint64((size + 7) / 8); length != expected {
+		return &store, exists, fmt.Errorf("%w: key %s holds %d bytes instead of %d for %d bits", ErrSizeMismatch, key, length, expected, size)
 	}
 
 	return &store, exists, nil
 }
 
-// init takes care of settings every bit to 0 in the Redis bitset.
+// init takes care of settings every bit to 0 in the Redis bitset. Setting the last bit is enough, since
+// Redis zero fills the string up to it. The key only expires if expiredAfterSeconds is positive.
 func (s *RedisStorage) init(expiredAfterSeconds int64) (err error) {
-	conn := s.pool.Get()
+	conn, err := s.conns.get(context.Background())
+	if err != nil {
+		return
+	}
 	defer conn.Close()
 
-	var i uint
-	for i = 0; i < s.size; i++ {
-		conn.Send("SETBIT", s.key, i, 0)
+	commands := 1
+	if err = conn.Send("SETBIT", s.key, s.offset+s.size-1, 0); err != nil {
+		return
+	}
+	if expiredAfterSeconds > 0 {
+		commands++
+		if err = conn.Send("EXPIRE", s.key, expiredAfterSeconds); err != nil {
+			return
+		}
+	}
+	if err = conn.Flush(); err != nil {
+		return
+	}
+
+	for i := 0; i < commands; i++ {
+		if _, err = conn.Receive(); err != nil {
+			return
+		}
 	}
-	_ = conn.Send("EXPIRE", s.key, expiredAfterSeconds)
-	err = conn.Flush()
 
 	return
 }
 
-// Append appends the bit, which is to be saved, to the queue.
+// Append appends the bit, which is to be saved, to the queue. It's safe to call concurrently with Save.
 func (s *RedisStorage) Append(bit uint) {
+	s.mu.Lock()
 	s.queue = append(s.queue, bit)
+	s.mu.Unlock()
+}
+
+// drain takes the bits of the queue, without duplicates, leaving an empty queue for the bits appended
+// while they're saved.
+func (s *RedisStorage) drain() []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bits := uniqueBits(s.queue)
+	s.queue = nil
+	return bits
+}
+
+// requeue puts the bits taken by drain back in front of the queue, since they failed to be saved.
+func (s *RedisStorage) requeue(bits []uint) {
+	s.mu.Lock()
+	s.queue = append(bits, s.queue...)
+	s.mu.Unlock()
 }
 
 // Save pushes the bits from the queue to the storage backend, assigning the value 1 in the process.
-func (s *RedisStorage) Save() {
+// Duplicate bits are only sent once and the writes are wrapped in a MULTI/EXEC transaction, so other
+// clients never see a partially saved queue. The queue is taken under a mutex before it's sent, so bits
+// can be appended while it's saved, and stay queued for the next Save.
+func (s *RedisStorage) Save() error {
+	return s.SaveContext(context.Background())
+}
 
-	if len(s.queue) <= 0 {
-		return
+// SaveContext is like Save, but returns ctx.Err() if the context is done before the transaction is
+// executed. Errors from sending the commands, executing the transaction or any of the SETBIT commands
+// are returned, and the queue is kept unless every bit was saved. With a sliding TTL, the expiration of
+// the key is reset with EXPIRE in the same transaction.
+func (s *RedisStorage) SaveContext(ctx context.Context) error {
+	bits := s.drain()
+	if len(bits) == 0 {
+		return nil
 	}
 
-	conn := s.pool.Get()
-	defer conn.Close()
+	err := s.retry.do(ctx, func() error {
+		conn, err := s.conns.get(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if _, err := s.sendSave(conn, bits); err != nil {
+			return err
+		}
+
+		return execError(redis.Values(doContext(ctx, conn, "EXEC")))
+	})
+	if err != nil {
+		s.requeue(bits)
+		return err
+	}
+
+	return nil
+}
 
-	for _, bit := range s.queue {
-		conn.Send("SETBIT", s.key, bit, 1)
+// sendSave sends the MULTI, SETBIT and EXPIRE commands saving the bits taken from the queue by drain,
+// leaving EXEC to the caller. It returns the number of commands sent.
+func (s *RedisStorage) sendSave(conn Conn, bits []uint) (int, error) {
+	if err := conn.Send("MULTI"); err != nil {
+		return 0, err
+	}
+	for _, bit := range bits {
+		if err := conn.Send("SETBIT", s.key, s.offset+bit, 1); err != nil {
+			return 0, err
+		}
+	}
+	commands := len(bits) + 1
+	if s.slidingTTL && s.expiredAfterSeconds > 0 {
+		if err := conn.Send("EXPIRE", s.key, s.expiredAfterSeconds); err != nil {
+			return 0, err
+		}
+		commands++
 	}
 
-	conn.Flush()
+	return commands, nil
+}
+
+// execError returns the error of an EXEC reply, or of the first command of the transaction that failed.
+func execError(replies []interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Exists checks if the given bit exists in the Redis backend.
 func (s *RedisStorage) Exists(bit uint) (ret bool, err error) {
-	conn := s.pool.Get()
+	return s.ExistsContext(context.Background(), bit)
+}
+
+// ExistsContext is like Exists, but returns ctx.Err() if the context is done before Redis replies.
+func (s *RedisStorage) ExistsContext(ctx context.Context, bit uint) (ret bool, err error) {
+	err = s.retry.do(ctx, func() error {
+		conn, _, err := s.conns.getRead(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		bitValue, err := redis.Int(doContext(ctx, conn, "GETBIT", s.key, s.offset+bit))
+		ret = bitValue == 1
+		return err
+	})
+
+	return ret && err == nil, err
+}
+
+// ExistsMany checks if each of the given bits exists in the Redis backend, pipelining the GETBIT
+// commands into a single round trip.
+func (s *RedisStorage) ExistsMany(bits []uint) ([]bool, error) {
+	return s.ExistsManyContext(context.Background(), bits)
+}
+
+// ExistsManyContext is like ExistsMany, but returns ctx.Err() if the context is done before all the
+// replies have been read.
+func (s *RedisStorage) ExistsManyContext(ctx context.Context, bits []uint) ([]bool, error) {
+	ret := make([]bool, len(bits))
+	if len(bits) == 0 {
+		return ret, nil
+	}
+
+	err := s.retry.do(ctx, func() error {
+		conn, pending, err := s.conns.getRead(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for _, bit := range bits {
+			if err := conn.Send("GETBIT", s.key, s.offset+bit); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := receiveContext(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		for i := range bits {
+			bitValue, err := redis.Int(receiveContext(ctx, conn))
+			if err != nil {
+				return err
+			}
+			ret[i] = bitValue == 1
+		}
+
+		return nil
+	})
+
+	return ret, err
+}
+
+// Count returns the number of bits set in the Redis backend.
+func (s *RedisStorage) Count() (uint, error) {
+	return s.CountContext(context.Background())
+}
+
+// CountContext is like Count, but returns ctx.Err() if the context is done before Redis replies.
+func (s *RedisStorage) CountContext(ctx context.Context) (uint, error) {
+	var count uint64
+	err := s.retry.do(ctx, func() error {
+		conn, err := s.conns.get(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		count, err = redis.Uint64(doContext(ctx, conn, "BITCOUNT", s.countArgs()...))
+		return err
+	})
+
+	return uint(count), err
+}
+
+// countArgs returns the arguments of the BITCOUNT counting the bits of the Redis backend, limited to its
+// bytes when it shares the key with other partitions.
+func (s *RedisStorage) countArgs() []interface{} {
+	if !s.shared {
+		return []interface{}{s.key}
+	}
+
+	return []interface{}{s.key, s.offset / 8, (s.offset+s.size+7)/8 - 1}
+}
+
+// Clear deletes the Redis bitset, initializes it again and empties the queue. A partition sharing the
+// key with others has its bytes zeroed with SETRANGE instead.
+func (s *RedisStorage) Clear() error {
+	s.mu.Lock()
+	s.queue = nil
+	s.mu.Unlock()
+
+	return s.retry.do(context.Background(), func() error {
+		conn, err := s.conns.get(context.Background())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if s.shared {
+			_, err := conn.Do("SETRANGE", s.key, s.offset/8, make([]byte, (s.size+7)/8))
+			return err
+		}
+
+		if _, err := conn.Do("DEL", s.key); err != nil {
+			return err
+		}
+
+		return s.init(s.expiredAfterSeconds)
+	})
+}
+
+// discard empties the queue without sending its bits to Redis.
+func (s *RedisStorage) discard() {
+	s.mu.Lock()
+	s.queue = nil
+	s.mu.Unlock()
+}
+
+// CountRange returns the number of bits set between start and end, which are byte offsets unless bits
+// is true. Negative offsets count from the end of the string, like with BITCOUNT. Bit offsets need
+// Redis 7.0 or later. The offsets are within the whole key, even if it's shared WithSingleKey.
+func (s *RedisStorage) CountRange(start, end int64, bits bool) (uint, error) {
+	conn, err := s.conns.get(context.Background())
+	if err != nil {
+		return 0, err
+	}
 	defer conn.Close()
 
-	bitValue, err := redis.Int(conn.Do("GETBIT", s.key, bit))
+	args := []interface{}{s.key, start, end}
+	if bits {
+		args = append(args, "BIT")
+	}
+
+	count, err := redis.Uint64(conn.Do("BITCOUNT", args...))
+	return uint(count), err
+}
+
+// countGroup groups the Redis backends by their connections, so their bits are counted over a single
+// connection.
+func (s *RedisStorage) countGroup() interface{} {
+	return s.conns
+}
+
+// countMany counts the bits set in each of the Redis backends, which share the connections of this one, with
+// pipelined BITCOUNTs.
+func (s *RedisStorage) countMany(ctx context.Context, stores []storage) ([]uint, error) {
+	counts := make([]uint, len(stores))
+	err := s.retry.do(ctx, func() error {
+		conn, err := s.conns.get(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for _, store := range stores {
+			if err := conn.Send("BITCOUNT", store.(*RedisStorage).countArgs()...); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		for i := range stores {
+			count, err := redis.Uint64(receiveContext(ctx, conn))
+			if err != nil {
+				return err
+			}
+			counts[i] = uint(count)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// existsAcross checks bits[i] in each of the Redis backends stores[i], which share the connections of
+// this one, pipelining all the GETBITs into a single round trip over a read connection.
+func (s *RedisStorage) existsAcross(ctx context.Context, stores []storage, bits []uint) ([]bool, error) {
+	found := make([]bool, len(bits))
+	err := s.retry.do(ctx, func() error {
+		conn, pending, err := s.conns.getRead(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for i, store := range stores {
+			store := store.(*RedisStorage)
+			if err := conn.Send("GETBIT", store.key, store.offset+bits[i]); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := receiveContext(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		for i := range bits {
+			bitValue, err := redis.Int(receiveContext(ctx, conn))
+			if err != nil {
+				return err
+			}
+			found[i] = bitValue == 1
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// anySet reports whether a bit is set in any of the Redis backends, which share the connections of this
+// one, with pipelined BITPOS commands.
+func (s *RedisStorage) anySet(ctx context.Context, stores []storage) (bool, error) {
+	set := false
+	err := s.retry.do(ctx, func() error {
+		conn, err := s.conns.get(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for _, store := range stores {
+			args := append([]interface{}{store.(*RedisStorage).key, 1}, store.(*RedisStorage).countArgs()[1:]...)
+			if err := conn.Send("BITPOS", args...); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		set = false
+		for range stores {
+			position, err := redis.Int64(receiveContext(ctx, conn))
+			if err != nil {
+				return err
+			}
+			set = set || position >= 0
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return set, nil
+}
+
+// destroyMany deletes the keys of the Redis backends, which share the connections of this one, and the
+// metadata hash, with pipelined DELs, emptying their queues. Keys shared WithSingleKey are deleted once.
+func (s *RedisStorage) destroyMany(ctx context.Context, stores []storage) error {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, store := range stores {
+		for _, key := range []string{store.(*RedisStorage).key, store.(*RedisStorage).metaKey} {
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	err := s.retry.do(ctx, func() error {
+		conn, err := s.conns.get(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		for _, key := range keys {
+			if err := conn.Send("DEL", key); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		for range keys {
+			if _, err := receiveContext(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		store.(*RedisStorage).drain()
+	}
+	return nil
+}
+
+// backend names the Redis backend in Config.
+func (s *RedisStorage) backend() string {
+	return "redis"
+}
+
+// keyPrefix returns the prefix of the key for Config.
+func (s *RedisStorage) keyPrefix() string {
+	return s.prefix
+}
+
+// clone copies the partition key to the key with the suffix using COPY, and returns a Redis backend for
+// the copy sharing the connections of this one. The queue is copied as well. Partitions sharing a single
+// key are copied along with the first one, at offset 0, so the others only point to the copy.
+func (s *RedisStorage) clone(suffix string) (storage, error) {
+	key := s.key + suffix
+	if !s.shared || s.offset == 0 {
+		conn, err := s.conns.get(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		copied, err := redis.Bool(conn.Do("COPY", s.key, key))
+		if err != nil {
+			return nil, err
+		}
+		if !copied {
+			return nil, fmt.Errorf("bloom: clone key %s already exists", key)
+		}
+	}
+
+	s.mu.Lock()
+	queue := append([]uint(nil), s.queue...)
+	s.mu.Unlock()
+
+	return &RedisStorage{
+		conns:               s.conns,
+		key:                 key,
+		size:                s.size,
+		queue:               queue,
+		expiredAfterSeconds: s.expiredAfterSeconds,
+		slidingTTL:          s.slidingTTL,
+		offset:              s.offset,
+		shared:              s.shared,
+		prefix:              s.prefix,
+		retry:               s.retry,
+		mu:                  new(sync.Mutex),
+	}, nil
+}
+
+// ttl returns the remaining time to live of the key with PTTL, or a negative duration if it doesn't
+// expire. A missing key is reported as an error, since its bits are lost.
+func (s *RedisStorage) ttl() (time.Duration, error) {
+	conn, err := s.conns.get(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	ttl, err := redis.Int64(conn.Do("PTTL", s.key))
+	if err != nil {
+		return 0, err
+	}
+	if ttl == -2 {
+		return 0, fmt.Errorf("bloom: key %s doesn't exist", s.key)
+	}
+	if ttl < 0 {
+		return -1, nil
+	}
+
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// persist removes the expiration of the key with PERSIST. A sliding TTL would expire it again on the
+// next Save, so it's turned off as well.
+func (s *RedisStorage) persist() error {
+	conn, err := s.conns.get(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("PERSIST", s.key); err != nil {
+		return err
+	}
+
+	s.expiredAfterSeconds = -1
+	return nil
+}
+
+// dump returns the bytes of the Redis backend with GET, or with GETRANGE when it shares the key with
+// other partitions, zero filled up to the size of the backend. A missing key is reported as an error,
+// since its bits are lost.
+func (s *RedisStorage) dump(ctx context.Context) (data []byte, err error) {
+	err = s.retry.do(ctx, func() error {
+		data, err = s.dumpOnce(ctx)
+		return err
+	})
+
+	return data, err
+}
+
+func (s *RedisStorage) dumpOnce(ctx context.Context) ([]byte, error) {
+	conn, err := s.conns.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var reply interface{}
+	if s.shared {
+		reply, err = doContext(ctx, conn, "GETRANGE", s.key, s.offset/8, (s.offset+s.size+7)/8-1)
+	} else {
+		reply, err = doContext(ctx, conn, "GET", s.key)
+	}
+	data, err := redis.Bytes(reply, err)
+	if err == redis.ErrNil {
+		return nil, fmt.Errorf("bloom: key %s doesn't exist", s.key)
 	}
-	return bitValue == 1, err
+	if err != nil {
+		return nil, err
+	}
+
+	length := int((s.size + 7) / 8)
+	if len(data) > length {
+		data = data[:length]
+	}
+
+	return append(data, make([]byte, length-len(data))...), nil
+}
+
+// restore replaces the bytes of the Redis backend with SET, or with SETRANGE when it shares the key with
+// other partitions, and sets the expiration of the key again in the same transaction.
+func (s *RedisStorage) restore(ctx context.Context, data []byte) error {
+	return s.retry.do(ctx, func() error {
+		return s.restoreOnce(ctx, data)
+	})
+}
+
+func (s *RedisStorage) restoreOnce(ctx context.Context, data []byte) error {
+	conn, err := s.conns.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if s.shared {
+		err = conn.Send("SETRANGE", s.key, s.offset/8, data)
+	} else {
+		err = conn.Send("SET", s.key, data)
+	}
+	if err != nil {
+		return err
+	}
+	if s.expiredAfterSeconds > 0 {
+		if err := conn.Send("EXPIRE", s.key, s.expiredAfterSeconds); err != nil {
+			return err
+		}
+	}
+
+	replies, err := redis.Values(doContext(ctx, conn, "EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// doContext executes the command on the connection, using the context deadline as the read timeout if the
+// connection supports timeouts. The context is only checked between commands, so a cancelled context
+// doesn't interrupt a blocked read.
+func doContext(ctx context.Context, conn Conn, cmd string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	var err error
+	deadline, ok := ctx.Deadline()
+	if rc, timeouts := conn.(redis.ConnWithTimeout); ok && timeouts {
+		reply, err = redis.DoWithTimeout(rc, time.Until(deadline), cmd, args...)
+	} else {
+		reply, err = conn.Do(cmd, args...)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return reply, err
+}
+
+// receiveContext receives a pipelined reply from the connection, using the context deadline as the
+// read timeout if the connection supports timeouts.
+func receiveContext(ctx context.Context, conn Conn) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	var err error
+	deadline, ok := ctx.Deadline()
+	if rc, timeouts := conn.(redis.ConnWithTimeout); ok && timeouts {
+		reply, err = redis.ReceiveWithTimeout(rc, time.Until(deadline))
+	} else {
+		reply, err = conn.Receive()
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return reply, err
 }
@@ -0,0 +1,2489 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRedisPool(maxIdle int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     maxIdle,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", fmt.Sprintf("%s:6379", os.Getenv("REDIS_HOST")))
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := c.Do("AUTH", os.Getenv("REDIS_PASSWORD")); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		},
+	}
+}
+
+func TestRedisInit(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	_, _, err := NewRedis(pool, "redis-init-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisInitLength(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-init-length-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, f := range r.filters {
+		key := f.storage.(*RedisStorage).key
+
+		length, err := redis.Int(conn.Do("STRLEN", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := int((f.partitionBits + 7) / 8); length != expected {
+			t.Fatalf("%s should be %d bytes long, got %d", key, expected, length)
+		}
+	}
+
+	r, _, err = NewRedis(pool, "redis-init-ttl-test", 15000, 7, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range r.filters {
+		key := f.storage.(*RedisStorage).key
+
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > 60 {
+			t.Fatalf("%s should expire within 60 seconds, got a TTL of %d", key, ttl)
+		}
+	}
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisSlidingTTL(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, sliding := range []bool{false, true} {
+		var opts []Option
+		if sliding {
+			opts = append(opts, WithSlidingTTL())
+		}
+
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-sliding-ttl-test.%v", sliding), 15000, 7, 60, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Wind the TTL down instead of waiting for it, as if 55 seconds had passed since the filter was created.
+		for _, f := range r.filters {
+			conn.Do("EXPIRE", f.storage.(*RedisStorage).key, 5)
+		}
+
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range r.filters {
+			key := f.storage.(*RedisStorage).key
+
+			ttl, err := redis.Int(conn.Do("TTL", key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sliding && ttl <= 5 {
+				t.Fatalf("%s should expire 60 seconds after being saved, got a TTL of %d", key, ttl)
+			}
+			if !sliding && ttl > 5 {
+				t.Fatalf("%s shouldn't have its TTL refreshed without WithSlidingTTL, got a TTL of %d", key, ttl)
+			}
+		}
+	}
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisTTL(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-ttl-test", 15000, 7, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("EXPIRE", r.filters[3].storage.(*RedisStorage).key, 30)
+
+	ttl, err := r.TTL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("expected the shortest TTL of 30 seconds, got %v", ttl)
+	}
+
+	if err := r.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := r.TTL(); err != nil || ttl >= 0 {
+		t.Fatalf("expected no TTL after Persist, got %v and %v", ttl, err)
+	}
+
+	conn.Do("DEL", r.filters[0].storage.(*RedisStorage).key)
+	if _, err := r.TTL(); err == nil {
+		t.Fatal("expected an error for a missing partition key")
+	}
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisStats(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-stats-test", 14000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"), Value("amma"))
+	r.Save()
+
+	stats, err := r.StatsContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var set uint
+	for _, f := range r.filters {
+		store := f.storage.(*RedisStorage)
+
+		count, err := store.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		set += count
+
+		bytes, err := store.CountRange(0, -1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bits, err := store.CountRange(0, int64(f.partitionBits)-1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes != count || bits != count {
+			t.Fatalf("expected %s to count %d bits over its whole range, got %d and %d", store.key, count, bytes, bits)
+		}
+	}
+	if stats.SetBits != set || set == 0 {
+		t.Fatalf("expected the pipelined counts to add up to %d, got %d", set, stats.SetBits)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisSave(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-save-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Append([]byte("afi"))
+	r.Save()
+
+	exists, err := r.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the Redis backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = r.Exists([]byte("amma"))
+	if exists {
+		t.Fatal("amma shouldn't exist in the Redis backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisClear(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-clear-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Append([]byte("afi"))
+	r.Save()
+	r.Append([]byte("amma"))
+
+	if err := r.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	r.Save()
+
+	for _, value := range []string{"afi", "amma"} {
+		exists, err := r.Exists([]byte(value))
+		if exists {
+			t.Fatalf("%s shouldn't exist in the Redis backend after Clear", value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisExist(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-exist-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"), Value("amma"))
+	r.Save()
+
+	exists, err := r.Exist(Value("afi"), Value("langafi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || exists[1] || !exists[2] {
+		t.Fatalf("expected only afi and amma to exist in the Redis backend, got %v", exists)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisContext(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-context-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r.Append([]byte("afi"))
+	if err := r.SaveContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := r.ExistsContext(ctx, []byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the Redis backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r.Append([]byte("amma"))
+	if err := r.SaveContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from SaveContext, got %v", err)
+	}
+	if _, err := r.ExistContext(cancelled, Value("afi")); err != context.Canceled {
+		t.Fatalf("expected context.Canceled from ExistContext, got %v", err)
+	}
+
+	r.Save()
+	exists, err = r.Exists([]byte("amma"))
+	if !exists {
+		t.Fatal("amma should exist in the Redis backend once saved without the cancelled context")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+// mockConn is a redis.Conn replying with a function instead of talking to a Redis server.
+type mockConn struct {
+	reply func(cmd string, args ...interface{}) (interface{}, error)
+	err   error
+}
+
+func (c *mockConn) Close() error                      { return nil }
+func (c *mockConn) Err() error                        { return nil }
+func (c *mockConn) Send(string, ...interface{}) error { return nil }
+func (c *mockConn) Flush() error                      { return c.err }
+func (c *mockConn) Receive() (interface{}, error)     { return nil, c.err }
+func (c *mockConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+
+	return c.reply(cmd, args...)
+}
+
+// partitionExists reports whether the command checks a partition key exists, which mockConns replying
+// to every command reply to with 0, so the partitions are created instead of having their length checked.
+func partitionExists(cmd string, args []interface{}) bool {
+	return cmd == "EXISTS" && !strings.HasSuffix(args[0].(string), ".meta")
+}
+
+func newMockPool(conn *mockConn) *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+}
+
+// memoryConn is a Conn keeping the bits of its keys in memory, logging the commands it's given.
+type memoryConn struct {
+	bits    map[string]map[int64]bool
+	sent    [][]interface{}
+	replies []interface{}
+	log     []string
+}
+
+func (c *memoryConn) exec(cmd string, args ...interface{}) interface{} {
+	switch cmd {
+	case "EXISTS":
+		_, ok := c.bits[args[0].(string)]
+		return boolInt(ok)
+	case "SETBIT":
+		key, bits := args[0].(string), c.bits[args[0].(string)]
+		if bits == nil {
+			bits = map[int64]bool{}
+			c.bits[key] = bits
+		}
+		bit := bitArg(args[1])
+		old := bits[bit]
+		bits[bit] = bits[bit] || args[2] == 1
+		return boolInt(old)
+	case "GETBIT":
+		return boolInt(c.bits[args[0].(string)][bitArg(args[1])])
+	case "STRLEN":
+		var length int64
+		for bit := range c.bits[args[0].(string)] {
+			if bit/8+1 > length {
+				length = bit/8 + 1
+			}
+		}
+		return length
+	}
+	return "OK"
+}
+
+func (c *memoryConn) Send(cmd string, args ...interface{}) error {
+	c.log = append(c.log, cmd)
+	c.sent = append(c.sent, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *memoryConn) Flush() error {
+	c.log = append(c.log, "FLUSH")
+	for _, command := range c.sent {
+		c.replies = append(c.replies, c.exec(command[0].(string), command[1:]...))
+	}
+	c.sent = nil
+	return nil
+}
+
+func (c *memoryConn) Receive() (interface{}, error) {
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply, nil
+}
+
+func (c *memoryConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.log = append(c.log, cmd)
+	if cmd != "EXEC" {
+		return c.exec(cmd, args...), nil
+	}
+
+	var replies []interface{}
+	for _, command := range c.sent[1:] {
+		replies = append(replies, c.exec(command[0].(string), command[1:]...))
+	}
+	c.sent = nil
+	return replies, nil
+}
+
+func (c *memoryConn) Close() error {
+	return nil
+}
+
+func bitArg(arg interface{}) (bit int64) {
+	fmt.Sscan(fmt.Sprint(arg), &bit)
+	return
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestRedisWithConn(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	factory := func() Conn { return conn }
+
+	s, exists, err := NewRedisStorageWithConn(factory, "redis-conn-test", 100, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("redis-conn-test shouldn't exist yet")
+	}
+
+	s.Append(3)
+	s.Append(42)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.log = nil
+	found, err := s.ExistsMany([]uint{3, 4, 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, []bool{true, false, true}) {
+		t.Fatalf("expected bits 3 and 42 to be set, got %v", found)
+	}
+	if !reflect.DeepEqual(conn.log, []string{"GETBIT", "GETBIT", "GETBIT", "FLUSH"}) {
+		t.Fatalf("expected the GETBITs to be pipelined with a single flush, got %v", conn.log)
+	}
+
+	r, _, err := NewRedisWithConn(factory, "redis-conn-bloom-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := r.Missing(Value("afi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []Value{Value("amma")}) {
+		t.Fatalf("expected only amma to be missing, got %q", missing)
+	}
+}
+
+// memoryRedisConn adapts a memoryConn to a redis.Conn, so a redis.Pool can hand it out.
+type memoryRedisConn struct {
+	*memoryConn
+}
+
+func (c memoryRedisConn) Err() error {
+	return nil
+}
+
+func TestRedisSharded(t *testing.T) {
+	instances := []*memoryConn{{bits: map[string]map[int64]bool{}}, {bits: map[string]map[int64]bool{}}}
+	pools := make([]*redis.Pool, len(instances))
+	for index, instance := range instances {
+		instance := instance
+		pools[index] = &redis.Pool{Dial: func() (redis.Conn, error) { return memoryRedisConn{instance}, nil }}
+	}
+
+	r, exists, err := NewRedisSharded(pools, "redis-sharded-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("redis-sharded-test shouldn't exist yet")
+	}
+
+	r.Add(Value("afi"), Value("amma"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	for multiplier := 1; multiplier <= 7; multiplier++ {
+		key := fmt.Sprintf("redis-sharded-test.%d", multiplier)
+		owner := (multiplier - 1) % len(instances)
+		for index, instance := range instances {
+			if _, ok := instance.bits[key]; ok != (index == owner) {
+				t.Fatalf("expected %s to be stored only by instance %d, instance %d has it: %t", key, owner, index, ok)
+			}
+		}
+	}
+
+	missing, err := r.Missing(Value("afi"), Value("amma"), Value("nanay"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []Value{Value("nanay")}) {
+		t.Fatalf("expected only nanay to be missing, got %q", missing)
+	}
+
+	for _, instance := range instances {
+		instance.log = nil
+	}
+	if exists, err := r.Has(Value("afi")); err != nil || !exists {
+		t.Fatalf("expected afi to exist, got %t, %v", exists, err)
+	}
+	for index, instance := range instances {
+		expected := []string{}
+		for partition := index; partition < 7; partition += len(instances) {
+			expected = append(expected, "GETBIT")
+		}
+		// The pool ends with an empty command when the connection is returned to it.
+		expected = append(expected, "FLUSH", "")
+		if !reflect.DeepEqual(instance.log, expected) {
+			t.Fatalf("expected the GETBITs of instance %d to be pipelined with a single flush, got %v", index, instance.log)
+		}
+	}
+
+	if _, _, err := NewRedisSharded(nil, "redis-sharded-test", 15000, 7, -1); err == nil {
+		t.Fatal("expected an error without pools")
+	}
+	if _, _, err := NewRedisSharded(pools, "redis-sharded-test", 15000, 7, -1, WithSingleKey()); err == nil {
+		t.Fatal("expected an error for a sharded single key filter")
+	}
+	if _, _, err := NewRedisSharded(pools, "redis-sharded-test", 15000, 7, -1, WithPartitions(1)); err == nil {
+		t.Fatal("expected an error for more shards than partitions")
+	}
+}
+
+func TestRedisEqual(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-equal-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Clear()
+
+	b := NewBitset(15000, 7)
+	for _, f := range []*BF{r, b} {
+		f.Add(Value("afi"), Value("amma"))
+		if err := f.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !r.Equal(b) || !b.Equal(r) {
+		t.Fatal("filters with as many bits set by the same values should be equal")
+	}
+
+	b.Add(Value("nanay"))
+	b.Save()
+	if r.Equal(b) {
+		t.Fatal("filters with a different number of bits set shouldn't be equal")
+	}
+}
+
+func TestRedisDump(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, "redis-dump-test", 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Clear()
+
+		r.Add(Value("afi"), Value("amma"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := r.Dump(&buf); err != nil {
+			t.Fatal(err)
+		}
+		dump := buf.Bytes()
+
+		restored, _, err := NewRedis(pool, "redis-dump-restore-test", 15000, 7, 60, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restored.Clear()
+		restored.Add(Value("nanay"))
+		restored.Save()
+
+		if err := restored.Restore(bytes.NewReader(dump)); err != nil {
+			t.Fatal(err)
+		}
+		missing, err := restored.Missing(Value("afi"), Value("amma"), Value("nanay"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(missing, []Value{Value("nanay")}) {
+			t.Fatalf("expected the restored filter to hold only afi and amma, got %q missing", missing)
+		}
+		if !restored.Equal(r) {
+			t.Fatal("the restored filter should have the bits of the dumped one")
+		}
+		ttl, err := restored.TTL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("expected the restored filter to expire within a minute, got %v", ttl)
+		}
+
+		if err := restored.Restore(bytes.NewReader(dump[:len(dump)-1])); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("expected ErrTruncated for a truncated dump, got %v", err)
+		}
+		other, _, err := NewRedis(pool, "redis-dump-other-test", 16000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Clear()
+		if err := other.Restore(bytes.NewReader(dump)); !errors.Is(err, ErrIncompatibleFilter) {
+			t.Fatalf("expected ErrIncompatibleFilter for a dump of another size, got %v", err)
+		}
+	}
+
+	if err := NewBitset(15000, 7).Dump(&bytes.Buffer{}); !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("expected ErrUnsupportedBackend for the Bitset backend, got %v", err)
+	}
+}
+
+func TestRedisExistsStream(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	r, _, err := NewRedisWithConn(func() Conn { return conn }, "redis-stream-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan Value, 10)
+	for i := 0; i < 10; i++ {
+		value := Value(fmt.Sprintf("afi.%d", i))
+		r.Add(value)
+		in <- value
+	}
+	close(in)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.log = nil
+	count := 0
+	for result := range r.ExistsStreamContext(context.Background(), in, 4) {
+		if result.Err != nil || !result.Exists {
+			t.Fatalf("expected %s to exist, got %v", result.Value, result.Err)
+		}
+		count++
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 results, got %d", count)
+	}
+
+	flushes := 0
+	for _, command := range conn.log {
+		if command == "FLUSH" {
+			flushes++
+		}
+	}
+	if flushes != 3 {
+		t.Fatalf("expected a round trip for each of the 3 batches, got %d", flushes)
+	}
+}
+
+func TestRedisHasPipelined(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	r, _, err := NewRedisWithConn(func() Conn { return conn }, "redis-pipelined-test", 15000, 10, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.log = nil
+	exists, err := r.Has([]byte("afi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("afi should exist in the Redis backend")
+	}
+	var expected []string
+	for i := 0; i < 10; i++ {
+		expected = append(expected, "GETBIT")
+	}
+	expected = append(expected, "FLUSH")
+	if !reflect.DeepEqual(conn.log, expected) {
+		t.Fatalf("expected the GETBITs of every partition to be pipelined with a single flush, got %v", conn.log)
+	}
+
+	if exists, _ := r.Has([]byte("amma")); exists {
+		t.Fatal("amma shouldn't exist in the Redis backend")
+	}
+}
+
+func TestRedisWarm(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	r, _, err := NewRedisWithConn(func() Conn { return conn }, "redis-warm-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.log = nil
+	if err := r.Warm(Value("afi"), Value("amma"), Value("langafi")); err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for i := 0; i < 3*7; i++ {
+		expected = append(expected, "GETBIT")
+	}
+	expected = append(expected, "FLUSH")
+	if !reflect.DeepEqual(conn.log, expected) {
+		t.Fatalf("expected the GETBITs of every value and partition to be pipelined with a single flush, got %v", conn.log)
+	}
+
+	conn.log = nil
+	if err := r.Warm(); err != nil || len(conn.log) != 0 {
+		t.Fatalf("expected warming no values to do nothing, got %v, %v", err, conn.log)
+	}
+
+	if err := NewBitset(15000, 7).Warm(Value("afi")); err != nil {
+		t.Fatalf("expected warming a Bitset to do nothing, got %v", err)
+	}
+}
+
+func TestTiered(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	down := false
+	errDown := errors.New("connection refused")
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		if down {
+			return nil, errDown
+		}
+		return memoryRedisConn{conn}, nil
+	}}
+
+	r, exists, err := NewTiered(pool, "tiered-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("tiered-test shouldn't exist yet")
+	}
+	if config := r.Config(); config.Backend != "tiered" {
+		t.Fatalf("expected the tiered backend, got %q", config.Backend)
+	}
+
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.bits["tiered-test.1"]; !ok {
+		t.Fatal("the bits of afi should be saved to Redis")
+	}
+
+	down = true
+	r.Add(Value("amma"))
+	if err := r.Save(); err != nil {
+		t.Fatalf("a Redis failure shouldn't fail Save, got %v", err)
+	}
+	missing, err := r.Missing(Value("afi"), Value("amma"), Value("nanay"))
+	if err != nil {
+		t.Fatalf("a Redis failure shouldn't fail Missing, got %v", err)
+	}
+	if !reflect.DeepEqual(missing, []Value{Value("nanay")}) {
+		t.Fatalf("expected the local Bitset to hold afi and amma, got %q missing", missing)
+	}
+	if !r.Degraded() {
+		t.Fatal("the filter should be degraded while Redis is down")
+	}
+	if err := r.Clear(); !errors.Is(err, errDown) {
+		t.Fatalf("expected Clear to return the Redis failure, got %v", err)
+	}
+
+	down = false
+	r.Add(Value("amma"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Degraded() {
+		t.Fatal("the filter shouldn't be degraded once Redis is back")
+	}
+
+	remote, _, err := NewRedis(pool, "tiered-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = remote.Has([]byte("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("amma should be saved to Redis once it's back")
+	}
+}
+
+func TestRedisClose(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-close-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Clear()
+
+	r.Add(Value("afi"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("closing the filter again should be safe, got %v", err)
+	}
+
+	reopened, _, err := NewRedis(pool, "redis-close-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatalf("the pool should be left open, got %v", err)
+	}
+	exists, err := reopened.Has([]byte("afi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("afi should be saved by Close")
+	}
+}
+
+func TestRedisSetBits(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, "redis-setbits-test", 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Clear()
+
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := r.SetBits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for index, position := range r.BitPositions([]byte("afi")) {
+			if !reflect.DeepEqual(set[index], []uint{position}) {
+				t.Fatalf("expected partition %d to have bit %d set, got %v", index, position, set[index])
+			}
+		}
+	}
+}
+
+func TestRedisBytes(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, "redis-bytes-test", 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Clear()
+
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := r.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for index, position := range r.BitPositions([]byte("afi")) {
+			if len(data[index]) != 268 {
+				t.Fatalf("expected partition %d to take 268 bytes, got %d", index, len(data[index]))
+			}
+			if data[index][position/8] != 0x80>>(position%8) {
+				t.Fatalf("expected partition %d to have bit %d set, got byte %08b", index, position, data[index][position/8])
+			}
+		}
+	}
+}
+
+func TestRedisClone(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-clone-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	r.Save()
+
+	clone, err := r.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("amma"))
+	r.Save()
+
+	exists, err := clone.Exist(Value("afi"), Value("amma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || exists[1] {
+		t.Fatalf("expected only afi to exist in the cloned Redis filter, got %v", exists)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func TestRedisClusterHashTag(t *testing.T) {
+	conn := &mockConn{
+		reply: func(cmd string, args ...interface{}) (interface{}, error) {
+			if partitionExists(cmd, args) {
+				return int64(0), nil
+			}
+			return int64(1), nil
+		},
+	}
+	pool := newMockPool(conn)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-cluster-test", 15000, 7, -1, WithClusterHashTag())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, f := range r.filters {
+		key := f.storage.(*RedisStorage).key
+		if !strings.HasPrefix(key, "{redis-cluster-test}.") {
+			t.Fatalf("partition %d key %q doesn't use the redis-cluster-test hash tag", index, key)
+		}
+		if expected := fmt.Sprintf("{redis-cluster-test}.%d", f.multiplier); key != expected {
+			t.Fatalf("expected partition %d key %q, got %q", index, expected, key)
+		}
+	}
+}
+
+func TestRedisKeyPrefix(t *testing.T) {
+	conn := &memoryConn{bits: map[string]map[int64]bool{}}
+	factory := func() Conn { return conn }
+
+	r, _, err := NewRedisWithConn(factory, "redis-prefix-test", 15000, 7, -1, WithKeyPrefix("app:env:"), WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for key := range conn.bits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var expected []string
+	for multiplier := 1; multiplier <= 7; multiplier++ {
+		expected = append(expected, fmt.Sprintf("app:env:redis-prefix-test.%d", multiplier))
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected the keys %v, got %v", expected, keys)
+	}
+	if config := r.Config(); config.KeyPrefix != "app:env:" {
+		t.Fatalf("expected Config to report the app:env: prefix, got %q", config.KeyPrefix)
+	}
+
+	tagged, _, err := NewRedisWithConn(factory, "redis-prefix-test", 15000, 7, -1, WithKeyPrefix("app:"), WithClusterHashTag())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := tagged.filters[0].storage.(*RedisStorage).key; key != "app:{redis-prefix-test}.1" {
+		t.Fatalf("expected the prefix outside the hash tag, got %q", key)
+	}
+
+	single, _, err := NewRedisWithConn(factory, "redis-prefix-test", 15000, 7, -1, WithKeyPrefix("app:"), WithSingleKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, f := range single.filters {
+		if key := f.storage.(*RedisStorage).key; key != "app:redis-prefix-test" {
+			t.Fatalf("expected partition %d to use the app:redis-prefix-test key, got %q", index, key)
+		}
+	}
+}
+
+func TestRedisWithPartitions(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-partitions-test", 15000, 7, -1, WithPartitions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Clear()
+
+	r.Append([]byte("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := r.Exists([]byte("afi"))
+	if !exists {
+		t.Fatal("afi should exist in the Redis backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for multiplier := 1; multiplier <= 4; multiplier++ {
+		key := fmt.Sprintf("redis-partitions-test.%d", multiplier)
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != (multiplier <= 3) {
+			t.Fatalf("expected %s to exist only for the 3 partitions, got %t", key, exists)
+		}
+	}
+}
+
+func TestRedisSingleKey(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	multi, _, err := NewRedis(pool, "redis-multi-key-test", 15000, 7, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, _, err := NewRedis(pool, "redis-single-key-test", 15000, 7, 60, WithSingleKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]Value, 200)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	for _, r := range []*BF{multi, single} {
+		r.Add(values[:100]...)
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := multi.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := single.Exist(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exists, expected) {
+		t.Fatal("the single key layout should hold the same values as the key per partition one")
+	}
+	if multi.Stats().SetBits != single.Stats().SetBits {
+		t.Fatalf("expected %d bits set in the single key, got %d", multi.Stats().SetBits, single.Stats().SetBits)
+	}
+
+	keys, err := redis.Int(conn.Do("EXISTS", "redis-single-key-test", "redis-single-key-test.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != 1 {
+		t.Fatalf("expected the partitions to share a single key, got %d keys", keys)
+	}
+	length, err := redis.Int(conn.Do("STRLEN", "redis-single-key-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 7*268 {
+		t.Fatalf("expected the key to hold 7 partitions of 268 bytes, got %d bytes", length)
+	}
+	if ttl, err := single.TTL(); err != nil || ttl <= 0 {
+		t.Fatalf("expected the single key to expire, got %v and %v", ttl, err)
+	}
+
+	clone, err := single.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := single.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := single.Exists([]byte("afi.0")); exists {
+		t.Fatal("afi.0 shouldn't exist once the single key is cleared")
+	}
+	if exists, _ := clone.Exists([]byte("afi.0")); !exists {
+		t.Fatal("afi.0 should exist in the clone of the single key")
+	}
+}
+
+func TestRedisAddObserved(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-add-observed-test.%d", len(opts)), 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := r.AddObserved([]byte("afi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if set != 0 {
+			t.Fatalf("expected none of the bits of afi to be set before it's added, got %d", set)
+		}
+		if set, err := r.AddObserved([]byte("afi")); err != nil || set != 7 {
+			t.Fatalf("expected all 7 bits of afi to be set once added, got %d, %v", set, err)
+		}
+		if exists, err := r.Exists([]byte("afi")); err != nil || !exists {
+			t.Fatalf("afi should exist once added, got %v, %v", exists, err)
+		}
+
+		// Setting some of the bits of amma beforehand shows up in the count.
+		for _, f := range r.filters[:3] {
+			a, b := f.hashValue([]byte("amma"))
+			f.storage.Append(f.position(a, b))
+		}
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if set, err := r.AddObserved([]byte("amma")); err != nil || set < 3 || set == 7 {
+			t.Fatalf("expected at least the 3 saved bits of amma to be set, got %d, %v", set, err)
+		}
+	}
+
+	if _, err := NewBitset(15000, 7).AddObserved([]byte("afi")); err != ErrUnsupportedBackend {
+		t.Fatalf("expected ErrUnsupportedBackend for a Bitset, got %v", err)
+	}
+}
+
+func TestRedisCheckAndAdd(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-check-and-add-test.%d", len(opts)), 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range []bool{false, true} {
+			wasPresent, err := r.CheckAndAdd([]byte("afi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wasPresent != expected {
+				t.Fatalf("expected afi to be present %t, got %t", expected, wasPresent)
+			}
+		}
+
+		exists, err := r.Exists([]byte("afi"))
+		if !exists {
+			t.Fatal("afi should exist once checked and added")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		added := make(chan bool, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				wasPresent, err := r.CheckAndAdd([]byte("amma"))
+				if err != nil {
+					t.Error(err)
+				}
+				if !wasPresent {
+					added <- true
+				}
+			}()
+		}
+		wg.Wait()
+		close(added)
+
+		if len(added) != 1 {
+			t.Fatalf("expected amma to be added by a single client, got %d", len(added))
+		}
+	}
+
+	if _, err := NewBitset(15000, 7).CheckAndAdd([]byte("afi")); err != ErrUnsupportedBackend {
+		t.Fatalf("expected ErrUnsupportedBackend for the Bitset backend, got %v", err)
+	}
+}
+
+func TestRedisGetOrAdd(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-get-or-add-test.%d", len(opts)), 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range []bool{false, true} {
+			existed, err := r.GetOrAdd([]byte("afi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if existed != expected {
+				t.Fatalf("expected afi to exist %t, got %t", expected, existed)
+			}
+		}
+
+		exists, err := r.Has([]byte("afi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("afi should exist once added by GetOrAdd")
+		}
+
+		var wg sync.WaitGroup
+		added := make(chan bool, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				existed, err := r.GetOrAdd([]byte("amma"))
+				if err != nil {
+					t.Error(err)
+				}
+				if !existed {
+					added <- true
+				}
+			}()
+		}
+		wg.Wait()
+		close(added)
+
+		if len(added) != 1 {
+			t.Fatalf("expected amma to be added by a single client, got %d", len(added))
+		}
+	}
+}
+
+func TestRedisSizeMismatch(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		key := fmt.Sprintf("redis-size-mismatch-test.%d", len(opts))
+		if _, _, err := NewRedis(pool, key, 1000, 1, -1, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := NewRedis(pool, key, 1000, 1, -1, opts...); err != nil {
+			t.Fatalf("expected the key of the same size to be opened, got %v", err)
+		}
+
+		if _, _, err := NewRedis(pool, key, 15000, 1, -1, opts...); !errors.Is(err, ErrSizeMismatch) {
+			t.Fatalf("expected ErrSizeMismatch for a key created with a smaller size, got %v", err)
+		}
+	}
+
+	if _, err := conn.Do("SET", "redis-size-mismatch-test.0.1", "afi"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRedis(pool, "redis-size-mismatch-test.0"); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected OpenRedis to return ErrSizeMismatch for a truncated key, got %v", err)
+	}
+}
+
+func TestRedisMissing(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	r, _, err := NewRedis(pool, "redis-missing-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	r.Save()
+
+	missing, err := r.Missing(Value("amma"), Value("afi"), Value("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []Value{Value("amma"), Value("foo")}) {
+		t.Fatalf("expected amma and foo to be missing from the Redis backend, got %q", missing)
+	}
+}
+
+func TestRedisIsEmpty(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-is-empty-test.%d", len(opts)), 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		empty, err := r.IsEmpty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !empty {
+			t.Fatal("a new Redis filter should be empty")
+		}
+
+		// Only set a bit of the last partition, at its last bit.
+		last := r.filters[6]
+		last.storage.Append(last.partitionBits - 1)
+		r.Save()
+
+		empty, err = r.IsEmpty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if empty {
+			t.Fatal("a Redis filter with a bit set shouldn't be empty")
+		}
+	}
+}
+
+func TestRedisSaveErrors(t *testing.T) {
+	errFlush := errors.New("connection reset")
+
+	var execReply interface{}
+	conn := &mockConn{
+		reply: func(cmd string, args ...interface{}) (interface{}, error) {
+			switch {
+			case partitionExists(cmd, args):
+				return int64(0), nil
+			case cmd == "EXISTS":
+				return int64(1), nil
+			case cmd == "EXEC":
+				if execReply == nil {
+					return nil, errFlush
+				}
+				return execReply, nil
+			}
+			return nil, nil
+		},
+	}
+	pool := newMockPool(conn)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-save-errors-test", 15000, 2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Append([]byte("afi"))
+	if err := r.Save(); !errors.Is(err, errFlush) {
+		t.Fatalf("expected the flush error to propagate from Save, got %v", err)
+	}
+	for _, f := range r.filters {
+		if len(f.storage.(*RedisStorage).queue) != 1 {
+			t.Fatal("the queue should be kept when saving fails")
+		}
+	}
+
+	errWrongType := redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	execReply = []interface{}{errWrongType}
+	if err := r.Save(); !errors.Is(err, errWrongType) {
+		t.Fatalf("expected the SETBIT error to propagate from Save, got %v", err)
+	}
+
+	execReply = []interface{}{int64(0)}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.filters {
+		if len(f.storage.(*RedisStorage).queue) != 0 {
+			t.Fatal("the queue should be emptied once saved")
+		}
+	}
+}
+
+func TestRedisSaveConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	conn := &mockConn{
+		reply: func(cmd string, args ...interface{}) (interface{}, error) {
+			if partitionExists(cmd, args) {
+				return int64(0), nil
+			}
+			if cmd != "EXEC" {
+				return int64(1), nil
+			}
+
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return []interface{}{int64(0)}, nil
+		},
+	}
+
+	// The last pool has fewer connections than concurrent saves, which then wait for each other.
+	for _, limits := range [][3]int{{0, defaultSaveConcurrency, 0}, {3, 3, 0}, {1, 1, 0}, {0, 2, 2}} {
+		pool := newMockPool(conn)
+		pool.MaxActive = limits[2]
+		pool.Wait = true
+
+		r, _, err := NewRedis(pool, "redis-save-concurrency-test", 30000, 30, -1, WithSaveConcurrency(limits[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peak = 0
+		r.Append([]byte("afi"))
+
+		saved := make(chan error, 1)
+		go func() { saved <- r.Save() }()
+
+		select {
+		case err := <-saved:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("saving with a concurrency of %d deadlocked on a pool of %d connections", limits[0], limits[2])
+		}
+
+		if peak > limits[1] {
+			t.Fatalf("expected at most %d concurrent partition saves, got %d", limits[1], peak)
+		}
+		pool.Close()
+	}
+}
+
+func TestRedisInvalidParameters(t *testing.T) {
+	for _, params := range [][2]uint{{0, 7}, {15000, 0}, {0, 0}} {
+		if _, _, err := NewRedis(nil, "redis-invalid-test", params[0], params[1], -1); !errors.Is(err, ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters for size %d and hash iterations %d, got %v", params[0], params[1], err)
+		}
+	}
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrInvalidParameters) {
+			t.Fatalf("expected MustNewRedis to panic with ErrInvalidParameters, got %v", err)
+		}
+	}()
+	MustNewRedis(nil, "redis-invalid-test", 0, 7, -1)
+}
+
+func BenchmarkRedisQueueAppend(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-queue-append-benchmark", 15000, 7, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		r.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func BenchmarkRedisSave(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-save-benchmark", 15000, 7, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		r.Append([]byte(fmt.Sprintf("afi.%d", i)))
+	}
+	r.Save()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+// BenchmarkRedisSaveQueue compares saving a queue of 10k bits in a MULTI/EXEC transaction of the
+// deduplicated bits like Save, with the previous Save pipelining a SETBIT per queued bit.
+func BenchmarkRedisSaveQueue(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	store, _, err := NewRedisStorage(pool, "redis-save-queue-benchmark", 15000, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("PerBit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			conn := pool.Get()
+			for bit := uint(0); bit < 10000; bit++ {
+				conn.Send("SETBIT", store.key, (bit*7919)%15000, 1)
+			}
+			conn.Flush()
+			for bit := 0; bit < 10000; bit++ {
+				conn.Receive()
+			}
+			conn.Close()
+		}
+	})
+
+	b.Run("Transaction", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for bit := uint(0); bit < 10000; bit++ {
+				store.Append((bit * 7919) % 15000)
+			}
+			store.Save()
+		}
+	})
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func BenchmarkRedisExists(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-exists-benchmark", 15000, 7, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	r.Append([]byte("afi.7500"))
+	r.Save()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+
+	for i := 0; i < b.N; i++ {
+		r.Exists([]byte("afi.7500"))
+	}
+
+	conn.Do("FLUSHALL")
+}
+
+func BenchmarkRedisExist(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-exist-benchmark", 15000, 7, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	values := make([]Value, 1000)
+	for i := range values {
+		values[i] = Value(fmt.Sprintf("afi.%d", i))
+	}
+	r.Add(values...)
+	r.Save()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Exist(values...)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+func BenchmarkRedisHas(b *testing.B) {
+	pool := newRedisPool(7)
+	defer pool.Close()
+
+	r, _, err := NewRedis(pool, "redis-has-benchmark", 15000, 10, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	r.Add(Value("afi"))
+	r.Save()
+
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r.Has([]byte("afi"))
+		}
+	})
+	b.Run("per-partition", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for index, position := range r.BitPositions([]byte("afi")) {
+				r.filters[index].storage.Exists(position)
+			}
+		}
+	})
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Do("FLUSHALL")
+}
+
+// BenchmarkRedisWorkload is BenchmarkBitsetWorkload with Redis backed filters, adding and checking fewer
+// keys since every check takes a round trip.
+func BenchmarkRedisWorkload(b *testing.B) {
+	pool := newRedisPool(10)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	keys, absent := GenerateKeys(10000, 1), GenerateKeys(1000, 2)
+	for _, config := range workloadConfigs {
+		name := fmt.Sprintf("m=%d/k=%d", config.size, config.hashIter)
+		key := "redis-workload-benchmark." + name
+
+		b.Run(name+"/add", func(b *testing.B) {
+			conn.Do("FLUSHALL")
+			r, _, err := NewRedis(pool, key, config.size, config.hashIter, -1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Add(keys[i%len(keys)])
+				if i%1000 == 999 {
+					r.Save()
+				}
+			}
+			r.Save()
+		})
+
+		b.Run(name+"/exists", func(b *testing.B) {
+			conn.Do("FLUSHALL")
+			r, _, err := NewRedis(pool, key, config.size, config.hashIter, -1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			r.Add(keys...)
+			if err := r.Save(); err != nil {
+				b.Fatal(err)
+			}
+			rate := workloadFalsePositives(r, absent)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Exists(keys[i%len(keys)])
+			}
+			b.ReportMetric(rate, "fp_rate")
+		})
+	}
+}
+
+// flakyConn is a memoryConn failing its next failures round trips with err, dropping what was sent.
+type flakyConn struct {
+	*memoryConn
+	failures int
+	err      error
+}
+
+func (c *flakyConn) fail() bool {
+	if c.failures <= 0 {
+		return false
+	}
+	c.failures--
+	c.sent = nil
+	return true
+}
+
+func (c *flakyConn) Flush() error {
+	if c.fail() {
+		return c.err
+	}
+	return c.memoryConn.Flush()
+}
+
+func (c *flakyConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.fail() {
+		return nil, c.err
+	}
+	return c.memoryConn.Do(cmd, args...)
+}
+
+func TestRedisRetry(t *testing.T) {
+	conn := &flakyConn{&memoryConn{bits: map[string]map[int64]bool{}}, 0, nil}
+	factory := func() Conn { return conn }
+
+	r, _, err := NewRedisWithConn(factory, "redis-retry-test", 15000, 7, -1, WithRetry(3, time.Millisecond), WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.failures, conn.err = 2, io.ErrUnexpectedEOF
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatalf("expected Save to succeed on the third attempt, got %v", err)
+	}
+	conn.failures, conn.err = 2, redis.Error("LOADING Redis is loading the dataset in memory")
+	exists, err := r.Exists(Value("afi"))
+	if err != nil {
+		t.Fatalf("expected Exists to succeed on the third attempt, got %v", err)
+	}
+	if !exists {
+		t.Fatal("afi should exist once saved with retries")
+	}
+
+	conn.failures, conn.err = 3, io.ErrUnexpectedEOF
+	if _, err := r.Exists(Value("afi")); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the error once the attempts are exhausted, got %v", err)
+	}
+
+	conn.failures, conn.err = 1, redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	if _, err := r.Exists(Value("afi")); err != conn.err {
+		t.Fatalf("expected WRONGTYPE not to be retried, got %v", err)
+	}
+	conn.failures = 0
+
+	plain, _, err := NewRedisWithConn(factory, "redis-retry-test", 15000, 7, -1, WithSaveConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.failures, conn.err = 1, io.ErrUnexpectedEOF
+	if _, err := plain.Exists(Value("afi")); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected operations not to be retried by default, got %v", err)
+	}
+}
+
+func TestRedisDiscard(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, opts := range [][]Option{nil, {WithSingleKey()}} {
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-discard-test.%d", len(opts)), 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Add(Value("afi"), Value("amma"))
+		r.Discard()
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := r.StatsContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.SetBits != 0 {
+			t.Fatalf("expected discarded values not to be saved, got %d bits set", stats.SetBits)
+		}
+
+		r.Add(Value("langafi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := r.Exists([]byte("langafi")); err != nil || !exists {
+			t.Fatalf("values added after Discard should be saved, got %v, %v", exists, err)
+		}
+		if exists, _ := r.Exists([]byte("afi")); exists {
+			t.Fatal("afi shouldn't exist once discarded")
+		}
+	}
+}
+
+func TestRedisDestroy(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, test := range []struct {
+		opts []Option
+		keys []string
+	}{
+		{[]Option{WithPartitions(3)}, []string{"redis-destroy-test.1", "redis-destroy-test.2", "redis-destroy-test.3", "redis-destroy-test.meta"}},
+		{[]Option{WithSingleKey(), WithKeyPrefix("app:")}, []string{"app:redis-destroy-test", "app:redis-destroy-test.meta"}},
+	} {
+		conn.Do("FLUSHALL")
+
+		r, _, err := NewRedis(pool, "redis-destroy-test", 15000, 7, 60, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range test.keys {
+			if exists, _ := redis.Bool(conn.Do("EXISTS", key)); !exists {
+				t.Fatalf("expected key %s to exist before Destroy", key)
+			}
+		}
+
+		r.Add(Value("amma"))
+		if err := r.Destroy(); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range test.keys {
+			if exists, _ := redis.Bool(conn.Do("EXISTS", key)); exists {
+				t.Fatalf("expected key %s to be deleted by Destroy", key)
+			}
+		}
+
+		if _, err := r.Exists([]byte("afi")); err != ErrDestroyed {
+			t.Fatalf("expected ErrDestroyed from Exists, got %v", err)
+		}
+		r.Add(Value("afi"))
+		if err := r.Save(); !errors.Is(err, ErrDestroyed) {
+			t.Fatalf("expected ErrDestroyed from Save, got %v", err)
+		}
+		if err := r.Destroy(); err != ErrDestroyed {
+			t.Fatalf("expected ErrDestroyed from Destroy, got %v", err)
+		}
+		if _, err := OpenRedis(pool, "redis-destroy-test", test.opts[1:]...); err != ErrMetadataNotFound {
+			t.Fatalf("expected the metadata to be deleted, got %v", err)
+		}
+	}
+
+	if err := NewBitset(15000, 7).Destroy(); err != ErrUnsupportedBackend {
+		t.Fatalf("expected ErrUnsupportedBackend for a Bitset, got %v", err)
+	}
+}
+
+func TestRedisConcurrentAddSave(t *testing.T) {
+	pool := newRedisPool(10)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	r, _, err := NewRedis(pool, "redis-concurrent-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var adders sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		adders.Add(1)
+		go func(i int) {
+			defer adders.Done()
+			for j := 0; j < 100; j++ {
+				r.Add(Value(fmt.Sprintf("afi.%d.%d", i, j)))
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	saved := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-done:
+				saved <- nil
+				return
+			default:
+			}
+			if err := r.Save(); err != nil {
+				saved <- err
+				return
+			}
+		}
+	}()
+
+	adders.Wait()
+	close(done)
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 100; j++ {
+			value := fmt.Sprintf("afi.%d.%d", i, j)
+			if exists, err := r.Exists([]byte(value)); err != nil || !exists {
+				t.Fatalf("expected %s to be saved, got %v, %v", value, exists, err)
+			}
+		}
+	}
+}
+
+func TestRedisTimeout(t *testing.T) {
+	// The slow server reads the commands without ever replying.
+	slow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	go func() {
+		for {
+			c, err := slow.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+
+	var stalled int32
+	pool := newRedisPool(0)
+	dial := pool.Dial
+	pool.Dial = func() (redis.Conn, error) {
+		if atomic.LoadInt32(&stalled) != 0 {
+			return redis.Dial("tcp", slow.Addr().String())
+		}
+		return dial()
+	}
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	r, _, err := NewRedis(pool, "redis-timeout-test", 15000, 7, 60, WithRedisTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(Value("afi"))
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&stalled, 1)
+	var netErr net.Error
+	for name, op := range map[string]func() error{
+		"Exists": func() error {
+			_, err := r.Exists([]byte("afi"))
+			return err
+		},
+		"Save": func() error {
+			r.Add(Value("amma"))
+			return r.Save()
+		},
+		"Count": func() error {
+			_, err := r.EstimatedItemCount()
+			return err
+		},
+		"NewRedis": func() error {
+			_, _, err := NewRedis(pool, "redis-timeout-test", 15000, 7, 60, WithRedisTimeout(50*time.Millisecond))
+			return err
+		},
+	} {
+		start := time.Now()
+		err := op()
+		if failed, ok := err.(SaveError); ok {
+			err = failed[0]
+		}
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("expected %s to time out, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected %s to time out after 50ms, took %v", name, elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.ExistsContext(ctx, []byte("afi")); err == nil {
+		t.Fatal("expected ExistsContext to time out")
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("expected the shorter context deadline to apply, took %v", elapsed)
+	}
+
+	atomic.StoreInt32(&stalled, 0)
+	if exists, err := r.Exists([]byte("afi")); err != nil || !exists {
+		t.Fatalf("afi should exist once Redis replies again, got %v, %v", exists, err)
+	}
+}
+
+func TestNewSpec(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	size, hashIter := estimateParameters(1000, 0.01)
+	size = NewBitset(size, hashIter).Config().Size
+	for _, test := range []struct {
+		spec     Spec
+		backend  string
+		size     uint
+		hashIter uint
+	}{
+		{Spec{N: 1000, P: 0.01}, "bitset", size, hashIter},
+		{Spec{Backend: "bitset", Size: 15000, HashIter: 7, Concurrent: true, Seed: 42}, "bitset", 15001, 7},
+		{Spec{N: 1000, P: 0.01, Pool: pool, Key: "redis-spec-test", TTL: time.Minute}, "redis", size, hashIter},
+		{Spec{Backend: "redis", Size: 15000, HashIter: 7, Pool: pool, Key: "redis-spec-test.explicit", Options: []Option{WithPartitions(3)}}, "redis", 15000, 7},
+	} {
+		b, err := New(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := b.Config()
+		if config.Backend != test.backend || config.Size != test.size || config.HashIter != test.hashIter {
+			t.Fatalf("expected a %s filter of %d bits and %d hash iterations for %+v, got %+v", test.backend, test.size, test.hashIter, test.spec, config)
+		}
+
+		b.Add(Value("afi"))
+		if err := b.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := b.Exists([]byte("afi")); err != nil || !exists {
+			t.Fatalf("afi should exist in the %s filter, got %v, %v", test.backend, exists, err)
+		}
+	}
+
+	if ttl, err := redis.Int64(conn.Do("PTTL", "redis-spec-test.1")); err != nil || ttl <= 0 {
+		t.Fatalf("expected the TTL to expire the keys, got %d, %v", ttl, err)
+	}
+	seeded, _ := New(Spec{Size: 15000, HashIter: 7, Seed: 42})
+	if plain, _ := New(Spec{Size: 15000, HashIter: 7}); seeded.Fingerprint() == plain.Fingerprint() {
+		t.Fatal("expected the seed to be applied")
+	}
+
+	for _, test := range []struct {
+		spec  Spec
+		field string
+	}{
+		{Spec{Backend: "memcached", N: 1000, P: 0.01}, "Backend"},
+		{Spec{}, "N"},
+		{Spec{N: 1000, P: 0.01, Size: 15000}, "Size"},
+		{Spec{P: 0.01}, "N"},
+		{Spec{N: 1000}, "P"},
+		{Spec{N: 1000, P: 1}, "P"},
+		{Spec{HashIter: 7}, "Size"},
+		{Spec{Size: 15000}, "HashIter"},
+		{Spec{N: 1000, P: 0.01, Key: "redis-spec-test"}, "Key"},
+		{Spec{N: 1000, P: 0.01, TTL: time.Minute}, "TTL"},
+		{Spec{Backend: "redis", N: 1000, P: 0.01, Key: "redis-spec-test"}, "Pool"},
+		{Spec{N: 1000, P: 0.01, Pool: pool}, "Key"},
+		{Spec{N: 1000, P: 0.01, Pool: pool, Key: "redis-spec-test", TTL: 1500 * time.Millisecond}, "TTL"},
+	} {
+		_, err := New(test.spec)
+		var specErr *SpecError
+		if !errors.As(err, &specErr) || specErr.Field != test.field {
+			t.Fatalf("expected an error on field %s for %+v, got %v", test.field, test.spec, err)
+		}
+	}
+	if _, err := New(Spec{N: 1000}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected sizing errors to wrap ErrInvalidParameters, got %v", err)
+	}
+}
+
+func TestOpenRedis(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	if _, err := OpenRedis(pool, "redis-open-test"); err != ErrMetadataNotFound {
+		t.Fatalf("expected ErrMetadataNotFound before the filter is created, got %v", err)
+	}
+
+	for _, opts := range [][]Option{
+		{WithSeed(42), WithPartitions(3), WithExactSize(), WithHashMixing()},
+		{WithEnhancedDoubleHashing(), WithSingleKey(), WithKeyPrefix("app:")},
+		{WithHashExtraction(binary.LittleEndian, 2, 4)},
+	} {
+		conn.Do("FLUSHALL")
+
+		r, _, err := NewRedis(pool, "redis-open-test", 15001, 7, 60, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		var open []Option
+		if newOptions(opts).keyPrefix != "" {
+			open = append(open, WithKeyPrefix("app:"))
+		}
+		opened, err := OpenRedis(pool, "redis-open-test", open...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened.Fingerprint() != r.Fingerprint() || opened.Config() != r.Config() {
+			t.Fatalf("expected the opened filter to match %+v, got %+v", r.Config(), opened.Config())
+		}
+		if exists, err := opened.Exists([]byte("afi")); err != nil || !exists {
+			t.Fatalf("afi should exist in the opened filter, got %v, %v", exists, err)
+		}
+		if ttl, err := opened.TTL(); err != nil || ttl <= 0 {
+			t.Fatalf("expected the opened filter to keep expiring, got %v, %v", ttl, err)
+		}
+	}
+
+	conn.Do("FLUSHALL")
+	if _, _, err := NewRedis(pool, "redis-open-test", 15000, 7, -1, WithHasher(fnv.New64a)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRedis(pool, "redis-open-test"); err != ErrIncompatibleFilter {
+		t.Fatalf("expected ErrIncompatibleFilter when opened with another hasher, got %v", err)
+	}
+	if _, err := OpenRedis(pool, "redis-open-test", WithHasher(fnv.New64a)); err != nil {
+		t.Fatalf("expected the filter to open with its hasher, got %v", err)
+	}
+}
+
+func TestRedisBatchSaver(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	dials := 0
+	batchPool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		dials++
+		return pool.Dial()
+	}}
+	defer batchPool.Close()
+
+	var filters []*BF
+	for i := 0; i < 20; i++ {
+		var opts []Option
+		if i%2 == 1 {
+			opts = append(opts, WithSingleKey())
+		}
+		r, _, err := NewRedis(pool, fmt.Sprintf("redis-batch-test-%d", i), 1000, 3, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, r)
+	}
+
+	saver := NewBatchSaver(batchPool)
+	for i, r := range filters {
+		r.AddString(fmt.Sprintf("tenant-%d", i))
+		saver.Add(r)
+	}
+	saver.Add(filters[0])
+	if err := saver.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 {
+		t.Fatalf("expected the filters to be saved over a single connection, got %d", dials)
+	}
+
+	for i, r := range filters {
+		exists, err := r.ExistsString(fmt.Sprintf("tenant-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("tenant-%d should exist once flushed", i)
+		}
+	}
+
+	saver.Add(filters[0])
+	if err := saver.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 {
+		t.Fatalf("expected nothing to be sent once the queues are empty, got %d connections", dials)
+	}
+}
+
+func TestRedisBatchSaverSaveError(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	r, _, err := NewRedis(pool, "redis-batch-error-test", 1000, 3, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := NewBitset(1000, 3)
+	failing.filters[1].storage = failingStorage{failing.filters[1].storage.(*BitsetStorage)}
+
+	saver := NewBatchSaver(pool)
+	r.AddString("tenant")
+	failing.AddString("tenant")
+	saver.Add(r)
+	saver.Add(failing)
+	if err := saver.Flush(); err != errFailingStorage {
+		t.Fatalf("expected the error of the failing partition, got %v", err)
+	}
+	for index, f := range r.filters {
+		if queue := f.storage.(*RedisStorage).queue; len(queue) != 1 {
+			t.Fatalf("expected the bit of partition %d to stay queued, got %v", index, queue)
+		}
+	}
+
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := r.ExistsString("tenant"); err != nil || !exists {
+		t.Fatalf("tenant should exist once saved after the failed Flush, got %t, %v", exists, err)
+	}
+}
+
+// loggingConn is a redis.Conn logging the commands it's given.
+type loggingConn struct {
+	redis.Conn
+	log *[]string
+}
+
+func (c loggingConn) Send(cmd string, args ...interface{}) error {
+	*c.log = append(*c.log, cmd)
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c loggingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	*c.log = append(*c.log, cmd)
+	return c.Conn.Do(cmd, args...)
+}
+
+func TestRedisContextWithoutTimeouts(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	// A loggingConn only implements redis.Conn, so it can't read replies with a timeout.
+	var log []string
+	factory := func() Conn {
+		return loggingConn{pool.Get(), &log}
+	}
+	r, _, err := NewRedisWithConn(factory, "redis-no-timeout-test", 15000, 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r.Add(Value("afi"))
+	if err := r.SaveContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := r.ExistsContext(ctx, []byte("afi")); err != nil || !exists {
+		t.Fatalf("afi should exist when checked with a deadline, got %t, %v", exists, err)
+	}
+	if _, err := r.StatsContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisReadConn(t *testing.T) {
+	pool := newRedisPool(5)
+	defer pool.Close()
+
+	conn := pool.Get()
+	defer conn.Close()
+	defer conn.Do("FLUSHALL")
+
+	for _, cluster := range []bool{false, true} {
+		var log []string
+		replica := func() Conn {
+			return loggingConn{pool.Get(), &log}
+		}
+
+		opts := []Option{WithReadConn(replica)}
+		if cluster {
+			opts = append(opts, WithClusterHashTag())
+		}
+		r, _, err := NewRedis(pool, "redis-read-test", 15000, 7, -1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Add(Value("afi"))
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if len(log) != 0 {
+			t.Fatalf("expected the filter to be saved on the primary, got %v on the replica", log)
+		}
+
+		exists, err := r.Exists([]byte("afi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("afi should exist on the replica")
+		}
+		if found, err := r.Exist(Value("afi"), Value("amma")); err != nil || !reflect.DeepEqual(found, []bool{true, false}) {
+			t.Fatalf("expected only afi to exist on the replica, got %v, %v", found, err)
+		}
+
+		readOnly := 0
+		for _, cmd := range log {
+			switch cmd {
+			case "READONLY":
+				readOnly++
+			case "GETBIT":
+			default:
+				t.Fatalf("expected only GETBIT and READONLY on the replica, got %s", cmd)
+			}
+		}
+		if cluster != (readOnly > 0) {
+			t.Fatalf("expected READONLY to be sent on the replica only for clusters, got %v", log)
+		}
+	}
+
+	if _, _, err := NewRedisSharded([]*redis.Pool{pool, pool}, "redis-read-test", 15000, 7, -1, WithReadConn(func() Conn { return pool.Get() })); err == nil {
+		t.Fatal("expected sharded filters not to support WithReadConn")
+	}
+}
@@ -0,0 +1,57 @@
+package bloom
+
+import "encoding/binary"
+
+// Rehash builds a new Bitset backed bloom filter with the given size and hash iterations, keeping the
+// hasher, seed, hashing scheme, hash extraction, number of partitions, save concurrency, capacity
+// threshold and Observer of this one, and fills it with the values passed to yield by reinsert.
+// Partitions of different sizes are taken as WithExactSize, so it's kept unless the size of this filter
+// is a multiple of its partitions. The new filter is concurrent if this one uses a concurrent Bitset
+// backend.
+//
+// A bloom filter only stores bits, so the values it holds can't be recovered from it: reinsert has to
+// yield the original values, typically by reading them from where they're stored. The new filter is
+// saved once reinsert returns, while this filter is left untouched.
+func (b *BF) Rehash(newSize, newHashIter uint, reinsert func(yield func(Value))) (*BF, error) {
+	opts := []Option{WithObserver(b.observer), WithSaveConcurrency(b.saves)}
+	if b.capacity != nil {
+		opts = append(opts, WithCapacityThreshold(b.capacity.ratio, b.capacity.callback))
+	}
+	if len(b.filters) > 0 {
+		f := b.filters[0]
+		opts = append(opts, WithHasher(f.hasher), WithSeed(f.seed))
+		switch f.scheme {
+		case enhancedDoubleHashing:
+			opts = append(opts, WithEnhancedDoubleHashing())
+		case mixedHashing:
+			opts = append(opts, WithHashMixing())
+		}
+		if e := f.extraction; e != defaultExtraction {
+			var order binary.ByteOrder = binary.BigEndian
+			if e.littleEndian {
+				order = binary.LittleEndian
+			}
+			opts = append(opts, WithHashExtraction(order, int(e.a), int(e.b)))
+		}
+		if partitions := len(b.partitions()); partitions != len(b.filters) {
+			opts = append(opts, WithPartitions(uint(partitions)))
+		}
+		if last := b.partitions()[len(b.partitions())-1]; last.partitionBits != f.partitionBits {
+			opts = append(opts, WithExactSize())
+		}
+		if store, ok := f.storage.(*BitsetStorage); ok && store.mu != nil {
+			opts = append(opts, WithConcurrency())
+		}
+	}
+
+	rehashed, err := NewBitsetE(newSize, newHashIter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	reinsert(func(value Value) {
+		rehashed.Add(value)
+	})
+
+	return rehashed, rehashed.Save()
+}
@@ -0,0 +1,71 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// retryPolicy is how many times the Redis backend tries an operation, and how long it waits before the
+// first retry, the wait doubling after every retry.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// do runs op, running it again while it fails with a retryable error and attempts are left. The last
+// error is returned once the attempts are exhausted, or as soon as the context is done.
+func (p retryPolicy) do(ctx context.Context, op func() error) error {
+	wait := p.backoff
+	err := op()
+	for attempt := 1; attempt < p.attempts && retryable(err); attempt++ {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		wait *= 2
+		err = op()
+	}
+
+	return err
+}
+
+// retryableReplies are the prefixes of the errors Redis replies with while it can't serve a command for
+// now, during startup, a failover or a cluster reconfiguration.
+var retryableReplies = []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "READONLY"}
+
+// retryable reports whether the error is transient: a network error, a connection closed or reset, an
+// exhausted pool, or one of the retryableReplies. Other Redis errors, like WRONGTYPE, would fail again.
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		for _, prefix := range retryableReplies {
+			if strings.HasPrefix(string(replyErr), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, redis.ErrPoolExhausted)
+}
@@ -0,0 +1,85 @@
+package bloom
+
+import "math"
+
+// scalableTightening is the ratio the false positive probability shrinks by for every new filter.
+const scalableTightening = 0.85
+
+// scalableFillRatio is the fill ratio of the active filter past which a new filter is added to the chain.
+// A filter estimated for its capacity with the optimal hash iterations is half full once it holds it.
+const scalableFillRatio = 0.5
+
+// ScalableBF is a bloom filter that grows as values are added, by chaining bloom filters with growing
+// capacities and tightening false positive probabilities (Almeida et al., Scalable Bloom Filters).
+// The overall false positive probability stays below the one requested, however many values are added.
+type ScalableBF struct {
+	filters  []*BF
+	capacity uint
+	p        float64
+	growth   float64
+	opts     []Option
+}
+
+// NewScalableBitset creates and returns a new scalable bloom filter using Bitset as a backend. The first
+// filter holds initialN values, and every new filter holds growth times more values than the previous
+// one. The overall false positive probability is kept below p. An initialN of 0 holds a single value, since
+// the capacities would otherwise never grow. The filters are created WithHashMixing unless the options set
+// another scheme, since the filters grow from their fill ratio, which only bounds the false positive
+// probability when the bits of a value are spread out.
+func NewScalableBitset(initialN uint, p float64, growth float64, opts ...Option) *ScalableBF {
+	if growth < 1 {
+		growth = 1
+	}
+	if initialN == 0 {
+		initialN = 1
+	}
+
+	s := &ScalableBF{
+		capacity: initialN,
+		p:        p * (1 - scalableTightening),
+		growth:   growth,
+		opts:     append([]Option{WithHashMixing()}, opts...),
+	}
+	s.grow()
+
+	return s
+}
+
+// grow adds a new active filter to the chain.
+func (s *ScalableBF) grow() {
+	if len(s.filters) > 0 {
+		s.filters[len(s.filters)-1].Save()
+		s.capacity = uint(math.Ceil(float64(s.capacity) * s.growth))
+		s.p *= scalableTightening
+	}
+
+	s.filters = append(s.filters, NewBitsetWithEstimate(s.capacity, s.p, s.opts...))
+}
+
+// Add adds and saves the given values. A new filter is added to the chain once the fill ratio of the
+// active filter reaches scalableFillRatio, which is when it holds about its capacity. Values already in
+// the active filter set no new bits, so adding them again doesn't grow the chain.
+func (s *ScalableBF) Add(values ...Value) {
+	for _, value := range values {
+		active := s.filters[len(s.filters)-1]
+		if active.Stats().FillRatio >= scalableFillRatio {
+			s.grow()
+			active = s.filters[len(s.filters)-1]
+		}
+
+		active.Add(value)
+		active.Save()
+	}
+}
+
+// Exists checks if the given value is in any of the chained bloom filters. False positives might occur.
+func (s *ScalableBF) Exists(value []byte) (bool, error) {
+	for _, f := range s.filters {
+		exists, err := f.Has(value)
+		if exists || err != nil {
+			return exists, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,104 @@
+package bloom
+
+import "context"
+
+// SetBits returns the bits set in every partition filter, in increasing order, which is meant for
+// debugging and testing small filters: Add sets the bits returned by BitPositions, which can be checked
+// with it. It isn't meant for large filters, whose set bits take a lot of memory. The Bitset backend scans
+// its words, the Redis backend reads every partition with a single GET like Dump, and other backends
+// check every bit of the partition.
+func (b *BF) SetBits() ([][]uint, error) {
+	partitions := b.partitions()
+	bits := make([][]uint, len(partitions))
+	for index, f := range partitions {
+		set, err := setBits(f)
+		if err != nil {
+			return nil, &PartitionError{index, err}
+		}
+		bits[index] = set
+	}
+
+	return bits, nil
+}
+
+// setBits returns the bits set in the storage of the partition filter.
+func setBits(f filter) ([]uint, error) {
+	set := []uint{}
+	switch s := f.storage.(type) {
+	case *BitsetStorage:
+		s.rlock()
+		defer s.runlock()
+
+		for bit, ok := s.store.NextSet(0); ok; bit, ok = s.store.NextSet(bit + 1) {
+			set = append(set, bit)
+		}
+	case dumper:
+		data, err := s.dump(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for bit := uint(0); bit < f.partitionBits; bit++ {
+			if data[bit/8]&(0x80>>(bit%8)) != 0 {
+				set = append(set, bit)
+			}
+		}
+	default:
+		all := make([]uint, f.partitionBits)
+		for bit := range all {
+			all[bit] = uint(bit)
+		}
+		exists, err := s.ExistsMany(all)
+		if err != nil {
+			return nil, err
+		}
+		for bit, ok := range exists {
+			if ok {
+				set = append(set, uint(bit))
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// Bytes returns the bits of every partition filter packed into bytes, for checking values outside of the
+// package, like in another language or on a GPU. Bit i of a partition is the most significant bit first
+// within byte i/8, so it's set when bytes[i/8]&(0x80>>(i%8)) isn't zero; this is the order of the Redis
+// GETBIT, SETBIT and GET commands. Each partition takes (partitionBits+7)/8 bytes, and the bits past the
+// end of the partition in the last byte are zero. A value exists when, for every hash iteration of
+// BitPositions, the bit at its position is set in the partition of the iteration, which is the partition
+// with the same index unless the filter was created WithPartitions. The Redis backend reads every
+// partition with a single GET like Dump, and values waiting in the queue are not included, so Save should
+// be called first.
+func (b *BF) Bytes() ([][]byte, error) {
+	partitions := b.partitions()
+	data := make([][]byte, len(partitions))
+	for index, f := range partitions {
+		packed, err := partitionBytes(f)
+		if err != nil {
+			return nil, &PartitionError{index, err}
+		}
+		data[index] = packed
+	}
+
+	return data, nil
+}
+
+// partitionBytes returns the bits of the storage of the partition filter packed into bytes.
+func partitionBytes(f filter) ([]byte, error) {
+	if d, ok := f.storage.(dumper); ok {
+		return d.dump(context.Background())
+	}
+
+	set, err := setBits(f)
+	if err != nil {
+		return nil, err
+	}
+
+	packed := make([]byte, (f.partitionBits+7)/8)
+	for _, bit := range set {
+		packed[bit/8] |= 0x80 >> (bit % 8)
+	}
+
+	return packed, nil
+}
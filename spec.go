@@ -0,0 +1,146 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Spec describes a bloom filter created by New, as a single configuration surface for the Bitset and Redis
+// constructors and their most common options. The filter is sized either for N values with a false
+// positive probability of P, like NewBitsetWithEstimate, or explicitly with Size and HashIter.
+type Spec struct {
+	// Backend is "bitset" or "redis". When empty, Redis is used if Pool is set, and Bitset otherwise.
+	Backend string
+
+	// N and P size the filter for N values with a false positive probability of P.
+	N uint
+	P float64
+	// Size and HashIter size the filter explicitly, instead of N and P.
+	Size     uint
+	HashIter uint
+
+	// Pool, Key and TTL are the pool of the Redis connections, the key the partitions are stored under and
+	// how long they live, in whole seconds, for the Redis backend. A zero TTL never expires.
+	Pool *redis.Pool
+	Key  string
+	TTL  time.Duration
+
+	// Hasher and Seed are set like WithHasher and WithSeed.
+	Hasher func() hash.Hash64
+	Seed   uint64
+	// Concurrent makes a Bitset backed filter safe for concurrent use, like WithConcurrency. Redis backed
+	// filters already are, so it doesn't change them.
+	Concurrent bool
+
+	// Options are applied after the ones derived from the other fields.
+	Options []Option
+}
+
+// SpecError is returned by New when a field of the Spec is invalid.
+type SpecError struct {
+	Field string
+	Err   error
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("bloom: invalid spec field %s: %v", e.Field, e.Err)
+}
+
+// Unwrap returns the reason the field is invalid, which is ErrInvalidParameters for the sizing fields.
+func (e *SpecError) Unwrap() error {
+	return e.Err
+}
+
+// New creates and returns a new bloom filter as described by the spec, with NewBitsetE or NewRedis. A
+// SpecError names the first invalid field, like a Redis backend without a Pool or Key, or one of the Redis
+// fields set for a Bitset backend, where it wouldn't apply. NewRedis should be used to know whether the
+// keys already existed.
+func New(spec Spec) (*BF, error) {
+	backend, err := spec.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	size, hashIter := spec.Size, spec.HashIter
+	if spec.N > 0 {
+		size, hashIter = estimateParameters(spec.N, spec.P)
+	}
+
+	opts := []Option{WithHasher(spec.Hasher), WithSeed(spec.Seed)}
+	if spec.Concurrent && backend == "bitset" {
+		opts = append(opts, WithConcurrency())
+	}
+	opts = append(opts, spec.Options...)
+
+	if backend == "redis" {
+		bloom, _, err := NewRedis(spec.Pool, spec.Key, size, hashIter, int64(spec.TTL/time.Second), opts...)
+		return bloom, err
+	}
+
+	return NewBitsetE(size, hashIter, opts...)
+}
+
+// validate checks the fields of the spec in order, returning the backend it selects.
+func (spec Spec) validate() (string, error) {
+	invalid := func(field string, format string, args ...interface{}) (string, error) {
+		return "", &SpecError{field, fmt.Errorf(format, args...)}
+	}
+	sizing := func(field string, format string, args ...interface{}) (string, error) {
+		return "", &SpecError{field, fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidParameters}, args...)...)}
+	}
+
+	backend := spec.Backend
+	switch {
+	case backend == "" && spec.Pool != nil:
+		backend = "redis"
+	case backend == "":
+		backend = "bitset"
+	case backend != "bitset" && backend != "redis":
+		return invalid("Backend", "unknown backend %q, expected bitset or redis", backend)
+	}
+
+	estimated, explicit := spec.N > 0 || spec.P != 0, spec.Size > 0 || spec.HashIter > 0
+	switch {
+	case estimated && explicit:
+		return sizing("Size", "set either N and P or Size and HashIter")
+	case !estimated && !explicit:
+		return sizing("N", "set either N and P or Size and HashIter")
+	case estimated && spec.N == 0:
+		return sizing("N", "must be positive")
+	case estimated && (spec.P <= 0 || spec.P >= 1):
+		return sizing("P", "must be between 0 and 1, got %v", spec.P)
+	case explicit && spec.Size == 0:
+		return sizing("Size", "must be positive")
+	case explicit && spec.HashIter == 0:
+		return sizing("HashIter", "must be positive")
+	}
+
+	if backend == "bitset" {
+		switch {
+		case spec.Pool != nil:
+			return invalid("Pool", "only applies to the redis backend")
+		case spec.Key != "":
+			return invalid("Key", "only applies to the redis backend")
+		case spec.TTL != 0:
+			return invalid("TTL", "only applies to the redis backend")
+		}
+		return backend, nil
+	}
+
+	switch {
+	case spec.Pool == nil:
+		return invalid("Pool", "is required by the redis backend")
+	case spec.Key == "":
+		return invalid("Key", "is required by the redis backend")
+	case spec.TTL < 0 || spec.TTL%time.Second != 0:
+		return invalid("TTL", "must be a whole number of seconds, got %v", spec.TTL)
+	}
+
+	return backend, nil
+}
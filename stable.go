@@ -0,0 +1,94 @@
+package bloom
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// stableFalsePositiveRate is the false positive probability NewStableBitset picks the number of
+// decremented cells for, once the filter reaches its stable point.
+const stableFalsePositiveRate = 0.01
+
+// StableBF is a bloom filter for unbounded streams of values, where old values age out as new ones are
+// added (Deng & Rafiei, Approximately Detecting Duplicates for Streaming Data using Stable Bloom Filters).
+// Every value sets its cells to the maximum value of a cell, after decrementing a few random cells, so the
+// fraction of empty cells converges to a stable point instead of the filter filling up.
+//
+// Unlike the other bloom filters, it has false negatives: the cells of a value can be decremented to zero
+// by the values added after it, in which case the value no longer exists. The more bits per cell, the
+// longer values are remembered, at the cost of memory.
+type StableBF struct {
+	filters []filter
+	cells   []uint8
+	max     uint8
+	p       uint
+	rand    *rand.Rand
+}
+
+// NewStableBitset creates and returns a new stable bloom filter of the given number of cells with d bits
+// each and k hash iterations, setting k cells of the filter per value. The number of cells decremented per
+// value is chosen for a 1% false positive probability at the stable point. The memory used stays the same
+// however many values are added. It panics if cells or k is zero, or d isn't between 1 and 8.
+func NewStableBitset(cells uint, d uint8, k uint, opts ...Option) *StableBF {
+	if err := validateParameters(cells, k); err != nil {
+		panic(err)
+	}
+	if d == 0 || d > 8 {
+		panic("bloom: cells of a stable bloom filter must have between 1 and 8 bits")
+	}
+
+	o := newOptions(append(opts, WithEnhancedDoubleHashing(), WithPartitions(1)))
+	max := uint8(1<<d - 1)
+
+	return &StableBF{
+		filters: filterSetup(cells, k, o),
+		cells:   make([]uint8, cells),
+		max:     max,
+		p:       stableDecrements(cells, k, max),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// stableDecrements returns the number of cells to decrement per value for the false positive probability
+// of the stable point to be stableFalsePositiveRate, solving the stable point equation of Deng & Rafiei.
+func stableDecrements(cells, k uint, max uint8) uint {
+	zeros := math.Pow(1-math.Pow(stableFalsePositiveRate, 1/float64(k)), 1/float64(max))
+	p := 1 / ((1/zeros - 1) * (1/float64(k) - 1/float64(cells)))
+	if p < 1 || math.IsNaN(p) || math.IsInf(p, 0) {
+		return 1
+	}
+
+	return uint(p)
+}
+
+// Add adds the given values. For every value, p consecutive cells starting at a random one are
+// decremented before the cells of the value are set to the maximum value.
+func (s *StableBF) Add(values ...Value) {
+	for _, value := range values {
+		start := uint(s.rand.Int63n(int64(len(s.cells))))
+		for i := uint(0); i < s.p; i++ {
+			if cell := &s.cells[(start+i)%uint(len(s.cells))]; *cell > 0 {
+				*cell--
+			}
+		}
+
+		for _, f := range s.filters {
+			a, b := f.hashValue(value)
+			s.cells[f.position(a, b)] = s.max
+		}
+	}
+}
+
+// Exists checks if the given value is in the stable bloom filter or not. Both false positives and false
+// negatives might occur.
+func (s *StableBF) Exists(value []byte) (bool, error) {
+	for _, f := range s.filters {
+		a, b := f.hashValue(value)
+		if s.cells[f.position(a, b)] == 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
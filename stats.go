@@ -0,0 +1,94 @@
+package bloom
+
+import "context"
+
+// Config holds the configuration of a bloom filter. Unlike Stats, it doesn't need to query the backend.
+type Config struct {
+	Size       uint   `json:"size"`
+	HashIter   uint   `json:"hash_iter"`
+	Partitions uint   `json:"partitions"`
+	Backend    string `json:"backend"`
+	KeyPrefix  string `json:"key_prefix,omitempty"`
+}
+
+// backendNamer is implemented by the storages to name their backend in Config.
+type backendNamer interface {
+	backend() string
+}
+
+// keyPrefixer is implemented by the storages whose keys are namespaced WithKeyPrefix.
+type keyPrefixer interface {
+	keyPrefix() string
+}
+
+// Stats holds metadata about a bloom filter and how full it is.
+type Stats struct {
+	Partitions       uint    `json:"partitions"`
+	BitsPerPartition uint    `json:"bits_per_partition"`
+	TotalBits        uint    `json:"total_bits"`
+	HashIterations   uint    `json:"hash_iterations"`
+	SetBits          uint    `json:"set_bits"`
+	FillRatio        float64 `json:"fill_ratio"`
+	EstimatedFPRate  float64 `json:"estimated_fp_rate"`
+}
+
+// Stats returns metadata about the bloom filter. Errors from the backend are ignored, leaving the set
+// bits and the ratios derived from them at zero, so StatsContext should be used for the Redis backend.
+func (b *BF) Stats() Stats {
+	stats, _ := b.StatsContext(context.Background())
+
+	return stats
+}
+
+// StatsContext returns metadata about the bloom filter, counting the set bits of every partition filter.
+// The Redis backend pipelines a BITCOUNT per partition and returns ctx.Err() once the context is done.
+func (b *BF) StatsContext(ctx context.Context) (Stats, error) {
+	var stats Stats
+	stats.TotalBits, stats.HashIterations = b.Parameters()
+	stats.Partitions = uint(len(b.partitions()))
+	if len(b.filters) > 0 {
+		stats.BitsPerPartition = b.filters[0].partitionBits
+	}
+
+	counts, err := b.counts(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for _, count := range counts {
+		stats.SetBits += count
+	}
+
+	if stats.TotalBits > 0 {
+		stats.FillRatio = float64(stats.SetBits) / float64(stats.TotalBits)
+	}
+
+	if len(b.filters) > 0 {
+		stats.EstimatedFPRate = 1
+		for _, f := range b.filters {
+			stats.EstimatedFPRate *= float64(counts[f.partition]) / float64(f.partitionBits)
+		}
+	}
+
+	return stats, nil
+}
+
+// Config returns the configuration of the bloom filter without querying the backend. Backend is "bitset",
+// "atomic", "counting", "mmap", "file", "redis" or "tiered", or "custom" for other storages. KeyPrefix is
+// the prefix of the keys of a Redis backed filter created WithKeyPrefix, which it has to be reopened with
+// to use the same keys.
+func (b *BF) Config() Config {
+	size, hashIter := b.Parameters()
+
+	backend := "custom"
+	var prefix string
+	if len(b.filters) > 0 {
+		if namer, ok := b.filters[0].storage.(backendNamer); ok {
+			backend = namer.backend()
+		}
+		if prefixer, ok := b.filters[0].storage.(keyPrefixer); ok {
+			prefix = prefixer.keyPrefix()
+		}
+	}
+
+	return Config{size, hashIter, uint(len(b.partitions())), backend, prefix}
+}
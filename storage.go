@@ -1,8 +1,284 @@
 package bloom
 
+import (
+	"context"
+	"sort"
+)
+
 // storage is an interface every bloom filter backend storage needs to implement.
 type storage interface {
 	Append(uint)
-	Save()
+	Save() error
 	Exists(uint) (bool, error)
+	ExistsMany([]uint) ([]bool, error)
+	Count() (uint, error)
+	Clear() error
+}
+
+// contextStorage is implemented by backend storages whose I/O can be bound to a context.Context.
+type contextStorage interface {
+	SaveContext(context.Context) error
+	ExistsContext(context.Context, uint) (bool, error)
+	ExistsManyContext(context.Context, []uint) ([]bool, error)
+	CountContext(context.Context) (uint, error)
+}
+
+// saveContext saves the storage, passing the context on if the storage supports it.
+func saveContext(ctx context.Context, s storage) error {
+	if cs, ok := s.(contextStorage); ok {
+		return cs.SaveContext(ctx)
+	}
+
+	return s.Save()
+}
+
+// existsContext checks the bit in the storage, passing the context on if the storage supports it.
+func existsContext(ctx context.Context, s storage, bit uint) (bool, error) {
+	if cs, ok := s.(contextStorage); ok {
+		return cs.ExistsContext(ctx, bit)
+	}
+
+	return s.Exists(bit)
+}
+
+// existsManyContext checks the bits in the storage, passing the context on if the storage supports it.
+func existsManyContext(ctx context.Context, s storage, bits []uint) ([]bool, error) {
+	if cs, ok := s.(contextStorage); ok {
+		return cs.ExistsManyContext(ctx, bits)
+	}
+
+	return s.ExistsMany(bits)
+}
+
+// countContext counts the bits set in the storage, passing the context on if the storage supports it.
+func countContext(ctx context.Context, s storage) (uint, error) {
+	if cs, ok := s.(contextStorage); ok {
+		return cs.CountContext(ctx)
+	}
+
+	return s.Count()
+}
+
+// closer is implemented by the storages holding resources to release when the bloom filter is closed.
+// Closing them again doesn't do anything.
+type closer interface {
+	close() error
+}
+
+// syncer is implemented by the storages whose saved bits only become durable once synced, like files and
+// memory mapped files, syncing the data shared by every partition of the bloom filter at once.
+type syncer interface {
+	sync() error
+}
+
+// syncPartitions syncs the data of the partitions once they're all saved, if they're syncers.
+func syncPartitions(partitions []filter) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	if s, ok := partitions[0].storage.(syncer); ok {
+		return s.sync()
+	}
+
+	return nil
+}
+
+// discarder is implemented by the storages queuing the bits appended to them until they're saved.
+type discarder interface {
+	discard()
+}
+
+// pipelinedCounter is implemented by the storages that can count the bits of several storages at once,
+// like Redis backends sharing their connections, which pipeline their BITCOUNTs over a single connection.
+type pipelinedCounter interface {
+	// countGroup identifies the storages that can be counted together.
+	countGroup() interface{}
+	// countMany counts the bits set in every storage of the group.
+	countMany(ctx context.Context, stores []storage) ([]uint, error)
+}
+
+// pipelinedEmptyChecker is implemented by the pipelinedCounter storages that can tell whether any bit of
+// several storages of their group is set, without counting them.
+type pipelinedEmptyChecker interface {
+	pipelinedCounter
+	// anySet reports whether a bit is set in any storage of the group.
+	anySet(ctx context.Context, stores []storage) (bool, error)
+}
+
+// pipelinedChecker is implemented by the pipelinedCounter storages that can check bits of several storages
+// of their group at once, like Redis backends pipelining their GETBITs over a single connection.
+type pipelinedChecker interface {
+	pipelinedCounter
+	// existsAcross checks bits[i] in stores[i] for every i.
+	existsAcross(ctx context.Context, stores []storage, bits []uint) ([]bool, error)
+}
+
+// checkGroup returns the storage checking the bits of all the filters at once, if their storages are
+// pipelinedCheckers of a single group.
+func checkGroup(filters []filter) (pipelinedChecker, bool) {
+	if len(filters) == 0 {
+		return nil, false
+	}
+
+	first, ok := filters[0].storage.(pipelinedChecker)
+	if !ok {
+		return nil, false
+	}
+	for _, f := range filters[1:] {
+		pc, ok := f.storage.(pipelinedChecker)
+		if !ok || pc.countGroup() != first.countGroup() {
+			return nil, false
+		}
+	}
+
+	return first, true
+}
+
+// atomicAdder is implemented by the pipelinedChecker storages which can set bits in several storages of
+// their group atomically, like Redis backends with a Lua script.
+type atomicAdder interface {
+	pipelinedChecker
+	// addAcross sets bits[i] in each of the storages stores[i], and reports whether all of them were
+	// already set.
+	addAcross(ctx context.Context, stores []storage, bits []uint) (bool, error)
+}
+
+// destroyer is implemented by the pipelinedCounter storages whose data outlives the bloom filter, like
+// Redis backends, which delete the keys of several storages of their group at once.
+type destroyer interface {
+	pipelinedCounter
+	// destroyMany deletes the data of every storage of the group.
+	destroyMany(ctx context.Context, stores []storage) error
+}
+
+// destroyedStorage replaces the storages of a destroyed bloom filter, failing with ErrDestroyed.
+type destroyedStorage struct{}
+
+func (destroyedStorage) Append(uint) {}
+
+func (destroyedStorage) Save() error {
+	return ErrDestroyed
+}
+
+func (destroyedStorage) Exists(uint) (bool, error) {
+	return false, ErrDestroyed
+}
+
+func (destroyedStorage) ExistsMany([]uint) ([]bool, error) {
+	return nil, ErrDestroyed
+}
+
+func (destroyedStorage) Count() (uint, error) {
+	return 0, ErrDestroyed
+}
+
+func (destroyedStorage) Clear() error {
+	return ErrDestroyed
+}
+
+// countAll counts the bits set in each of the storages, counting the storages of a pipelinedCounter
+// group together.
+func countAll(ctx context.Context, stores []storage) ([]uint, error) {
+	counts := make([]uint, len(stores))
+
+	single, groups := groupStores(stores)
+	for _, index := range single {
+		count, err := countContext(ctx, stores[index])
+		if err != nil {
+			return nil, &PartitionError{index, err}
+		}
+		counts[index] = count
+	}
+
+	for _, indexes := range groups {
+		grouped := make([]storage, len(indexes))
+		for i, index := range indexes {
+			grouped[i] = stores[index]
+		}
+
+		found, err := grouped[0].(pipelinedCounter).countMany(ctx, grouped)
+		if err != nil {
+			return nil, &PartitionError{indexes[0], err}
+		}
+		for i, index := range indexes {
+			counts[index] = found[i]
+		}
+	}
+
+	return counts, nil
+}
+
+// allEmpty reports whether no bit is set in any of the storages, checking the storages of a
+// pipelinedEmptyChecker group together and stopping at the first storage with a bit set.
+func allEmpty(ctx context.Context, stores []storage) (bool, error) {
+	single, groups := groupStores(stores)
+	for _, indexes := range groups {
+		if _, ok := stores[indexes[0]].(pipelinedEmptyChecker); !ok {
+			single = append(single, indexes...)
+			continue
+		}
+
+		grouped := make([]storage, len(indexes))
+		for i, index := range indexes {
+			grouped[i] = stores[index]
+		}
+
+		set, err := grouped[0].(pipelinedEmptyChecker).anySet(ctx, grouped)
+		if err != nil {
+			return false, &PartitionError{indexes[0], err}
+		}
+		if set {
+			return false, nil
+		}
+	}
+
+	for _, index := range single {
+		count, err := countContext(ctx, stores[index])
+		if err != nil {
+			return false, &PartitionError{index, err}
+		}
+		if count > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// groupStores returns the indexes of the storages that aren't a pipelinedCounter, and the indexes of the
+// others grouped by their countGroup, in order.
+func groupStores(stores []storage) (single []int, groups [][]int) {
+	positions := make(map[interface{}]int)
+	for index, s := range stores {
+		pc, ok := s.(pipelinedCounter)
+		if !ok {
+			single = append(single, index)
+			continue
+		}
+
+		group := pc.countGroup()
+		position, ok := positions[group]
+		if !ok {
+			position = len(groups)
+			positions[group] = position
+			groups = append(groups, nil)
+		}
+		groups[position] = append(groups[position], index)
+	}
+
+	return
+}
+
+// uniqueBits sorts the bits in place and returns them without duplicates.
+func uniqueBits(bits []uint) []uint {
+	sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+
+	unique := bits[:0]
+	for i, bit := range bits {
+		if i == 0 || bit != bits[i-1] {
+			unique = append(unique, bit)
+		}
+	}
+
+	return unique
 }
@@ -0,0 +1,82 @@
+package bloom
+
+import "context"
+
+// defaultStreamBatch is the largest number of values ExistsStream checks at once.
+const defaultStreamBatch = 64
+
+// Result is the outcome of checking a value received by ExistsStream.
+type Result struct {
+	Value  Value
+	Exists bool
+	Err    error
+}
+
+// ExistsStream checks every value received from in, sending a Result for each of them in order on the
+// returned channel, which is closed once in is closed. Values already waiting in the channel are checked
+// together, up to 64 at a time, like with Exist, so the Redis backend needs a pipelined round trip for
+// every batch instead of for every value. A value is never held back waiting for more to
+// arrive. When checking a batch fails, every value of the batch gets the error and the stream goes on.
+func (b *BF) ExistsStream(in <-chan Value) <-chan Result {
+	return b.ExistsStreamContext(context.Background(), in, defaultStreamBatch)
+}
+
+// ExistsStreamContext is like ExistsStream, but checks up to batch values at a time, or 64 if batch isn't
+// positive, and stops once the context is done, closing the returned channel without reading in any
+// further.
+func (b *BF) ExistsStreamContext(ctx context.Context, in <-chan Value, batch int) <-chan Result {
+	if batch <= 0 {
+		batch = defaultStreamBatch
+	}
+
+	out := make(chan Result, batch)
+	go func() {
+		defer close(out)
+
+		values := make([]Value, 0, batch)
+		for {
+			values = values[:0]
+			select {
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+				values = append(values, value)
+			case <-ctx.Done():
+				return
+			}
+
+		drain:
+			for len(values) < batch {
+				select {
+				case value, ok := <-in:
+					if !ok {
+						break drain
+					}
+					values = append(values, value)
+				default:
+					break drain
+				}
+			}
+
+			exists, err := b.ExistContext(ctx, values...)
+			if ctx.Err() != nil {
+				return
+			}
+			for index, value := range values {
+				result := Result{value, false, err}
+				if err == nil {
+					result.Exists = exists[index]
+				}
+
+				select {
+				case out <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,84 @@
+package bloom
+
+import (
+	"context"
+	"io"
+)
+
+// AddString is used to append string values to the queue. It behaves exactly like Add with the strings
+// converted to byte slices, but the default hasher hashes the strings without copying them, as do hashers
+// implementing io.StringWriter.
+func (b *BF) AddString(values ...string) {
+
+	for _, value := range values {
+		for _, f := range b.filters {
+			a, b := f.hashString(value)
+			f.storage.Append(f.position(a, b))
+		}
+	}
+	b.observeAdd(len(values))
+}
+
+// ExistsString checks if the given string is in the bloom filter or not, like Has. False positives might
+// occur. It's ExistString with a single string, but with the default hasher it doesn't allocate unless the
+// partitions are checked in a single pipeline, like with the Redis backend.
+func (b *BF) ExistsString(value string) (exists bool, err error) {
+	if _, ok := checkGroup(b.filters); ok || len(b.filters) == 0 {
+		found, err := b.ExistString(value)
+		if err != nil {
+			return false, err
+		}
+		return found[0], nil
+	}
+
+	a, h := b.filters[0].hashString(value)
+	for _, f := range b.filters {
+		exists, err = f.storage.Exists(f.position(a, h))
+		if !exists {
+			break
+		}
+	}
+
+	if err == nil && b.observer != nil {
+		b.observer.IncQuery(exists)
+	}
+	return
+}
+
+// ExistString checks if the given strings are in the bloom filter or not, like Exist. False positives
+// might occur.
+func (b *BF) ExistString(values ...string) (exists []bool, err error) {
+	hashes := make([][2]uint, len(values))
+	for index, value := range values {
+		if len(b.filters) > 0 {
+			hashes[index][0], hashes[index][1] = b.filters[0].hashString(value)
+		}
+	}
+
+	exists, err = b.existHashes(context.Background(), hashes)
+	b.observeQueries(err, exists...)
+
+	return
+}
+
+// hashString takes care of hashing the string that is being stored in the bloom filter. The default
+// hasher is computed inline like with hashUint64, since io.WriteString copies the string for hashers not
+// implementing io.StringWriter.
+func (f *filter) hashString(value string) (a, b uint) {
+	if f.hasher != nil {
+		hasher := f.newHasher()
+		io.WriteString(hasher, value)
+		return f.hashSum(hasher)
+	}
+
+	sum := uint64(fnvOffset64)
+	if f.seed != 0 {
+		sum = fnv1(sum, f.seed)
+	}
+	for i := 0; i < len(value); i++ {
+		sum *= fnvPrime64
+		sum ^= uint64(value[i])
+	}
+
+	return f.splitHash(sum)
+}
@@ -0,0 +1,174 @@
+//go:build !noredis
+// +build !noredis
+
+package bloom
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// TieredBF is a bloom filter keeping its bits both in a local Bitset, which is authoritative for the
+// values this process added, and in Redis, where they're shared with the other processes. Values are
+// checked in Redis, falling back to the local Bitset while Redis can't be reached, so the filter keeps
+// answering for the values of this process instead of failing.
+//
+// The fallback trades consistency for availability. While degraded, values added by other processes
+// don't exist, and values added by this process are only in the local Bitset: their bits stay queued
+// for Redis and are sent again by every Save until one succeeds, so other processes only see them once
+// Redis is back. The local Bitset is never filled from Redis, so values added by other processes are
+// never seen through the fallback, and it lives as long as the process, without the Redis expiration.
+type TieredBF struct {
+	BF
+}
+
+// NewTiered creates and returns a new tiered bloom filter using Bitset and Redis as backends, with the
+// same Redis keys and expiration as NewRedis. Redis is queried when the filter is created, so it needs to
+// be reachable then, and the returned bool reports whether the keys already existed.
+func NewTiered(pool *redis.Pool, key string, size, hashIter uint, expiredAfterSeconds int64, opts ...Option) (*TieredBF, bool, error) {
+	remote, exist, err := NewRedis(pool, key, size, hashIter, expiredAfterSeconds, opts...)
+	if err != nil {
+		return nil, exist, err
+	}
+
+	for index, f := range remote.filters {
+		if f.partition != index {
+			f.storage = remote.filters[f.partition].storage
+		} else {
+			f.storage = &tieredStorage{NewBitsetStorage(f.partitionBits), f.storage.(*RedisStorage), 0}
+		}
+		remote.filters[index] = f
+	}
+
+	return &TieredBF{*remote}, exist, nil
+}
+
+// Degraded reports whether Redis failed the last time any partition used it, in which case the filter
+// answers from the local Bitset.
+func (t *TieredBF) Degraded() bool {
+	for _, f := range t.partitions() {
+		if atomic.LoadInt32(&f.storage.(*tieredStorage).degraded) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tieredStorage is the storage of a partition of a TieredBF, writing to both backends and reading from
+// the local one when Redis fails.
+type tieredStorage struct {
+	local    *BitsetStorage
+	remote   *RedisStorage
+	degraded int32
+}
+
+// fallback records whether Redis failed, and reports whether it did. The local Bitset is only used when
+// the context isn't done, otherwise ctx.Err() is returned like with the Redis backend.
+func (s *tieredStorage) fallback(err error) bool {
+	if err != nil {
+		atomic.StoreInt32(&s.degraded, 1)
+		return true
+	}
+
+	atomic.StoreInt32(&s.degraded, 0)
+	return false
+}
+
+func (s *tieredStorage) Append(bit uint) {
+	s.local.Append(bit)
+	s.remote.Append(bit)
+}
+
+func (s *tieredStorage) Save() error {
+	return s.SaveContext(context.Background())
+}
+
+// SaveContext saves the bits to the local Bitset, then to Redis. A Redis failure only degrades the
+// storage, keeping the bits queued for the next Save, unless the context is done.
+func (s *tieredStorage) SaveContext(ctx context.Context) error {
+	if err := s.local.Save(); err != nil {
+		return err
+	}
+
+	err := s.remote.SaveContext(ctx)
+	if s.fallback(err) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+func (s *tieredStorage) Exists(bit uint) (bool, error) {
+	return s.ExistsContext(context.Background(), bit)
+}
+
+func (s *tieredStorage) ExistsContext(ctx context.Context, bit uint) (bool, error) {
+	exists, err := s.remote.ExistsContext(ctx, bit)
+	if s.fallback(err) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return s.local.Exists(bit)
+	}
+
+	return exists, nil
+}
+
+func (s *tieredStorage) ExistsMany(bits []uint) ([]bool, error) {
+	return s.ExistsManyContext(context.Background(), bits)
+}
+
+func (s *tieredStorage) ExistsManyContext(ctx context.Context, bits []uint) ([]bool, error) {
+	exists, err := s.remote.ExistsManyContext(ctx, bits)
+	if s.fallback(err) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return s.local.ExistsMany(bits)
+	}
+
+	return exists, nil
+}
+
+func (s *tieredStorage) Count() (uint, error) {
+	return s.CountContext(context.Background())
+}
+
+func (s *tieredStorage) CountContext(ctx context.Context) (uint, error) {
+	count, err := s.remote.CountContext(ctx)
+	if s.fallback(err) {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return s.local.Count()
+	}
+
+	return count, nil
+}
+
+// Clear clears both backends. Unlike the other operations, a Redis failure is returned, since the values
+// would still exist for the other processes.
+func (s *tieredStorage) Clear() error {
+	if err := s.local.Clear(); err != nil {
+		return err
+	}
+
+	err := s.remote.Clear()
+	s.fallback(err)
+
+	return err
+}
+
+// discard empties the queues of both backends.
+func (s *tieredStorage) discard() {
+	s.local.discard()
+	s.remote.discard()
+}
+
+// backend names the tiered backend in Config.
+func (s *tieredStorage) backend() string {
+	return "tiered"
+}
@@ -0,0 +1,129 @@
+package bloom
+
+import (
+	"sync"
+	"time"
+)
+
+// TimedBF is a bloom filter whose values expire some time after they were added, rather than the whole
+// filter expiring at once. The window is split into time buckets, each with its own bloom filter: values
+// are added to the filter of the current bucket, checked against the filters of all the buckets, and
+// the oldest bucket is cleared whenever a new one starts.
+//
+// It approximates a per value time to live with the granularity of a bucket: a value exists for at least
+// window - window/buckets and at most window after it was added, depending on how far into its bucket it
+// was added. More buckets expire values more precisely, but every bucket is a full filter that's checked
+// for every value. It's safe for concurrent use.
+type TimedBF struct {
+	filters []*BF
+	bucket  time.Duration
+	start   time.Time
+	epoch   int64
+	now     func() time.Time
+
+	mu   sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewTimedBitset creates and returns a new timed bloom filter using Bitset backed filters of the given
+// size and hash iterations for each of the buckets, so values expire after the window. A goroutine
+// clears the expired buckets in the background until Stop is called. It panics if size or hashIter is
+// zero, if window isn't positive or if there are fewer than 1 or more buckets than nanoseconds in the
+// window.
+func NewTimedBitset(window time.Duration, buckets int, size, hashIter uint, opts ...Option) *TimedBF {
+	t := newTimedBitset(window, buckets, size, hashIter, time.Now, opts...)
+	go t.rotateEvery(t.bucket)
+
+	return t
+}
+
+// newTimedBitset creates the timed bloom filter, using now as its clock, without rotating it in the
+// background.
+func newTimedBitset(window time.Duration, buckets int, size, hashIter uint, now func() time.Time, opts ...Option) *TimedBF {
+	if buckets < 1 || window < time.Duration(buckets) {
+		panic("bloom: a timed bloom filter needs at least one bucket and a window of a nanosecond per bucket")
+	}
+
+	filters := make([]*BF, buckets)
+	for index := range filters {
+		filters[index] = NewBitset(size, hashIter, opts...)
+	}
+
+	return &TimedBF{
+		filters: filters,
+		bucket:  window / time.Duration(buckets),
+		start:   now(),
+		now:     now,
+		stop:    make(chan struct{}),
+	}
+}
+
+// rotateEvery rotates the buckets at every interval, until the filter is stopped.
+func (t *TimedBF) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			t.rotate()
+			t.mu.Unlock()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// rotate clears the buckets that started since the last rotation, which hold expired values, and makes
+// the bucket of the current time the one values are added to. The caller holds the lock.
+func (t *TimedBF) rotate() {
+	epoch := int64(t.now().Sub(t.start) / t.bucket)
+	if epoch <= t.epoch {
+		return
+	}
+
+	elapsed := epoch - t.epoch
+	if elapsed > int64(len(t.filters)) {
+		elapsed = int64(len(t.filters))
+	}
+	for i := int64(1); i <= elapsed; i++ {
+		t.filters[(t.epoch+i)%int64(len(t.filters))].Clear()
+	}
+	t.epoch = epoch
+}
+
+// Add adds and saves the given values in the bucket of the current time.
+func (t *TimedBF) Add(values ...Value) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	current := t.filters[t.epoch%int64(len(t.filters))]
+	current.Add(values...)
+	current.Save()
+}
+
+// Exists checks if the given value was added to the timed bloom filter within the window. False
+// positives might occur.
+func (t *TimedBF) Exists(value []byte) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	for _, f := range t.filters {
+		exists, err := f.Has(value)
+		if exists || err != nil {
+			return exists, err
+		}
+	}
+
+	return false, nil
+}
+
+// Stop stops the background rotation of the buckets. Expired values still don't exist once it's
+// stopped, the buckets are then cleared when values are added or checked.
+func (t *TimedBF) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
@@ -0,0 +1,49 @@
+package bloom
+
+import "time"
+
+// expirer is implemented by the storages whose data can expire.
+type expirer interface {
+	ttl() (time.Duration, error)
+	persist() error
+}
+
+// TTL returns the shortest remaining time to live of the partition filters, since the bloom filter is
+// only valid while all of them exist. A negative duration is returned if none of them expire. Only the
+// Redis backend supports expiration; other backends return ErrUnsupportedBackend.
+func (b *BF) TTL() (time.Duration, error) {
+	shortest := time.Duration(-1)
+	for index, f := range b.partitions() {
+		e, ok := f.storage.(expirer)
+		if !ok {
+			return 0, ErrUnsupportedBackend
+		}
+
+		ttl, err := e.ttl()
+		if err != nil {
+			return 0, &PartitionError{index, err}
+		}
+		if ttl >= 0 && (shortest < 0 || ttl < shortest) {
+			shortest = ttl
+		}
+	}
+
+	return shortest, nil
+}
+
+// Persist removes the expiration of every partition filter, so the bloom filter is kept until it's
+// deleted. Only the Redis backend supports expiration; other backends return ErrUnsupportedBackend.
+func (b *BF) Persist() error {
+	for index, f := range b.partitions() {
+		e, ok := f.storage.(expirer)
+		if !ok {
+			return ErrUnsupportedBackend
+		}
+
+		if err := e.persist(); err != nil {
+			return &PartitionError{index, err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package bloom
+
+// Filter is a bloom filter of values of type T, which are encoded to bytes by the encoder it's created
+// with. Values that are equal need to be encoded to the same bytes, so encoders shouldn't depend on map
+// iteration order or pointers.
+type Filter[T any] struct {
+	bf  *BF
+	enc func(T) []byte
+}
+
+// NewBitsetFilter creates and returns a new bloom filter of values of type T using Bitset as a backend,
+// encoding the values with enc. It panics if size or hashIter is zero.
+func NewBitsetFilter[T any](size, hashIter uint, enc func(T) []byte, opts ...Option) *Filter[T] {
+	return NewFilter(NewBitset(size, hashIter, opts...), enc)
+}
+
+// NewFilter wraps the bloom filter into a bloom filter of values of type T, encoding the values with enc.
+func NewFilter[T any](bf *BF, enc func(T) []byte) *Filter[T] {
+	return &Filter[T]{bf, enc}
+}
+
+// Add is used to append values to the queue.
+func (f *Filter[T]) Add(values ...T) {
+	for _, value := range values {
+		f.bf.Add(f.enc(value))
+	}
+}
+
+// Save saves the values from the queue, like BF.Save.
+func (f *Filter[T]) Save() error {
+	return f.bf.Save()
+}
+
+// Exists checks if the given value is in the bloom filter or not. False positives might occur.
+func (f *Filter[T]) Exists(value T) (bool, error) {
+	return f.bf.Has(f.enc(value))
+}
+
+// BF returns the underlying bloom filter.
+func (f *Filter[T]) BF() *BF {
+	return f.bf
+}
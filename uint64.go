@@ -0,0 +1,100 @@
+package bloom
+
+import "encoding/binary"
+
+// FNV-1 parameters, for hashing integers without creating a hasher.
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// AddUint64 is used to append integer values to the queue. An integer is hashed as its 8 big endian
+// bytes, so it's the same value as Value of those bytes. With the default hasher, integers are hashed
+// without allocating.
+func (b *BF) AddUint64(ids ...uint64) {
+
+	for _, id := range ids {
+		for _, f := range b.filters {
+			a, b := f.hashUint64(id)
+			f.storage.Append(f.position(a, b))
+		}
+	}
+	b.observeAdd(len(ids))
+}
+
+// ExistsUint64 checks if the given integer is in the bloom filter or not. False positives might occur.
+func (b *BF) ExistsUint64(id uint64) (exists bool, err error) {
+	for _, f := range b.filters {
+		a, b := f.hashUint64(id)
+		exists, err = f.storage.Exists(f.position(a, b))
+		if !exists {
+			break
+		}
+	}
+
+	if err == nil && len(b.filters) > 0 && b.observer != nil {
+		b.observer.IncQuery(exists)
+	}
+	return
+}
+
+// AddHash is used to append values hashed elsewhere to the queue, using the 64 bit hash of every value
+// instead of hashing it again. The hash is split into two 32 bit values like the sum of the hasher, so
+// it has to be uniformly distributed over all 64 bits, otherwise the bits of the values cluster and false
+// positives rise. The seed and hasher of the bloom filter aren't applied to it.
+func (b *BF) AddHash(hashes ...uint64) {
+
+	for _, h := range hashes {
+		for _, f := range b.filters {
+			f.storage.Append(f.position(f.splitHash(h)))
+		}
+	}
+	b.observeAdd(len(hashes))
+}
+
+// ExistsHash checks if the value with the given 64 bit hash is in the bloom filter or not, like AddHash.
+// False positives might occur.
+func (b *BF) ExistsHash(h uint64) (exists bool, err error) {
+	for _, f := range b.filters {
+		exists, err = f.storage.Exists(f.position(f.splitHash(h)))
+		if !exists {
+			break
+		}
+	}
+
+	if err == nil && len(b.filters) > 0 && b.observer != nil {
+		b.observer.IncQuery(exists)
+	}
+	return
+}
+
+// hashUint64 takes care of hashing the integer that is being stored in the bloom filter. The default
+// hasher is computed inline, since creating a hasher and calling Sum both allocate.
+func (f *filter) hashUint64(id uint64) (a, b uint) {
+	if f.hasher != nil {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], id)
+
+		hasher := f.newHasher()
+		hasher.Write(buf[:])
+		return f.hashSum(hasher)
+	}
+
+	sum := uint64(fnvOffset64)
+	if f.seed != 0 {
+		sum = fnv1(sum, f.seed)
+	}
+	sum = fnv1(sum, id)
+
+	return f.splitHash(sum)
+}
+
+// fnv1 continues an FNV-1 hash with the 8 big endian bytes of v.
+func fnv1(sum, v uint64) uint64 {
+	for shift := 56; shift >= 0; shift -= 8 {
+		sum *= fnvPrime64
+		sum ^= (v >> uint(shift)) & 0xff
+	}
+
+	return sum
+}
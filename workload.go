@@ -0,0 +1,33 @@
+package bloom
+
+import "math/rand"
+
+// workloadKeyBytes is the length of the keys returned by GenerateKeys.
+const workloadKeyBytes = 16
+
+// GenerateKeys returns n random keys of 16 bytes, generated from the seed, for benchmarking bloom filter
+// configurations on a reproducible workload: the same seed always returns the same keys, on every
+// platform and Go version, since math/rand keeps the sequence of its sources. Keys generated from
+// different seeds are distinct but for a negligible chance, so they can measure the false positive rate
+// of a filter holding the keys of another seed. The keys share a single allocation.
+func GenerateKeys(n int, seed int64) []Value {
+	if n <= 0 {
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	data := make([]byte, n*workloadKeyBytes)
+	for i := 0; i < len(data); i += 8 {
+		word := r.Uint64()
+		for j := 0; j < 8; j++ {
+			data[i+j] = byte(word >> (8 * j))
+		}
+	}
+
+	keys := make([]Value, n)
+	for i := range keys {
+		keys[i] = Value(data[i*workloadKeyBytes : (i+1)*workloadKeyBytes : (i+1)*workloadKeyBytes])
+	}
+
+	return keys
+}